@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"bike_race/auth"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kauth"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+func DeleteUserCommand(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "DeleteUserCommand"), slog.String("userId", userId.String()))
+	logger.Info("deleting user")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	err := currentUser.Moderate(userId)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	medicalCertificates, err := deleteUser(ctx, conn, userId)
+	if errors.Is(err, ErrUserIsOrganizer) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+	for _, medicalCertificate := range medicalCertificates {
+		err = medicalCertificate.Delete()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	logger.Info("user deleted", slog.Int("medicalCertificates", len(medicalCertificates)))
+	return http.StatusOK, nil
+}
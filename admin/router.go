@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"bike_race/auth"
+	"bike_race/race"
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kauth"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+func Router(conn *pgxpool.Pool) *chi.Mux {
+	router := chi.NewRouter()
+
+	router.Post("/users/{userId}/disable", adminCommandRoute(conn, "userId", "/admin/users", auth.DisableUserCommand))
+	router.Post("/users/{userId}/enable", adminCommandRoute(conn, "userId", "/admin/users", auth.EnableUserCommand))
+	router.Post("/users/{userId}/delete", adminCommandRoute(conn, "userId", "/admin/users", DeleteUserCommand))
+	router.Post("/races/{raceId}/unpublish", adminCommandRoute(conn, "raceId", "/admin/races", race.UnpublishRaceCommand))
+	router.Post("/races/{raceId}/delete", adminCommandRoute(conn, "raceId", "/admin/races", race.DeleteRaceCommand))
+
+	router.Get("/users", viewUsersRoute(conn))
+	router.Get("/races", viewRacesRoute(conn))
+
+	return router
+}
+
+func viewUsersRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		login := auth.LoginFromContext(ctx)
+		if !login.Ok {
+			kauth.Unauthorized(w, auth.ErrNotAuthenticated)
+			return
+		}
+		search := r.URL.Query().Get("q")
+		users, code, err := auth.UserListQuery(ctx, conn, search)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		page := UsersPage(login, users, search)
+		kcore.RenderPage(ctx, page, w)
+	}
+}
+
+func viewRacesRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		login := auth.LoginFromContext(ctx)
+		if !login.Ok {
+			kauth.Unauthorized(w, auth.ErrNotAuthenticated)
+			return
+		}
+		races, code, err := race.AdminRaceListQuery(ctx, conn)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		page := RacesPage(login, races)
+		kcore.RenderPage(ctx, page, w)
+	}
+}
+
+// adminCommandRoute serves the commands that only need the id of the moderated user or race
+func adminCommandRoute(conn *pgxpool.Pool, param string, redirectUrl string, command func(context.Context, *pgxpool.Pool, kcore.ID) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := kcore.ParseID(chi.URLParam(r, param))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing "+param)
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := command(ctx, conn, id)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrUserIsOrganizer = errors.New("user still organizes races")
+)
+
+// deleteUser removes the user with their registrations, the organizers must hand over their races first.
+// The medical certificates are returned to be deleted once the transaction is committed.
+func deleteUser(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID) ([]kcore.File, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	var isOrganizer bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM race_organizers WHERE user_id = $1)`, userId).Scan(&isOrganizer)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_organizers table")
+	}
+	if isOrganizer {
+		return nil, ErrUserIsOrganizer
+	}
+	rows, err := tx.Query(ctx, `
+	SELECT medical_certificate FROM race_registrations WHERE user_id = $1 AND medical_certificate IS NOT NULL
+	`, userId)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_registrations table")
+	}
+	medicalCertificates, err := pgx.CollectRows(rows, pgx.RowTo[kcore.File])
+	if err != nil {
+		return nil, kcore.Wrap(err, "error scanning race_registrations table")
+	}
+	// Races loaded before the deletion must not write the registrations back
+	_, err = tx.Exec(ctx, `
+	UPDATE races SET version = version + 1 WHERE id IN (SELECT race_id FROM race_registrations WHERE user_id = $1)
+	`, userId)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error updating races table")
+	}
+	for _, table := range []string{"api_tokens", "email_verification_tokens", "notifications", "password_reset_tokens", "race_organizer_invitations", "race_results", "race_registrations", "sessions", "pending_logins", "totp_recovery_codes", "user_identities", "users"} {
+		column := "user_id"
+		if table == "users" {
+			column = "id"
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM "+table+" WHERE "+column+" = $1", userId)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error deleting "+table+" table")
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error committing transaction")
+	}
+	return medicalCertificates, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bike_race/auth"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+var (
+	ErrAuthorizationInvalid = errors.New("authorization header must be a bearer token")
+)
+
+// recoverMiddleware answers panics with a problem, instead of the plain text of kcore.RecoverMiddleware
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rcv := recover(); rcv != nil {
+				if err, ok := rcv.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(rcv)
+				}
+				writeProblem(w, r, http.StatusInternalServerError, fmt.Errorf("panic: %v", rcv))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerAuthMiddleware replaces the cookie user with the owner of the API token, when there is one.
+// It only applies to the API, so that tokens can not reach the pages that manage the account,
+// and the API commands that manage the account refuse token users whatever their scope.
+func bearerAuthMiddleware(conn *pgxpool.Pool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			authorization := r.Header.Get("Authorization")
+			if authorization == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			secret, ok := strings.CutPrefix(authorization, "Bearer ")
+			if !ok {
+				slog.Warn(ErrAuthorizationInvalid.Error())
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeProblem(w, r, http.StatusUnauthorized, ErrAuthorizationInvalid)
+				return
+			}
+			user, err := auth.LoadAPITokenUser(ctx, conn, secret, time.Now())
+			if errors.Is(err, auth.ErrAPITokenNotFound) || errors.Is(err, auth.ErrUserNotFound) {
+				slog.Warn(err.Error())
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeProblem(w, r, http.StatusUnauthorized, auth.ErrAPITokenNotFound)
+				return
+			}
+			kcore.Expect(err, "error loading api token user")
+			logger := slog.With(slog.String("userId", user.Id.String()))
+			if user.IsDisabled {
+				logger.Warn(auth.ErrUserDisabled.Error())
+				writeProblem(w, r, http.StatusForbidden, auth.ErrUserDisabled)
+				return
+			}
+			if !user.APITokenScope.Allows(r.Method) {
+				logger.Warn(auth.ErrAPITokenReadOnly.Error())
+				writeProblem(w, r, http.StatusForbidden, auth.ErrAPITokenReadOnly)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithAPITokenUser(ctx, user)))
+		})
+	}
+}
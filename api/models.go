@@ -0,0 +1,334 @@
+package api
+
+import (
+	"bike_race/auth"
+	"bike_race/race"
+	"time"
+)
+
+// Models mirror the queries models, with ids in the same format as in the urls and snake_case fields like the forms
+
+type Category struct {
+	Id                  string     `json:"id"`
+	Name                string     `json:"name"`
+	StartAt             *time.Time `json:"start_at"`
+	RegisteredCount     int        `json:"registered_count"`
+	MaximumParticipants int        `json:"maximum_participants"`
+	MinimumAge          int        `json:"minimum_age"`
+	MaximumAge          int        `json:"maximum_age"`
+	FirstBib            int        `json:"first_bib"`
+	LastBib             int        `json:"last_bib"`
+}
+
+type RaceListItem struct {
+	Id                    string     `json:"id"`
+	Name                  string     `json:"name"`
+	Status                string     `json:"status"`
+	StartAt               *time.Time `json:"start_at"`
+	Timezone              string     `json:"timezone"`
+	IsOpenForRegistration bool       `json:"is_open_for_registration"`
+	RegistrationClosesAt  *time.Time `json:"registration_closes_at"`
+	Organizers            string     `json:"organizers"`
+	RegisteredCount       int        `json:"registered_count"`
+	WaitlistedCount       int        `json:"waitlisted_count"`
+	MaximumParticipants   int        `json:"maximum_participants"`
+	Categories            []Category `json:"categories"`
+	CanRegister           bool       `json:"can_register"`
+}
+
+type Course struct {
+	Distance      float64 `json:"distance"`
+	ElevationGain float64 `json:"elevation_gain"`
+	ElevationLoss float64 `json:"elevation_loss"`
+	MaxGradient   float64 `json:"max_gradient"`
+}
+
+type Organizer struct {
+	UserId   string `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type RacePermissions struct {
+	CanUpdateDescription    bool `json:"can_update_description"`
+	CanPublish              bool `json:"can_publish"`
+	CanCancel               bool `json:"can_cancel"`
+	CanFinish               bool `json:"can_finish"`
+	CanOpenForRegistration  bool `json:"can_open_for_registration"`
+	CanCloseRegistration    bool `json:"can_close_registration"`
+	CanApproveRegistrations bool `json:"can_approve_registrations"`
+	CanManageCategories     bool `json:"can_manage_categories"`
+	CanManageTiming         bool `json:"can_manage_timing"`
+	CanManageOrganizers     bool `json:"can_manage_organizers"`
+	CanViewContactDetails   bool `json:"can_view_contact_details"`
+	CanRegister             bool `json:"can_register"`
+}
+
+type RaceDetail struct {
+	Id                    string          `json:"id"`
+	Name                  string          `json:"name"`
+	Status                string          `json:"status"`
+	IsOpenForRegistration bool            `json:"is_open_for_registration"`
+	RegistrationOpensAt   *time.Time      `json:"registration_opens_at"`
+	RegistrationClosesAt  *time.Time      `json:"registration_closes_at"`
+	MaximumParticipants   int             `json:"maximum_participants"`
+	StartAt               *time.Time      `json:"start_at"`
+	Timezone              string          `json:"timezone"`
+	Course                *Course         `json:"course"`
+	Organizers            []Organizer     `json:"organizers"`
+	OrganizerInvitations  []Organizer     `json:"organizer_invitations"`
+	Categories            []Category      `json:"categories"`
+	Permissions           RacePermissions `json:"permissions"`
+}
+
+type RegistrationUser struct {
+	Id              string `json:"id"`
+	Username        string `json:"username"`
+	Email           string `json:"email,omitempty"`
+	IsEmailVerified bool   `json:"is_email_verified,omitempty"`
+	FullName        string `json:"full_name,omitempty"`
+	BirthDate       string `json:"birth_date,omitempty"`
+	Gender          string `json:"gender,omitempty"`
+	Phone           string `json:"phone,omitempty"`
+}
+
+type RegistrationPermissions struct {
+	CanApprove                   bool `json:"can_approve"`
+	CanApproveMedicalCertificate bool `json:"can_approve_medical_certificate"`
+	CanReject                    bool `json:"can_reject"`
+	CanCancel                    bool `json:"can_cancel"`
+	CanAssignBib                 bool `json:"can_assign_bib"`
+	CanViewMedicalCertificate    bool `json:"can_view_medical_certificate"`
+	CanViewContactDetails        bool `json:"can_view_contact_details"`
+	CanSubmit                    bool `json:"can_submit"`
+	CanWithdraw                  bool `json:"can_withdraw"`
+}
+
+type Registration struct {
+	User                         RegistrationUser        `json:"user"`
+	Category                     string                  `json:"category"`
+	Bib                          int                     `json:"bib"`
+	Status                       string                  `json:"status"`
+	WaitlistPosition             int                     `json:"waitlist_position"`
+	RejectionReason              string                  `json:"rejection_reason"`
+	RegisteredAt                 time.Time               `json:"registered_at"`
+	HasMedicalCertificate        bool                    `json:"has_medical_certificate"`
+	IsMedicalCertificateApproved bool                    `json:"is_medical_certificate_approved"`
+	Permissions                  RegistrationPermissions `json:"permissions"`
+}
+
+type RaceReference struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserRegistrationPermissions struct {
+	CanUploadMedicalCertificate bool `json:"can_upload_medical_certificate"`
+	CanSubmit                   bool `json:"can_submit"`
+	CanWithdraw                 bool `json:"can_withdraw"`
+}
+
+type UserRegistration struct {
+	Race             RaceReference               `json:"race"`
+	Category         string                      `json:"category"`
+	Status           string                      `json:"status"`
+	WaitlistPosition int                         `json:"waitlist_position"`
+	RejectionReason  string                      `json:"rejection_reason"`
+	Permissions      UserRegistrationPermissions `json:"permissions"`
+}
+
+type OrganizerInvitation struct {
+	Race RaceReference `json:"race"`
+	Role string        `json:"role"`
+}
+
+type Result struct {
+	Username          string  `json:"username"`
+	Category          string  `json:"category"`
+	Status            string  `json:"status"`
+	FinishTimeSeconds float64 `json:"finish_time_seconds"`
+	Rank              int     `json:"rank"`
+	CategoryRank      int     `json:"category_rank"`
+}
+
+type CategoryResults struct {
+	Name    string   `json:"name"`
+	Results []Result `json:"results"`
+}
+
+type Results struct {
+	Race       RaceReference     `json:"race"`
+	Overall    []Result          `json:"overall"`
+	Categories []CategoryResults `json:"categories"`
+	CanImport  bool              `json:"can_import"`
+}
+
+type Profile struct {
+	Id              string `json:"id"`
+	Username        string `json:"username"`
+	Language        string `json:"language"`
+	Email           string `json:"email"`
+	IsEmailVerified bool   `json:"is_email_verified"`
+	FullName        string `json:"full_name"`
+	BirthDate       string `json:"birth_date"`
+	Gender          string `json:"gender"`
+	Phone           string `json:"phone"`
+	TOTPEnabled     bool   `json:"totp_enabled"`
+	IsAdmin         bool   `json:"is_admin"`
+}
+
+type Created struct {
+	Id string `json:"id"`
+}
+
+// optionalTime is null for the times that are not chosen yet
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func optionalDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateOnly)
+}
+
+func newCategories(models []race.RaceCategoryModel) []Category {
+	categories := make([]Category, 0, len(models))
+	for _, model := range models {
+		categories = append(categories, Category{
+			Id:                  model.Id.String(),
+			Name:                model.Name,
+			StartAt:             optionalTime(model.StartAt),
+			RegisteredCount:     model.RegisteredCount,
+			MaximumParticipants: model.MaximumParticipants,
+			MinimumAge:          model.MinimumAge,
+			MaximumAge:          model.MaximumAge,
+			FirstBib:            model.FirstBib,
+			LastBib:             model.LastBib,
+		})
+	}
+	return categories
+}
+
+func newRaceListItem(model race.RaceListModel) RaceListItem {
+	return RaceListItem{
+		Id:                    model.Id.String(),
+		Name:                  model.Name,
+		Status:                string(model.Status),
+		StartAt:               optionalTime(model.StartAt),
+		Timezone:              model.Location.String(),
+		IsOpenForRegistration: model.IsOpenForRegistration,
+		RegistrationClosesAt:  optionalTime(model.RegistrationClosesAt),
+		Organizers:            model.Organizers,
+		RegisteredCount:       model.RegisteredCount,
+		WaitlistedCount:       model.WaitlistedCount,
+		MaximumParticipants:   model.MaximumParticipants,
+		Categories:            newCategories(model.Categories),
+		CanRegister:           model.CanRegister,
+	}
+}
+
+func newOrganizers(models []race.RaceOrganizerModel) []Organizer {
+	organizers := make([]Organizer, 0, len(models))
+	for _, model := range models {
+		organizers = append(organizers, Organizer{UserId: model.UserId.String(), Username: model.Username, Role: string(model.Role)})
+	}
+	return organizers
+}
+
+func newRaceDetail(model race.RaceDetailModel) RaceDetail {
+	detail := RaceDetail{
+		Id:                    model.Id.String(),
+		Name:                  model.Name,
+		Status:                string(model.Status),
+		IsOpenForRegistration: model.IsOpenForRegistration,
+		RegistrationOpensAt:   optionalTime(model.RegistrationOpensAt),
+		RegistrationClosesAt:  optionalTime(model.RegistrationClosesAt),
+		MaximumParticipants:   model.MaximumParticipants,
+		StartAt:               optionalTime(model.StartAt),
+		Timezone:              model.Timezone,
+		Organizers:            newOrganizers(model.Organizers),
+		OrganizerInvitations:  newOrganizers(model.OrganizerInvitations),
+		Categories:            newCategories(model.Categories),
+		Permissions:           RacePermissions(model.Permissions),
+	}
+	if model.Course != nil {
+		detail.Course = &Course{
+			Distance:      model.Course.Distance,
+			ElevationGain: model.Course.ElevationGain,
+			ElevationLoss: model.Course.ElevationLoss,
+			MaxGradient:   model.Course.MaxGradient,
+		}
+	}
+	return detail
+}
+
+func newRegistration(model race.RaceRegistrationModel) Registration {
+	return Registration{
+		User: RegistrationUser{
+			Id:              model.User.Id.String(),
+			Username:        model.User.Username,
+			Email:           model.User.Email,
+			IsEmailVerified: model.User.IsEmailVerified,
+			FullName:        model.User.ContactDetails.FullName,
+			BirthDate:       optionalDate(model.User.ContactDetails.BirthDate),
+			Gender:          string(model.User.ContactDetails.Gender),
+			Phone:           model.User.ContactDetails.Phone,
+		},
+		Category:                     model.Category,
+		Bib:                          model.Bib,
+		Status:                       string(model.Status),
+		WaitlistPosition:             model.WaitlistPosition,
+		RejectionReason:              model.RejectionReason,
+		RegisteredAt:                 model.RegisteredAt,
+		HasMedicalCertificate:        model.MedicalCertificate != nil,
+		IsMedicalCertificateApproved: model.IsMedicalCertificateApproved,
+		Permissions:                  RegistrationPermissions(model.Permissions),
+	}
+}
+
+func newUserRegistration(model race.UserRegistrationModel) UserRegistration {
+	return UserRegistration{
+		Race:             RaceReference{Id: model.Race.Id.String(), Name: model.Race.Name},
+		Category:         model.Category,
+		Status:           string(model.Status),
+		WaitlistPosition: model.WaitlistPosition,
+		RejectionReason:  model.RejectionReason,
+		Permissions:      UserRegistrationPermissions(model.Permissions),
+	}
+}
+
+func newResults(models []race.RaceResultModel) []Result {
+	results := make([]Result, 0, len(models))
+	for _, model := range models {
+		results = append(results, Result{
+			Username:          model.Username,
+			Category:          model.Category,
+			Status:            string(model.Status),
+			FinishTimeSeconds: model.FinishTime.Seconds(),
+			Rank:              model.Rank,
+			CategoryRank:      model.CategoryRank,
+		})
+	}
+	return results
+}
+
+func newProfile(user auth.User) Profile {
+	return Profile{
+		Id:              user.Id.String(),
+		Username:        user.Username,
+		Language:        user.Language(),
+		Email:           user.Email,
+		IsEmailVerified: user.IsEmailVerified(),
+		FullName:        user.ContactDetails.FullName,
+		BirthDate:       optionalDate(user.ContactDetails.BirthDate),
+		Gender:          string(user.ContactDetails.Gender),
+		Phone:           user.ContactDetails.Phone,
+		TOTPEnabled:     user.TOTPEnabled,
+		IsAdmin:         user.IsAdmin,
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var pathParamRegexp = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument is generated from the operations and the reflected request and response types
+func openAPIDocument(ops []operation) map[string]any {
+	schemas := map[string]any{}
+	schemas["Problem"] = schemaOf(reflect.TypeOf(Problem{}), schemas)
+	paths := map[string]map[string]any{}
+	for _, op := range ops {
+		item, ok := paths[op.path]
+		if !ok {
+			item = map[string]any{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = openAPIOperation(op, schemas)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Bike race API",
+			"version": "1",
+		},
+		"servers":  []any{map[string]any{"url": "/api/v1"}},
+		"paths":    paths,
+		"security": []any{map[string]any{"cookieAuth": []any{}}, map[string]any{"bearerAuth": []any{}}},
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": "authentication"},
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func openAPIOperation(op operation, schemas map[string]any) map[string]any {
+	parameters := []any{}
+	for _, match := range pathParamRegexp.FindAllStringSubmatch(op.path, -1) {
+		parameters = append(parameters, map[string]any{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	response := map[string]any{"description": http.StatusText(op.status)}
+	if op.response != nil {
+		response["content"] = map[string]any{
+			"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.response), schemas)},
+		}
+	}
+	result := map[string]any{
+		"summary":    op.summary,
+		"parameters": parameters,
+		"responses": map[string]any{
+			strconv.Itoa(op.status): response,
+			"default": map[string]any{
+				"description": "Problem",
+				"content": map[string]any{
+					"application/problem+json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Problem"}},
+				},
+			},
+		},
+	}
+	if op.request != nil {
+		result["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.request), schemas)},
+			},
+		}
+	}
+	return result
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf registers named structs in the components, and references them from everywhere else
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if ref, ok := schema["$ref"]; ok {
+			return map[string]any{"allOf": []any{map[string]any{"$ref": ref}}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// Placeholder first, in case the struct references itself
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema marks the fields without omitempty as required
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+		if options != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
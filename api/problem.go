@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+var (
+	ErrNotFound         = errors.New("resource not found")
+	ErrMethodNotAllowed = errors.New("method not allowed on this resource")
+	ErrInternal         = errors.New("internal error, see the server logs")
+)
+
+// Problem is an RFC 7807 problem details object, the type is always about:blank so the title is the status text
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+}
+
+// writeProblem hides the details of server errors, they are only logged
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		slog.Error(err.Error(), slog.String("path", r.URL.Path))
+		err = ErrInternal
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	})
+	kcore.Expect(err, "error encoding problem")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	kcore.Expect(err, "error encoding response")
+}
+
+// decodeBody refuses unknown fields, so that typos in client scripts are not silently ignored
+func decodeBody(w http.ResponseWriter, r *http.Request, body any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(body)
+	if err != nil {
+		err = kcore.Wrap(err, "error decoding body")
+		slog.Warn(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// idParam writes the problem itself, callers only have to return when it fails
+func idParam(w http.ResponseWriter, r *http.Request, name string) (kcore.ID, bool) {
+	id, err := kcore.ParseID(chi.URLParam(r, name))
+	if err != nil {
+		err = kcore.Wrap(err, "error parsing "+name)
+		slog.Warn(err.Error())
+		writeProblem(w, r, http.StatusBadRequest, err)
+		return kcore.ID{}, false
+	}
+	return id, true
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"bike_race/race"
+	"context"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+// respondCommand answers 204, as commands return nothing but their status
+func respondCommand(w http.ResponseWriter, r *http.Request, code int, err error) {
+	if err != nil {
+		writeProblem(w, r, code, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func raceCommandHandler(conn *pgxpool.Pool, command func(context.Context, *pgxpool.Pool, kcore.ID) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		code, err := command(r.Context(), conn, raceId)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func raceUserCommandHandler(conn *pgxpool.Pool, command func(context.Context, *pgxpool.Pool, kcore.ID, kcore.ID) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		userId, ok := idParam(w, r, "userId")
+		if !ok {
+			return
+		}
+		code, err := command(r.Context(), conn, raceId, userId)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func raceListHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		models, code, err := race.RaceListQuery(r.Context(), conn)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		races := make([]RaceListItem, 0, len(models))
+		for _, model := range models {
+			races = append(races, newRaceListItem(model))
+		}
+		writeJSON(w, http.StatusOK, races)
+	}
+}
+
+func organizeRaceHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body OrganizeRaceRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		raceId, code, err := race.OrganizeRaceCommand(r.Context(), conn, body.Name)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/races/"+raceId.String())
+		writeJSON(w, http.StatusCreated, Created{Id: raceId.String()})
+	}
+}
+
+func raceDetailHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		model, code, err := race.RaceDetailQuery(r.Context(), conn, raceId)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newRaceDetail(model))
+	}
+}
+
+func openForRegistrationHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		var body OpenForRegistrationRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		code, err := race.OpenRaceForRegistration(r.Context(), conn, raceId, timeOrZero(body.StartAt), body.Timezone, body.MaximumParticipants, timeOrZero(body.RegistrationOpensAt), timeOrZero(body.RegistrationClosesAt))
+		respondCommand(w, r, code, err)
+	}
+}
+
+func addCategoryHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		var body CategoryRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		bibs := race.BibRange{First: body.FirstBib, Last: body.LastBib}
+		code, err := race.AddRaceCategoryCommand(r.Context(), conn, raceId, body.Name, timeOrZero(body.StartAt), body.MaximumParticipants, body.MinimumAge, body.MaximumAge, bibs)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func updateCategoryHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		categoryId, ok := idParam(w, r, "categoryId")
+		if !ok {
+			return
+		}
+		var body CategoryRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		bibs := race.BibRange{First: body.FirstBib, Last: body.LastBib}
+		code, err := race.UpdateRaceCategoryCommand(r.Context(), conn, raceId, categoryId, body.Name, timeOrZero(body.StartAt), body.MaximumParticipants, body.MinimumAge, body.MaximumAge, bibs)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func removeCategoryHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		categoryId, ok := idParam(w, r, "categoryId")
+		if !ok {
+			return
+		}
+		code, err := race.RemoveRaceCategoryCommand(r.Context(), conn, raceId, categoryId)
+		respondCommand(w, r, code, err)
+	}
+}
+
+// registrationsHandler loads the race first, as the registrations shown depend on the organizer permissions
+func registrationsHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		detail, code, err := race.RaceDetailQuery(ctx, conn, raceId)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		models, code, err := race.RaceRegistrationsQuery(ctx, conn, raceId, detail.Permissions)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		registrations := make([]Registration, 0, len(models))
+		for _, model := range models {
+			registrations = append(registrations, newRegistration(model))
+		}
+		writeJSON(w, http.StatusOK, registrations)
+	}
+}
+
+func registerForRaceHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		var body RegisterForRaceRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		var categoryId *kcore.ID
+		if body.CategoryId != "" {
+			id, err := kcore.ParseID(body.CategoryId)
+			if err != nil {
+				err = kcore.Wrap(err, "error parsing category_id")
+				slog.Warn(err.Error())
+				writeProblem(w, r, http.StatusBadRequest, err)
+				return
+			}
+			categoryId = &id
+		}
+		code, err := race.RegisterForRaceCommand(r.Context(), conn, raceId, categoryId)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func rejectRegistrationHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		userId, ok := idParam(w, r, "userId")
+		if !ok {
+			return
+		}
+		var body RejectRegistrationRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		code, err := race.RejectRaceRegistrationCommand(r.Context(), conn, raceId, userId, body.Reason)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func assignBibHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		userId, ok := idParam(w, r, "userId")
+		if !ok {
+			return
+		}
+		var body AssignBibRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		code, err := race.AssignRaceBibCommand(r.Context(), conn, raceId, userId, body.Bib)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func inviteOrganizerHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		var body InviteOrganizerRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		role, err := race.ParseOrganizerRole(body.Role)
+		if err != nil {
+			slog.Warn(err.Error())
+			writeProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+		code, err := race.InviteOrganizerCommand(r.Context(), conn, raceId, body.Username, role)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func changeOrganizerRoleHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		userId, ok := idParam(w, r, "userId")
+		if !ok {
+			return
+		}
+		var body ChangeOrganizerRoleRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		role, err := race.ParseOrganizerRole(body.Role)
+		if err != nil {
+			slog.Warn(err.Error())
+			writeProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+		code, err := race.ChangeOrganizerRoleCommand(r.Context(), conn, raceId, userId, role)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func resultsHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raceId, ok := idParam(w, r, "raceId")
+		if !ok {
+			return
+		}
+		model, code, err := race.RaceResultsQuery(r.Context(), conn, raceId)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		results := Results{
+			Race:       RaceReference{Id: model.Race.Id.String(), Name: model.Race.Name},
+			Overall:    newResults(model.Overall),
+			Categories: make([]CategoryResults, 0, len(model.Categories)),
+			CanImport:  model.Permissions.CanImport,
+		}
+		for _, category := range model.Categories {
+			results.Categories = append(results.Categories, CategoryResults{Name: category.Name, Results: newResults(category.Results)})
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import "time"
+
+type OrganizeRaceRequest struct {
+	Name string `json:"name"`
+}
+
+type OpenForRegistrationRequest struct {
+	StartAt              *time.Time `json:"start_at"`
+	Timezone             string     `json:"timezone"`
+	MaximumParticipants  int        `json:"maximum_participants"`
+	RegistrationOpensAt  *time.Time `json:"registration_opens_at"`
+	RegistrationClosesAt *time.Time `json:"registration_closes_at"`
+}
+
+type CategoryRequest struct {
+	Name                string     `json:"name"`
+	StartAt             *time.Time `json:"start_at"`
+	MaximumParticipants int        `json:"maximum_participants"`
+	MinimumAge          int        `json:"minimum_age"`
+	MaximumAge          int        `json:"maximum_age"`
+	FirstBib            int        `json:"first_bib"`
+	LastBib             int        `json:"last_bib"`
+}
+
+// RegisterForRaceRequest needs a category when the race has some, age limits use the birth date of the profile
+type RegisterForRaceRequest struct {
+	CategoryId string `json:"category_id,omitempty"`
+}
+
+type RejectRegistrationRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AssignBibRequest struct {
+	Bib int `json:"bib"`
+}
+
+type InviteOrganizerRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type ChangeOrganizerRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type RegisterUserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password"`
+}
+
+type UpdateProfileRequest struct {
+	Email     string `json:"email,omitempty"`
+	FullName  string `json:"full_name,omitempty"`
+	BirthDate string `json:"birth_date,omitempty"`
+	Gender    string `json:"gender,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+}
+
+type UpdateLanguageRequest struct {
+	Language string `json:"language"`
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// parseOptionalDate reads an empty date as the zero time
+func parseOptionalDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bike_race/config"
+	"bike_race/mail"
+	"bike_race/race"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// operation describes a route both for the router and for the OpenAPI document, so they cannot drift apart
+type operation struct {
+	method   string
+	path     string
+	summary  string
+	request  any
+	response any
+	status   int
+	handler  http.HandlerFunc
+}
+
+func operations(conn *pgxpool.Pool, config config.Config, mailer mail.Mailer, live *race.LiveTiming) []operation {
+	return []operation{
+		{http.MethodGet, "/races", "List the races visible to the current user", nil, []RaceListItem{}, http.StatusOK, raceListHandler(conn)},
+		{http.MethodPost, "/races", "Organize a new race", OrganizeRaceRequest{}, Created{}, http.StatusCreated, organizeRaceHandler(conn)},
+		{http.MethodGet, "/races/{raceId}", "Get a race", nil, RaceDetail{}, http.StatusOK, raceDetailHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/publish", "Publish a race", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.PublishRaceCommand)},
+		{http.MethodPost, "/races/{raceId}/unpublish", "Unpublish a race", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.UnpublishRaceCommand)},
+		{http.MethodPost, "/races/{raceId}/cancel", "Cancel a race", nil, nil, http.StatusNoContent, raceCommandHandler(conn, live.Ending(race.CancelRaceCommand))},
+		{http.MethodPost, "/races/{raceId}/finish", "Finish a race", nil, nil, http.StatusNoContent, raceCommandHandler(conn, live.Ending(race.FinishRaceCommand))},
+		{http.MethodDelete, "/races/{raceId}", "Delete a draft race", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.DeleteRaceCommand)},
+		{http.MethodPost, "/races/{raceId}/open_for_registration", "Open a race for registration", OpenForRegistrationRequest{}, nil, http.StatusNoContent, openForRegistrationHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/close_registration", "Close the registration of a race", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.CloseRegistrationCommand)},
+		{http.MethodPost, "/races/{raceId}/categories", "Add a category to a race", CategoryRequest{}, nil, http.StatusNoContent, addCategoryHandler(conn)},
+		{http.MethodPut, "/races/{raceId}/categories/{categoryId}", "Update a category", CategoryRequest{}, nil, http.StatusNoContent, updateCategoryHandler(conn)},
+		{http.MethodDelete, "/races/{raceId}/categories/{categoryId}", "Remove a category", nil, nil, http.StatusNoContent, removeCategoryHandler(conn)},
+		{http.MethodGet, "/races/{raceId}/registrations", "List the registrations of a race", nil, []Registration{}, http.StatusOK, registrationsHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/registrations", "Register the current user for a race", RegisterForRaceRequest{}, nil, http.StatusNoContent, registerForRaceHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/registration/submit", "Submit the registration of the current user", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.SubmitRaceRegistrationCommand)},
+		{http.MethodPost, "/races/{raceId}/registration/withdraw", "Withdraw the registration of the current user", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.WithdrawRaceRegistrationCommand)},
+		{http.MethodPost, "/races/{raceId}/registrations/{userId}/approve", "Approve a registration", nil, nil, http.StatusNoContent, raceUserCommandHandler(conn, race.ApproveRaceRegistrationCommand)},
+		{http.MethodPost, "/races/{raceId}/registrations/{userId}/reject", "Reject a registration", RejectRegistrationRequest{}, nil, http.StatusNoContent, rejectRegistrationHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/registrations/{userId}/cancel", "Cancel a registration", nil, nil, http.StatusNoContent, raceUserCommandHandler(conn, race.CancelRaceRegistrationCommand)},
+		{http.MethodPut, "/races/{raceId}/registrations/{userId}/bib", "Assign a bib to a registration", AssignBibRequest{}, nil, http.StatusNoContent, assignBibHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/organizers", "Invite an organizer", InviteOrganizerRequest{}, nil, http.StatusNoContent, inviteOrganizerHandler(conn)},
+		{http.MethodPost, "/races/{raceId}/organizers/accept", "Accept an organizer invitation", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.AcceptOrganizerInvitationCommand)},
+		{http.MethodPost, "/races/{raceId}/organizers/decline", "Decline an organizer invitation", nil, nil, http.StatusNoContent, raceCommandHandler(conn, race.DeclineOrganizerInvitationCommand)},
+		{http.MethodPut, "/races/{raceId}/organizers/{userId}/role", "Change the role of an organizer", ChangeOrganizerRoleRequest{}, nil, http.StatusNoContent, changeOrganizerRoleHandler(conn)},
+		{http.MethodDelete, "/races/{raceId}/organizers/{userId}", "Remove an organizer", nil, nil, http.StatusNoContent, raceUserCommandHandler(conn, race.RemoveOrganizerCommand)},
+		{http.MethodDelete, "/races/{raceId}/organizers/{userId}/invitation", "Revoke an organizer invitation", nil, nil, http.StatusNoContent, raceUserCommandHandler(conn, race.RevokeOrganizerInvitationCommand)},
+		{http.MethodGet, "/races/{raceId}/results", "Get the results of a race", nil, Results{}, http.StatusOK, resultsHandler(conn)},
+		{http.MethodPost, "/users", "Register a new user", RegisterUserRequest{}, nil, http.StatusCreated, registerUserHandler(conn, mailer, config.BaseURL)},
+		{http.MethodGet, "/me", "Get the current user", nil, Profile{}, http.StatusOK, meHandler},
+		{http.MethodPut, "/me/profile", "Update the email and contact details of the current user", UpdateProfileRequest{}, nil, http.StatusNoContent, updateProfileHandler(conn, mailer, config.BaseURL)},
+		{http.MethodPut, "/me/language", "Update the language of the current user", UpdateLanguageRequest{}, nil, http.StatusNoContent, updateLanguageHandler(conn)},
+		{http.MethodGet, "/me/registrations", "List the registrations of the current user", nil, []UserRegistration{}, http.StatusOK, userRegistrationsHandler(conn)},
+		{http.MethodGet, "/me/organizer_invitations", "List the organizer invitations of the current user", nil, []OrganizerInvitation{}, http.StatusOK, organizerInvitationsHandler(conn)},
+	}
+}
+
+// Router exposes the same queries and commands as the pages, file uploads and medical certificates stay on the pages.
+// Requests are authenticated with the session cookie, or with an API token as a bearer.
+func Router(conn *pgxpool.Pool, config config.Config, mailer mail.Mailer, live *race.LiveTiming) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(recoverMiddleware)
+	router.Use(bearerAuthMiddleware(conn))
+	ops := operations(conn, config, mailer, live)
+	for _, op := range ops {
+		router.Method(op.method, op.path, op.handler)
+	}
+	document := openAPIDocument(ops)
+	router.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, document)
+	})
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, ErrNotFound)
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+	})
+	return router
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bike_race/auth"
+	"bike_race/mail"
+	"bike_race/race"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+func registerUserHandler(conn *pgxpool.Pool, mailer mail.Mailer, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body RegisterUserRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		code, err := auth.RegisterUserCommand(r.Context(), conn, mailer, baseURL, body.Username, body.Email, body.Password)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, auth.ErrNotAuthenticated)
+		return
+	}
+	writeJSON(w, http.StatusOK, newProfile(user))
+}
+
+func updateProfileHandler(conn *pgxpool.Pool, mailer mail.Mailer, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body UpdateProfileRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		birthDate, err := parseOptionalDate(body.BirthDate)
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing birth_date")
+			slog.Warn(err.Error())
+			writeProblem(w, r, http.StatusBadRequest, err)
+			return
+		}
+		details := auth.ContactDetails{
+			FullName:  body.FullName,
+			BirthDate: birthDate,
+			Gender:    auth.Gender(body.Gender),
+			Phone:     body.Phone,
+		}
+		code, err := auth.UpdateProfileCommand(r.Context(), conn, mailer, baseURL, body.Email, details)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func updateLanguageHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body UpdateLanguageRequest
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		code, err := auth.UpdateUserLanguageCommand(r.Context(), conn, body.Language)
+		respondCommand(w, r, code, err)
+	}
+}
+
+func userRegistrationsHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		models, code, err := race.CurrentUserRegistrationsQuery(r.Context(), conn)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		registrations := make([]UserRegistration, 0, len(models))
+		for _, model := range models {
+			registrations = append(registrations, newUserRegistration(model))
+		}
+		writeJSON(w, http.StatusOK, registrations)
+	}
+}
+
+func organizerInvitationsHandler(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		models, code, err := race.OrganizerInvitationsQuery(r.Context(), conn)
+		if err != nil {
+			writeProblem(w, r, code, err)
+			return
+		}
+		invitations := make([]OrganizerInvitation, 0, len(models))
+		for _, model := range models {
+			invitations = append(invitations, OrganizerInvitation{
+				Race: RaceReference{Id: model.RaceId.String(), Name: model.RaceName},
+				Role: string(model.Role),
+			})
+		}
+		writeJSON(w, http.StatusOK, invitations)
+	}
+}
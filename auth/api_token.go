@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrAPITokenNotFound     = errors.New("api token not found")
+	ErrAPITokenNameInvalid  = errors.New("api token name must be between 1 and 255 characters")
+	ErrAPITokenScopeInvalid = errors.New("api token scope is invalid")
+	ErrAPITokenReadOnly     = errors.New("api token is read-only")
+	ErrAPITokenNotAllowed   = errors.New("api tokens can not manage the account or api tokens")
+)
+
+type APITokenScope string
+
+const (
+	// APITokenScopeReadOnly only allows the requests that do not change anything
+	APITokenScopeReadOnly APITokenScope = "read_only"
+	// APITokenScopeOrganizer allows everything the user can do from the API, like organizing races
+	APITokenScopeOrganizer APITokenScope = "organizer"
+)
+
+var APITokenScopes = []APITokenScope{APITokenScopeReadOnly, APITokenScopeOrganizer}
+
+func (scope APITokenScope) Allows(method string) bool {
+	if scope == APITokenScopeReadOnly {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return true
+}
+
+type APIToken struct {
+	Id        kcore.ID
+	UserId    kcore.ID
+	Name      string
+	Scope     APITokenScope
+	CreatedAt time.Time
+}
+
+// NewAPIToken returns the secret along with the token, it is shown once and only its hash is stored
+func NewAPIToken(userId kcore.ID, name string, scope APITokenScope, now time.Time) (APIToken, string, error) {
+	if len(name) == 0 || len(name) > 255 {
+		return APIToken{}, "", ErrAPITokenNameInvalid
+	}
+	if !slices.Contains(APITokenScopes, scope) {
+		return APIToken{}, "", ErrAPITokenScopeInvalid
+	}
+	secret, err := newSecretToken()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	return APIToken{Id: kcore.NewID(), UserId: userId, Name: name, Scope: scope, CreatedAt: now}, secret, nil
+}
+
+func (token APIToken) Save(ctx context.Context, conn *pgxpool.Pool, secret string) error {
+	_, err := conn.Exec(ctx, `
+	INSERT INTO api_tokens (id, user_id, name, scope, token_hash, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`, token.Id, token.UserId, token.Name, token.Scope, hashSecretToken(secret), token.CreatedAt)
+	if err != nil {
+		return kcore.Wrap(err, "error inserting api_tokens table")
+	}
+	return nil
+}
+
+// LoadAPITokenUser is the bearer counterpart of LoadSessionUser, revoked tokens are not found
+func LoadAPITokenUser(ctx context.Context, conn *pgxpool.Pool, secret string, now time.Time) (User, error) {
+	var tokenId kcore.ID
+	var userId kcore.ID
+	var scope APITokenScope
+	var lastUsedAt *time.Time
+	err := conn.QueryRow(ctx, `
+	SELECT id, user_id, scope, last_used_at FROM api_tokens WHERE token_hash = $1 AND revoked_at IS NULL
+	`, hashSecretToken(secret)).Scan(&tokenId, &userId, &scope, &lastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrAPITokenNotFound
+	} else if err != nil {
+		return User{}, kcore.Wrap(err, "error selecting api_tokens table")
+	}
+	if lastUsedAt == nil || now.Sub(*lastUsedAt) > lastSeenPrecision {
+		_, err = conn.Exec(ctx, `UPDATE api_tokens SET last_used_at = $2 WHERE id = $1`, tokenId, now)
+		if err != nil {
+			return User{}, kcore.Wrap(err, "error updating api_tokens table")
+		}
+	}
+	user, err := LoadUser(ctx, conn, userId)
+	if err != nil {
+		return User{}, err
+	}
+	user.APITokenScope = scope
+	return user, nil
+}
+
+func revokeAPIToken(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, tokenId kcore.ID, now time.Time) error {
+	tag, err := conn.Exec(ctx, `
+	UPDATE api_tokens SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, tokenId, userId, now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating api_tokens table")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
@@ -9,10 +9,25 @@ import (
 
 type Login = kcore.Login[User]
 
+// LoginFromContext gives anonymous visitors the language negotiated by LanguageMiddleware
 func LoginFromContext(ctx context.Context) Login {
-	return kauth.LoginFromContext[User](ctx)
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		user.language = languageFromContext(ctx)
+	}
+	return kcore.LoginFromUser(user, ok)
+}
+
+type apiTokenUserContext struct{}
+
+// WithAPITokenUser overrides the user loaded by kauth, whose context key can not be set from outside
+func WithAPITokenUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, apiTokenUserContext{}, user)
 }
 
 func UserFromContext(ctx context.Context) (User, bool) {
+	if user, ok := ctx.Value(apiTokenUserContext{}).(User); ok {
+		return user, true
+	}
 	return kauth.UserFromContext[User](ctx)
 }
@@ -1,23 +1,35 @@
 package auth
 
 import (
+	"bike_race/mail"
+	"bike_race/media"
+	"bike_race/oidc"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kauth"
 	"github.com/martinlehoux/kagamigo/kcore"
 	"golang.org/x/exp/slog"
 )
 
-func RegisterUserCommand(ctx context.Context, conn *pgxpool.Pool, username string, password string) (int, error) {
+func RegisterUserCommand(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string, username string, email string, password string) (int, error) {
 	logger := slog.With(slog.String("command", "RegisterUserCommand"), slog.String("username", username))
-	user, err := NewUser(username)
+	user, err := NewUser(username, languageFromContext(ctx))
 	if err != nil {
 		err = kcore.Wrap(err, "error creating user")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
 	logger = logger.With(slog.String("userId", user.Id.String()))
+	err = user.SetEmail(email)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
 	err = user.SetPassword("", password)
 	if err != nil {
 		err = kcore.Wrap(err, "error setting password")
@@ -25,10 +37,589 @@ func RegisterUserCommand(ctx context.Context, conn *pgxpool.Pool, username strin
 		return http.StatusBadRequest, err
 	}
 	err = user.Save(ctx, conn)
-	if err != nil {
+	if errors.Is(err, ErrUserEmailTaken) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	} else if err != nil {
 		err = kcore.Wrap(err, "error saving user")
 		logger.Error(err.Error())
 		return http.StatusInternalServerError, err
 	}
+	// The account exists even if the mail fails, the verification can be sent again from the profile
+	if user.Email != "" {
+		err = sendEmailVerification(ctx, conn, mailer, baseURL, user)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
 	return http.StatusCreated, nil
 }
+
+func DisableUserCommand(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "DisableUserCommand"), slog.String("userId", userId.String()))
+	logger.Info("disabling user")
+	return setUserDisabled(ctx, conn, logger, userId, true)
+}
+
+func EnableUserCommand(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "EnableUserCommand"), slog.String("userId", userId.String()))
+	logger.Info("enabling user")
+	return setUserDisabled(ctx, conn, logger, userId, false)
+}
+
+func setUserDisabled(ctx context.Context, conn *pgxpool.Pool, logger *slog.Logger, userId kcore.ID, isDisabled bool) (int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	err := currentUser.Moderate(userId)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	user, err := LoadUser(ctx, conn, userId)
+	if errors.Is(err, ErrUserNotFound) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	user.IsDisabled = isDisabled
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+
+	logger.Info("user moderated", slog.Bool("isDisabled", isDisabled))
+	return http.StatusOK, nil
+}
+
+func ChangePasswordCommand(ctx context.Context, conn *pgxpool.Pool, oldPassword string, newPassword string) (int, error) {
+	logger := slog.With(slog.String("command", "ChangePasswordCommand"))
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	logger.Info("changing password")
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+
+	err = user.SetPassword(oldPassword, newPassword)
+	if errors.Is(err, ErrBadPassword) || errors.Is(err, ErrPasswordTooShort) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+	// Whoever knew the old password must not stay logged in, the current session is kept
+	count, err := revokeOtherSessions(ctx, conn, user.Id, currentUser.SessionId, time.Now())
+	kcore.Expect(err, "")
+
+	logger.Info("password changed", slog.Int("revokedSessions", count))
+	return http.StatusOK, nil
+}
+
+// RequestPasswordResetCommand answers the same way whether the email is known or not, so that it can not be used to find accounts
+func RequestPasswordResetCommand(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string, email string) (int, error) {
+	logger := slog.With(slog.String("command", "RequestPasswordResetCommand"))
+	logger.Info("requesting password reset")
+	user, err := LoadUserByEmail(ctx, conn, email)
+	if errors.Is(err, ErrUserNotFound) {
+		logger.Warn(err.Error())
+		return http.StatusOK, nil
+	}
+	kcore.Expect(err, "")
+	logger = logger.With(slog.String("userId", user.Id.String()))
+	if user.IsDisabled {
+		logger.Warn(ErrUserDisabled.Error())
+		return http.StatusOK, nil
+	}
+	// The reset link is a login, it is only sent to an address that the user proved to own
+	if !user.IsEmailVerified() {
+		logger.Warn(ErrUserEmailNotVerified.Error())
+		return http.StatusOK, nil
+	}
+
+	token, err := NewPasswordResetToken(user.Id, time.Now())
+	kcore.Expect(err, "")
+	err = savePasswordResetToken(ctx, conn, token, time.Now())
+	kcore.Expect(err, "")
+
+	tr := kcore.GetTr(user)
+	link := fmt.Sprintf("%s/users/password_reset/%s", baseURL, token.Token)
+	err = mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: tr("passwordResetMailSubject"),
+		Body:    tr("passwordResetMailBody", user.Username, link, int(PasswordResetTokenLifetime.Minutes())),
+	})
+	// Failing only for known emails would reveal them, the user can ask again
+	if err != nil {
+		logger.Error(kcore.Wrap(err, "error sending password reset mail").Error())
+		return http.StatusOK, nil
+	}
+
+	logger.Info("password reset requested")
+	return http.StatusOK, nil
+}
+
+func ResetPasswordCommand(ctx context.Context, conn *pgxpool.Pool, token string, newPassword string) (int, error) {
+	logger := slog.With(slog.String("command", "ResetPasswordCommand"))
+	logger.Info("resetting password")
+	userId, err := loadPasswordResetTokenUserId(ctx, conn, token, time.Now())
+	if errors.Is(err, ErrPasswordResetTokenInvalid) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+	logger = logger.With(slog.String("userId", userId.String()))
+	user, err := LoadUser(ctx, conn, userId)
+	kcore.Expect(err, "")
+
+	err = user.ResetPassword(newPassword)
+	if errors.Is(err, ErrPasswordTooShort) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+
+	err = resetPassword(ctx, conn, user, token, time.Now())
+	if errors.Is(err, ErrPasswordResetTokenInvalid) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("password reset")
+	return http.StatusOK, nil
+}
+
+func RevokeSessionCommand(ctx context.Context, conn *pgxpool.Pool, sessionId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RevokeSessionCommand"), slog.String("sessionId", sessionId.String()))
+	logger.Info("revoking session")
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	err := revokeSession(ctx, conn, currentUser.Id, sessionId, time.Now())
+	if errors.Is(err, ErrSessionNotFound) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("session revoked")
+	return http.StatusOK, nil
+}
+
+func RevokeAllSessionsCommand(ctx context.Context, conn *pgxpool.Pool) (int, error) {
+	logger := slog.With(slog.String("command", "RevokeAllSessionsCommand"))
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	logger.Info("revoking all sessions")
+	count, err := revokeAllSessions(ctx, conn, currentUser.Id, time.Now())
+	kcore.Expect(err, "")
+
+	logger.Info("all sessions revoked", slog.Int("count", count))
+	return http.StatusOK, nil
+}
+
+func StartTOTPEnrollmentCommand(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring) (int, error) {
+	logger := slog.With(slog.String("command", "StartTOTPEnrollmentCommand"))
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	logger.Info("starting totp enrollment")
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+
+	err = user.StartTOTPEnrollment(keyring)
+	if errors.Is(err, ErrTOTPAlreadyEnabled) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+
+	logger.Info("totp enrollment started")
+	return http.StatusOK, nil
+}
+
+// ConfirmTOTPEnrollmentCommand returns the recovery codes, they can not be shown again
+func ConfirmTOTPEnrollmentCommand(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring, code string) ([]string, int, error) {
+	logger := slog.With(slog.String("command", "ConfirmTOTPEnrollmentCommand"))
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return nil, http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	logger.Info("confirming totp enrollment")
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+
+	err = user.ConfirmTOTPEnrollment(keyring, code, time.Now())
+	if errors.Is(err, ErrTOTPAlreadyEnabled) || errors.Is(err, ErrTOTPNotStarted) || errors.Is(err, ErrTOTPCodeInvalid) {
+		logger.Warn(err.Error())
+		return nil, http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+	recoveryCodes, err := newRecoveryCodes()
+	kcore.Expect(err, "")
+	err = saveRecoveryCodes(ctx, conn, user.Id, recoveryCodes)
+	kcore.Expect(err, "")
+
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+	err = verifySessionSecondFactor(ctx, conn, currentUser.SessionId)
+	kcore.Expect(err, "")
+
+	logger.Info("totp enrollment confirmed")
+	return recoveryCodes, http.StatusOK, nil
+}
+
+func DisableTOTPCommand(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring, code string) (int, error) {
+	logger := slog.With(slog.String("command", "DisableTOTPCommand"))
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	logger.Info("disabling totp")
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+
+	err = user.DisableTOTP(keyring, code, time.Now())
+	if errors.Is(err, ErrTOTPNotEnabled) || errors.Is(err, ErrTOTPCodeInvalid) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+	err = saveRecoveryCodes(ctx, conn, user.Id, nil)
+	kcore.Expect(err, "")
+
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+
+	logger.Info("totp disabled")
+	return http.StatusOK, nil
+}
+
+// CompleteTOTPLoginCommand is the second login step, code is either a TOTP code or one of the recovery codes
+func CompleteTOTPLoginCommand(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring, pendingLogin string, code string, userAgent string, ip string) (Session, int, error) {
+	logger := slog.With(slog.String("command", "CompleteTOTPLoginCommand"), slog.String("ip", ip))
+	now := time.Now()
+	userId, err := attemptPendingLogin(ctx, conn, pendingLogin, now)
+	if errors.Is(err, ErrPendingLoginInvalid) {
+		logger.Warn(err.Error())
+		return Session{}, http.StatusUnauthorized, err
+	}
+	kcore.Expect(err, "")
+	logger = logger.With(slog.String("userId", userId.String()))
+	logger.Info("completing totp login")
+	user, err := LoadUser(ctx, conn, userId)
+	kcore.Expect(err, "")
+	if user.IsDisabled {
+		logger.Warn(ErrUserDisabled.Error())
+		return Session{}, http.StatusForbidden, ErrUserDisabled
+	}
+	// Codes are throttled like passwords, so that a known password does not allow unlimited guesses over many logins
+	failures, err := reserveLoginAttempts(ctx, conn, user.Username, ip, now)
+	if err != nil {
+		logger.Warn(err.Error())
+		return Session{}, http.StatusTooManyRequests, err
+	}
+
+	err = user.CheckTOTP(keyring, code, now)
+	if errors.Is(err, ErrTOTPCodeInvalid) {
+		err = useRecoveryCode(ctx, conn, user.Id, code, now)
+		if err != nil {
+			logger.Warn(err.Error())
+			_, status, _ := failLogin(ctx, conn, logger, &user, failures, ip, now)
+			return Session{}, status, err
+		}
+		logger.Warn("recovery code used")
+	}
+	kcore.Expect(err, "")
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+	err = deletePendingLogin(ctx, conn, pendingLogin)
+	kcore.Expect(err, "")
+	err = clearLoginThrottle(ctx, conn, usernameThrottle, user.Username)
+	kcore.Expect(err, "")
+	err = releaseLoginAttempt(ctx, conn, ipThrottle, ip)
+	kcore.Expect(err, "")
+
+	session := NewSession(user.Id, userAgent, ip, now)
+	session.IsSecondFactorVerified = true
+	err = session.Save(ctx, conn)
+	kcore.Expect(err, "")
+
+	logger.Info("totp login completed")
+	return session, http.StatusOK, nil
+}
+
+// OIDCLogInCommand finds the user linked to the identity, a local account is created on the first login.
+// An existing account is never linked by email, its owner has to link the identity from their profile.
+func OIDCLogInCommand(ctx context.Context, conn *pgxpool.Pool, issuer string, claims oidc.Claims) (User, int, error) {
+	logger := slog.With(slog.String("command", "OIDCLogInCommand"), slog.String("issuer", issuer), slog.String("subject", claims.Subject))
+	now := time.Now()
+	userId, err := loadIdentityUserId(ctx, conn, issuer, claims.Subject)
+	if errors.Is(err, ErrIdentityNotFound) {
+		user, err := createIdentityUser(ctx, conn, issuer, claims, now)
+		kcore.Expect(err, "")
+		logger.Info("user created from identity", slog.String("userId", user.Id.String()))
+		return user, http.StatusOK, nil
+	}
+	kcore.Expect(err, "")
+	user, err := LoadUser(ctx, conn, userId)
+	kcore.Expect(err, "")
+	if user.IsDisabled {
+		logger.Warn(ErrUserDisabled.Error())
+		return User{}, http.StatusForbidden, ErrUserDisabled
+	}
+
+	logger.Info("user authenticated from identity", slog.String("userId", user.Id.String()))
+	return user, http.StatusOK, nil
+}
+
+// createIdentityUser has no password, the email is only kept when the issuer verified it and nobody uses it yet
+func createIdentityUser(ctx context.Context, conn *pgxpool.Pool, issuer string, claims oidc.Claims, now time.Time) (User, error) {
+	username, err := availableUsername(ctx, conn, claims.PreferredUsername, claims.Email)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := NewUser(username, languageFromContext(ctx))
+	if err != nil {
+		return User{}, err
+	}
+	user.PasswordHash = []byte{}
+	// The provider verified the address, it is taken from the accounts that only claimed it, unless another account verified it
+	if claims.EmailVerified && user.SetEmail(claims.Email) == nil {
+		_, err = LoadUserByEmail(ctx, conn, claims.Email)
+		if errors.Is(err, ErrUserNotFound) {
+			user.VerifyEmail(now)
+			err = reclaimEmail(ctx, conn, user.Id, user.Email)
+		} else if err == nil {
+			slog.Warn("oidc email kept unverified", slog.String("reason", ErrUserEmailTaken.Error()))
+		}
+		if err != nil {
+			return User{}, err
+		}
+	}
+	err = user.Save(ctx, conn)
+	if err != nil {
+		return User{}, err
+	}
+	return user, saveUserIdentity(ctx, conn, issuer, claims.Subject, user.Id, now)
+}
+
+func LinkIdentityCommand(ctx context.Context, conn *pgxpool.Pool, issuer string, claims oidc.Claims) (int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return http.StatusUnauthorized, ErrNotAuthenticated
+	}
+	logger := slog.With(slog.String("command", "LinkIdentityCommand"), slog.String("userId", currentUser.Id.String()), slog.String("issuer", issuer), slog.String("subject", claims.Subject))
+	userId, err := loadIdentityUserId(ctx, conn, issuer, claims.Subject)
+	if err == nil && userId == currentUser.Id {
+		return http.StatusOK, nil
+	} else if err == nil {
+		logger.Warn(ErrIdentityAlreadyLinked.Error())
+		return http.StatusConflict, ErrIdentityAlreadyLinked
+	} else if !errors.Is(err, ErrIdentityNotFound) {
+		kcore.Expect(err, "")
+	}
+	err = saveUserIdentity(ctx, conn, issuer, claims.Subject, currentUser.Id, time.Now())
+	kcore.Expect(err, "")
+
+	logger.Info("identity linked")
+	return http.StatusOK, nil
+}
+
+func sendEmailVerification(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string, user User) error {
+	now := time.Now()
+	token, err := NewEmailVerificationToken(user, now)
+	if err != nil {
+		return err
+	}
+	err = saveEmailVerificationToken(ctx, conn, token, now)
+	if err != nil {
+		return err
+	}
+	tr := kcore.GetTr(user)
+	link := fmt.Sprintf("%s/users/email_verification/%s", baseURL, token.Token)
+	err = mailer.Send(ctx, mail.Message{
+		To:      token.Email,
+		Subject: tr("emailVerificationMailSubject"),
+		Body:    tr("emailVerificationMailBody", user.Username, link, int(EmailVerificationTokenLifetime.Hours())),
+	})
+	if err != nil {
+		return kcore.Wrap(err, "error sending email verification mail")
+	}
+	return nil
+}
+
+// UpdateProfileCommand sends a verification link when the email address changes
+func UpdateProfileCommand(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string, email string, details ContactDetails) (int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return http.StatusUnauthorized, ErrNotAuthenticated
+	}
+	logger := slog.With(slog.String("command", "UpdateProfileCommand"), slog.String("userId", currentUser.Id.String()))
+	// The email receives the password resets, a leaked token must not be able to take over the account
+	if currentUser.APITokenScope != "" {
+		logger.Warn(ErrAPITokenNotAllowed.Error())
+		return http.StatusForbidden, ErrAPITokenNotAllowed
+	}
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+	emailChanged := email != user.Email
+	err = user.SetEmail(email)
+	if err == nil {
+		err = user.SetContactDetails(details, time.Now())
+	}
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = user.Save(ctx, conn)
+	if errors.Is(err, ErrUserEmailTaken) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+	if emailChanged && user.Email != "" {
+		err = sendEmailVerification(ctx, conn, mailer, baseURL, user)
+		if err != nil {
+			logger.Error(err.Error())
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	logger.Info("profile updated", slog.Bool("emailChanged", emailChanged))
+	return http.StatusOK, nil
+}
+
+func SendEmailVerificationCommand(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string) (int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return http.StatusUnauthorized, ErrNotAuthenticated
+	}
+	logger := slog.With(slog.String("command", "SendEmailVerificationCommand"), slog.String("userId", currentUser.Id.String()))
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+	if user.IsEmailVerified() {
+		return http.StatusOK, nil
+	}
+	err = sendEmailVerification(ctx, conn, mailer, baseURL, user)
+	if errors.Is(err, ErrEmailMissing) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	} else if err != nil {
+		logger.Error(err.Error())
+		return http.StatusInternalServerError, err
+	}
+
+	logger.Info("email verification sent")
+	return http.StatusOK, nil
+}
+
+// VerifyEmailCommand does not need the user to be logged in, the link can be opened on another device
+func VerifyEmailCommand(ctx context.Context, conn *pgxpool.Pool, token string) (int, error) {
+	logger := slog.With(slog.String("command", "VerifyEmailCommand"))
+	userId, err := verifyEmail(ctx, conn, token, time.Now())
+	if errors.Is(err, ErrEmailVerificationTokenInvalid) {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	} else if errors.Is(err, ErrUserEmailTaken) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("email verified", slog.String("userId", userId.String()))
+	return http.StatusOK, nil
+}
+
+func UpdateUserLanguageCommand(ctx context.Context, conn *pgxpool.Pool, language string) (int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return http.StatusUnauthorized, ErrNotAuthenticated
+	}
+	logger := slog.With(slog.String("command", "UpdateUserLanguageCommand"), slog.String("userId", currentUser.Id.String()), slog.String("language", language))
+	user, err := LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
+	err = user.SetLanguage(language)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = user.Save(ctx, conn)
+	kcore.Expect(err, "")
+
+	logger.Info("user language updated")
+	return http.StatusOK, nil
+}
+
+// CreateAPITokenCommand returns the secret of the token, it can not be shown again
+func CreateAPITokenCommand(ctx context.Context, conn *pgxpool.Pool, name string, scope APITokenScope) (string, int, error) {
+	logger := slog.With(slog.String("command", "CreateAPITokenCommand"), slog.String("scope", string(scope)))
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return "", http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	if currentUser.APITokenScope != "" {
+		logger.Warn(ErrAPITokenNotAllowed.Error())
+		return "", http.StatusForbidden, ErrAPITokenNotAllowed
+	}
+	logger.Info("creating api token")
+	token, secret, err := NewAPIToken(currentUser.Id, name, scope, time.Now())
+	if errors.Is(err, ErrAPITokenNameInvalid) || errors.Is(err, ErrAPITokenScopeInvalid) {
+		logger.Warn(err.Error())
+		return "", http.StatusBadRequest, err
+	}
+	kcore.Expect(err, "")
+	err = token.Save(ctx, conn, secret)
+	kcore.Expect(err, "")
+
+	logger.Info("api token created", slog.String("tokenId", token.Id.String()))
+	return secret, http.StatusOK, nil
+}
+
+func RevokeAPITokenCommand(ctx context.Context, conn *pgxpool.Pool, tokenId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RevokeAPITokenCommand"), slog.String("tokenId", tokenId.String()))
+	logger.Info("revoking api token")
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	if currentUser.APITokenScope != "" {
+		logger.Warn(ErrAPITokenNotAllowed.Error())
+		return http.StatusForbidden, ErrAPITokenNotAllowed
+	}
+	err := revokeAPIToken(ctx, conn, currentUser.Id, tokenId, time.Now())
+	if errors.Is(err, ErrAPITokenNotFound) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("api token revoked")
+	return http.StatusOK, nil
+}
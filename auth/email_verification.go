@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrEmailVerificationTokenInvalid = errors.New("email verification link is invalid or expired")
+	ErrEmailMissing                  = errors.New("no email address to verify")
+)
+
+const EmailVerificationTokenLifetime = 24 * time.Hour
+
+// EmailVerificationToken is bound to the address it was sent to, it can not verify an address set afterwards
+type EmailVerificationToken struct {
+	Token     string
+	UserId    kcore.ID
+	Email     string
+	ExpiresAt time.Time
+}
+
+func NewEmailVerificationToken(user User, now time.Time) (EmailVerificationToken, error) {
+	if user.Email == "" {
+		return EmailVerificationToken{}, ErrEmailMissing
+	}
+	token, err := newSecretToken()
+	if err != nil {
+		return EmailVerificationToken{}, err
+	}
+	return EmailVerificationToken{
+		Token:     token,
+		UserId:    user.Id,
+		Email:     user.Email,
+		ExpiresAt: now.Add(EmailVerificationTokenLifetime),
+	}, nil
+}
+
+func saveEmailVerificationToken(ctx context.Context, conn *pgxpool.Pool, token EmailVerificationToken, now time.Time) error {
+	_, err := conn.Exec(ctx, `
+	INSERT INTO email_verification_tokens (token_hash, user_id, email, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`, hashSecretToken(token.Token), token.UserId, token.Email, now, token.ExpiresAt)
+	if err != nil {
+		return kcore.Wrap(err, "error inserting email_verification_tokens table")
+	}
+	return nil
+}
+
+// verifyEmail uses the token and marks the address as verified together, it fails if the user changed their address since
+func verifyEmail(ctx context.Context, conn *pgxpool.Pool, token string, now time.Time) (kcore.ID, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	var userId kcore.ID
+	var email string
+	err = tx.QueryRow(ctx, `
+	UPDATE email_verification_tokens SET used_at = $2
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	RETURNING user_id, email
+	`, hashSecretToken(token), now).Scan(&userId, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return kcore.ID{}, ErrEmailVerificationTokenInvalid
+	} else if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error updating email_verification_tokens table")
+	}
+	err = reclaimEmail(ctx, tx, userId, email)
+	if err != nil {
+		return kcore.ID{}, err
+	}
+	tag, err := tx.Exec(ctx, `UPDATE users SET email_verified_at = $3 WHERE id = $1 AND email = $2`, userId, email, now)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "users_email_verified_key" {
+		return kcore.ID{}, ErrUserEmailTaken
+	} else if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error updating users table")
+	}
+	if tag.RowsAffected() == 0 {
+		return kcore.ID{}, ErrEmailVerificationTokenInvalid
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error committing transaction")
+	}
+	return userId, nil
+}
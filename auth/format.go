@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Layouts are translated as Go reference times, only the long weekday and month names are translated
+var dateNamePattern = regexp.MustCompile(`Monday|January`)
+
+func formatTime(login Login, t time.Time, layoutKey string) string {
+	layout := login.Tr(layoutKey)
+	var formatted strings.Builder
+	last := 0
+	for _, match := range dateNamePattern.FindAllStringIndex(layout, -1) {
+		formatted.WriteString(t.Format(layout[last:match[0]]))
+		if layout[match[0]:match[1]] == "Monday" {
+			formatted.WriteString(login.Tr("weekday_" + t.Weekday().String()))
+		} else {
+			formatted.WriteString(login.Tr("month_" + t.Month().String()))
+		}
+		last = match[1]
+	}
+	formatted.WriteString(t.Format(layout[last:]))
+	return formatted.String()
+}
+
+// FormatDateTime is for times shown in full, like race starts. The time must already be in the right location.
+func FormatDateTime(login Login, t time.Time) string {
+	return formatTime(login, t, "dateTimeLayout")
+}
+
+func FormatDate(login Login, t time.Time) string {
+	return formatTime(login, t, "dateLayout")
+}
+
+// FormatShortDateTime is for tables, like the sessions
+func FormatShortDateTime(login Login, t time.Time) string {
+	return formatTime(login, t, "shortDateTimeLayout")
+}
+
+// FormatNumber uses the decimal and grouping separators of the language
+func FormatNumber(login Login, value float64, decimals int) string {
+	printer := message.NewPrinter(language.Make(login.User.Language()))
+	return printer.Sprintf("%.*f", decimals, value)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+
+	"golang.org/x/text/language"
+)
+
+var ErrLanguageUnsupported = errors.New("language is not supported")
+
+const (
+	DefaultLanguage = "en-GB"
+	languageCookie  = "lang"
+)
+
+// Languages are the directories under locales/, read from the working directory like i18n.Default does
+var Languages = loadLanguages("locales")
+
+var languageMatcher = newLanguageMatcher(Languages)
+
+type languageContext struct{}
+
+func loadLanguages(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return []string{DefaultLanguage}
+	}
+	languages := []string{DefaultLanguage}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultLanguage {
+			languages = append(languages, entry.Name())
+		}
+	}
+	return languages
+}
+
+// newLanguageMatcher puts the default language first, as it is returned when nothing matches
+func newLanguageMatcher(languages []string) language.Matcher {
+	tags := make([]language.Tag, 0, len(languages))
+	for _, lang := range languages {
+		tags = append(tags, language.Make(lang))
+	}
+	return language.NewMatcher(tags)
+}
+
+// negotiateLanguage prefers the cookie set by the language selector over the browser settings
+func negotiateLanguage(r *http.Request) string {
+	cookie, err := r.Cookie(languageCookie)
+	if err == nil && slices.Contains(Languages, cookie.Value) {
+		return cookie.Value
+	}
+	preferred, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(preferred) == 0 {
+		return DefaultLanguage
+	}
+	_, index, _ := languageMatcher.Match(preferred...)
+	return Languages[index]
+}
+
+// LanguageMiddleware negotiates the language of anonymous visitors, logged in users have their own
+func LanguageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), languageContext{}, negotiateLanguage(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func languageFromContext(ctx context.Context) string {
+	lang, ok := ctx.Value(languageContext{}).(string)
+	if !ok {
+		return DefaultLanguage
+	}
+	return lang
+}
+
+func setLanguageCookie(w http.ResponseWriter, lang string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookie,
+		Value:    lang,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func loadLocaleKeys(t *testing.T, lang string) map[string]any {
+	content, err := os.ReadFile(filepath.Join("..", "locales", lang, "index.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]any)
+	err = yaml.Unmarshal(content, &keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// Missing keys are rendered as empty strings, empty values fall back to the default language
+func TestLocalesHaveAllDefaultKeys(t *testing.T) {
+	defaultKeys := loadLocaleKeys(t, DefaultLanguage)
+	for _, lang := range loadLanguages(filepath.Join("..", "locales")) {
+		keys := loadLocaleKeys(t, lang)
+		for key := range defaultKeys {
+			if _, ok := keys[key]; !ok {
+				t.Errorf("%s is missing %s", lang, key)
+			}
+		}
+	}
+}
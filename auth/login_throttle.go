@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrTooManyLoginAttempts = errors.New("too many login attempts, try again later")
+)
+
+type loginThrottleScope string
+
+const (
+	usernameThrottle loginThrottleScope = "username"
+	ipThrottle       loginThrottleScope = "ip"
+)
+
+type authenticationEventKind string
+
+const (
+	accountLockedEvent authenticationEventKind = "account_locked"
+)
+
+const (
+	// freeLoginFailures are allowed before the backoff starts, it then doubles with every failure
+	freeLoginFailures = 3
+	maxLoginBackoff   = 15 * time.Minute
+	// loginFailuresMemory forgets the failures of a key that stopped failing
+	loginFailuresMemory = time.Hour
+	// LockoutFailures consecutive failures on a username lock the account for LockoutDuration
+	LockoutFailures = 10
+	LockoutDuration = 15 * time.Minute
+	// authenticationAuditRetention is long enough to investigate an attack reported by a user
+	authenticationAuditRetention = 90 * 24 * time.Hour
+)
+
+type loginThrottle struct {
+	Failures     int
+	LastFailedAt time.Time
+}
+
+// retryAfter is zero when a new attempt is allowed
+func (throttle loginThrottle) retryAfter(now time.Time) time.Duration {
+	if throttle.Failures < freeLoginFailures || now.Sub(throttle.LastFailedAt) > loginFailuresMemory {
+		return 0
+	}
+	backoff := maxLoginBackoff
+	if throttle.Failures-freeLoginFailures < 10 {
+		backoff = min(time.Duration(1<<(throttle.Failures-freeLoginFailures))*time.Second, maxLoginBackoff)
+	}
+	return max(throttle.LastFailedAt.Add(backoff).Sub(now), 0)
+}
+
+// reserveLoginAttempt counts the attempt as a failure before the credentials are checked, so that parallel attempts can not all pass the throttle.
+// It returns the number of recent failures for the key, this attempt included.
+func reserveLoginAttempt(ctx context.Context, conn *pgxpool.Pool, scope loginThrottleScope, key string, now time.Time) (int, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	// The no-op update locks the row until the attempt is counted, parallel attempts on the same key wait for it
+	var throttle loginThrottle
+	err = tx.QueryRow(ctx, `
+	INSERT INTO login_throttles (scope, key, failures, last_failed_at)
+	VALUES ($1, $2, 0, $3)
+	ON CONFLICT (scope, key) DO UPDATE SET failures = login_throttles.failures
+	RETURNING failures, last_failed_at
+	`, scope, key, now).Scan(&throttle.Failures, &throttle.LastFailedAt)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error upserting login_throttles table")
+	}
+	if throttle.retryAfter(now) > 0 {
+		return 0, ErrTooManyLoginAttempts
+	}
+	var failures int
+	err = tx.QueryRow(ctx, `
+	UPDATE login_throttles SET
+		failures = CASE WHEN last_failed_at < $4 THEN 1 ELSE failures + 1 END,
+		last_failed_at = $3
+	WHERE scope = $1 AND key = $2
+	RETURNING failures
+	`, scope, key, now, now.Add(-loginFailuresMemory)).Scan(&failures)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error updating login_throttles table")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error committing transaction")
+	}
+	return failures, nil
+}
+
+// releaseLoginAttempt uncounts a reserved attempt that did not fail
+func releaseLoginAttempt(ctx context.Context, conn *pgxpool.Pool, scope loginThrottleScope, key string) error {
+	_, err := conn.Exec(ctx, `
+	UPDATE login_throttles SET failures = greatest(failures - 1, 0) WHERE scope = $1 AND key = $2
+	`, scope, key)
+	if err != nil {
+		return kcore.Wrap(err, "error updating login_throttles table")
+	}
+	return nil
+}
+
+func clearLoginThrottle(ctx context.Context, conn *pgxpool.Pool, scope loginThrottleScope, key string) error {
+	_, err := conn.Exec(ctx, `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`, scope, key)
+	if err != nil {
+		return kcore.Wrap(err, "error deleting login_throttles table")
+	}
+	return nil
+}
+
+func lockUser(ctx context.Context, conn *pgxpool.Pool, user User, ip string, now time.Time) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	_, err = tx.Exec(ctx, `UPDATE users SET locked_until = $2 WHERE id = $1`, user.Id, now.Add(LockoutDuration))
+	if err != nil {
+		return kcore.Wrap(err, "error updating users table")
+	}
+	_, err = tx.Exec(ctx, `
+	INSERT INTO authentication_audit_events (user_id, username, ip, kind, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`, user.Id, user.Username, ip, accountLockedEvent, now)
+	if err != nil {
+		return kcore.Wrap(err, "error inserting authentication_audit_events table")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
+
+// PruneLoginRecords deletes the throttles that no longer slow anything down, and the audit events past their retention
+func PruneLoginRecords(ctx context.Context, conn *pgxpool.Pool, now time.Time) (int64, error) {
+	tag, err := conn.Exec(ctx, `DELETE FROM login_throttles WHERE last_failed_at < $1`, now.Add(-loginFailuresMemory))
+	if err != nil {
+		return 0, kcore.Wrap(err, "error deleting login_throttles table")
+	}
+	pruned := tag.RowsAffected()
+	tag, err = conn.Exec(ctx, `DELETE FROM authentication_audit_events WHERE created_at < $1`, now.Add(-authenticationAuditRetention))
+	if err != nil {
+		return pruned, kcore.Wrap(err, "error deleting authentication_audit_events table")
+	}
+	return pruned + tag.RowsAffected(), nil
+}
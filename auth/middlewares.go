@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func clearAuthenticationCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    "authentication",
+		Value:   "",
+		Expires: time.Unix(0, 0),
+		Path:    "/",
+	})
+}
+
+// RefuseDisabledUserMiddleware runs after the cookie authentication, so that disabled accounts are logged out on their next request
+func RefuseDisabledUserMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if ok && user.IsDisabled {
+			slog.Warn(ErrUserDisabled.Error(), slog.String("userId", user.Id.String()))
+			clearAuthenticationCookie(w)
+			http.Error(w, ErrUserDisabled.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
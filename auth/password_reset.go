@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrPasswordResetTokenInvalid = errors.New("password reset link is invalid or expired")
+)
+
+const PasswordResetTokenLifetime = time.Hour
+
+// PasswordResetToken is only sent by email, the database only knows its hash
+type PasswordResetToken struct {
+	Token     string
+	UserId    kcore.ID
+	ExpiresAt time.Time
+}
+
+func NewPasswordResetToken(userId kcore.ID, now time.Time) (PasswordResetToken, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+	return PasswordResetToken{
+		Token:     token,
+		UserId:    userId,
+		ExpiresAt: now.Add(PasswordResetTokenLifetime),
+	}, nil
+}
+
+func savePasswordResetToken(ctx context.Context, conn *pgxpool.Pool, token PasswordResetToken, now time.Time) error {
+	_, err := conn.Exec(ctx, `
+	INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+	VALUES ($1, $2, $3, $4)
+	`, hashSecretToken(token.Token), token.UserId, now, token.ExpiresAt)
+	if err != nil {
+		return kcore.Wrap(err, "error inserting password_reset_tokens table")
+	}
+	return nil
+}
+
+func loadPasswordResetTokenUserId(ctx context.Context, conn *pgxpool.Pool, token string, now time.Time) (kcore.ID, error) {
+	var userId kcore.ID
+	err := conn.QueryRow(ctx, `
+	SELECT user_id FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	`, hashSecretToken(token), now).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return kcore.ID{}, ErrPasswordResetTokenInvalid
+	} else if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error selecting password_reset_tokens table")
+	}
+	return userId, nil
+}
+
+// resetPassword uses the token and saves the new password together, so that a token can not be used twice.
+// Other pending tokens and the sessions of the user are used up as well.
+func resetPassword(ctx context.Context, conn *pgxpool.Pool, user User, token string, now time.Time) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	tag, err := tx.Exec(ctx, `
+	UPDATE password_reset_tokens SET used_at = $3
+	WHERE token_hash = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > $3
+	`, hashSecretToken(token), user.Id, now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating password_reset_tokens table")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPasswordResetTokenInvalid
+	}
+	_, err = tx.Exec(ctx, `UPDATE password_reset_tokens SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`, user.Id, now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating password_reset_tokens table")
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1`, user.Id, user.PasswordHash)
+	if err != nil {
+		return kcore.Wrap(err, "error updating users table")
+	}
+	// Whoever knew the old password must not stay logged in
+	_, err = tx.Exec(ctx, `UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`, user.Id, now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating sessions table")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
@@ -3,26 +3,113 @@ package auth
 import (
 	"context"
 	"net/http"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/martinlehoux/kagamigo/kcore"
 )
 
 type UserListModel struct {
-	Username string
+	Id         kcore.ID
+	Username   string
+	IsAdmin    bool
+	IsDisabled bool
 }
 
-func UserListQuery(ctx context.Context, conn *pgxpool.Pool) ([]UserListModel, int, error) {
-	rows, err := conn.Query(ctx, `SELECT username FROM users`)
+// UserListQuery is only for admins, search matches part of the username
+func UserListQuery(ctx context.Context, conn *pgxpool.Pool, search string) ([]UserListModel, int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok || !currentUser.IsAdmin {
+		return nil, http.StatusUnauthorized, ErrUserNotAdmin
+	}
+	rows, err := conn.Query(ctx, `
+	SELECT id, username, is_admin, is_disabled
+	FROM users
+	WHERE username ILIKE '%' || $1 || '%'
+	ORDER BY username
+	`, search)
 	kcore.Expect(err, "error querying users")
-	defer rows.Close()
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByPos[UserListModel])
+	kcore.Expect(err, "error scanning users")
+
+	return users, http.StatusOK, nil
+}
+
+type SessionModel struct {
+	Id         kcore.ID
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IsCurrent  bool
+}
 
-	var users []UserListModel
-	for rows.Next() {
-		var user UserListModel
-		kcore.Expect(rows.Scan(&user.Username), "error scanning users")
-		users = append(users, user)
+// SessionListQuery lists the active sessions of the current user, the most recently used first
+func SessionListQuery(ctx context.Context, conn *pgxpool.Pool) ([]SessionModel, int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return nil, http.StatusUnauthorized, ErrNotAuthenticated
 	}
+	rows, err := conn.Query(ctx, `
+	SELECT id, user_agent, ip, created_at, last_seen_at, id = $2
+	FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $3
+	ORDER BY last_seen_at DESC
+	`, currentUser.Id, currentUser.SessionId, time.Now())
+	kcore.Expect(err, "error querying sessions")
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByPos[SessionModel])
+	kcore.Expect(err, "error scanning sessions")
 
-	return users, http.StatusOK, nil
+	return sessions, http.StatusOK, nil
+}
+
+type IdentityModel struct {
+	Issuer    string
+	CreatedAt time.Time
+}
+
+func IdentityListQuery(ctx context.Context, conn *pgxpool.Pool) ([]IdentityModel, int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return nil, http.StatusUnauthorized, ErrNotAuthenticated
+	}
+	rows, err := conn.Query(ctx, `
+	SELECT issuer, created_at
+	FROM user_identities
+	WHERE user_id = $1
+	ORDER BY created_at
+	`, currentUser.Id)
+	kcore.Expect(err, "error querying user identities")
+	identities, err := pgx.CollectRows(rows, pgx.RowToStructByPos[IdentityModel])
+	kcore.Expect(err, "error scanning user identities")
+
+	return identities, http.StatusOK, nil
+}
+
+type APITokenModel struct {
+	Id         kcore.ID
+	Name       string
+	Scope      APITokenScope
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// APITokenListQuery lists the tokens of the current user that are not revoked, their secrets are never shown again
+func APITokenListQuery(ctx context.Context, conn *pgxpool.Pool) ([]APITokenModel, int, error) {
+	currentUser, ok := UserFromContext(ctx)
+	if !ok {
+		return nil, http.StatusUnauthorized, ErrNotAuthenticated
+	}
+	rows, err := conn.Query(ctx, `
+	SELECT id, name, scope, created_at, last_used_at
+	FROM api_tokens
+	WHERE user_id = $1 AND revoked_at IS NULL
+	ORDER BY created_at
+	`, currentUser.Id)
+	kcore.Expect(err, "error querying api tokens")
+	tokens, err := pgx.CollectRows(rows, pgx.RowToStructByPos[APITokenModel])
+	kcore.Expect(err, "error scanning api tokens")
+
+	return tokens, http.StatusOK, nil
 }
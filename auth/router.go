@@ -2,9 +2,16 @@ package auth
 
 import (
 	"bike_race/config"
+	"bike_race/mail"
+	"bike_race/media"
+	"bike_race/oidc"
 	"context"
+	"crypto/subtle"
 	"errors"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -17,23 +24,58 @@ import (
 )
 
 var (
-	ErrNotAuthenticated = errors.New("not authenticated")
+	ErrNotAuthenticated  = errors.New("not authenticated")
+	ErrBadCredentials    = errors.New("incorrect username or password")
+	ErrOIDCLoginFailed   = errors.New("log in with the identity provider failed")
+	ErrOIDCStateMismatch = errors.New("oidc state does not match")
 )
 
-func Router(conn *pgxpool.Pool, config config.Config) *chi.Mux {
+const (
+	pendingLoginCookie = "pending_login"
+	oidcLoginCookie    = "oidc_login"
+)
+
+func Router(conn *pgxpool.Pool, config config.Config, mailer mail.Mailer) *chi.Mux {
 	router := chi.NewRouter()
+	provider := oidc.NewProvider(config.OIDC, config.BaseURL)
+	keyring := media.NewKeyring(config.MediaMasterKey, config.MediaPreviousMasterKey).WithPlaintext(config.MediaAllowPlaintext)
 
-	router.Post("/register", registerRoute(conn))
+	router.Post("/register", registerRoute(conn, mailer, config))
+	router.Post("/language", setLanguageRoute())
 	router.Post("/log_in", logInRoute(conn, config))
-	router.Post("/log_out", logOutRoute())
+	router.Post("/log_in/totp", totpLogInRoute(conn, keyring, config))
+	router.Post("/log_out", logOutRoute(conn))
+	router.Post("/log_out_everywhere", logOutEverywhereRoute(conn))
+	router.Post("/me/sessions/{sessionId}/revoke", revokeSessionRoute(conn))
+	router.Post("/me/password", changePasswordRoute(conn))
+	router.Post("/me/language", updateUserLanguageRoute(conn))
+	router.Post("/me/profile", updateProfileRoute(conn, mailer, config))
+	router.Post("/me/email_verification", sendEmailVerificationRoute(conn, mailer, config))
+	router.Post("/me/totp/start", startTOTPEnrollmentRoute(conn, keyring))
+	router.Post("/me/totp/confirm", confirmTOTPEnrollmentRoute(conn, keyring))
+	router.Post("/me/totp/disable", disableTOTPRoute(conn, keyring))
+	router.Post("/me/api_tokens", createAPITokenRoute(conn))
+	router.Post("/me/api_tokens/{tokenId}/revoke", revokeAPITokenRoute(conn))
+	router.Post("/password_reset", requestPasswordResetRoute(conn, mailer, config))
+	router.Post("/password_reset/{token}", resetPasswordRoute(conn))
+	router.Post("/email_verification/{token}", verifyEmailRoute(conn))
+
+	router.Get("/log_in/totp", viewTOTPLogInRoute())
+	router.Get("/password_reset", viewPasswordResetRequestRoute())
+	router.Get("/password_reset/{token}", viewPasswordResetRoute())
+	router.Get("/email_verification/{token}", viewEmailVerificationRoute())
+
+	router.Get("/me", viewUserMeRoute(conn, keyring, provider))
 
-	router.Get("/me", viewUserMeRoute())
-	router.Get("/", viewUsersRoute(conn))
+	if provider != nil {
+		router.Get("/oidc/log_in", oidcLogInRoute(provider))
+		router.Get("/oidc/callback", oidcCallbackRoute(conn, provider, config))
+	}
 
 	return router
 }
 
-func viewUsersRoute(conn *pgxpool.Pool) http.HandlerFunc {
+func viewUserMeRoute(conn *pgxpool.Pool, keyring media.Keyring, provider *oidc.Provider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		login := LoginFromContext(ctx)
@@ -41,32 +83,173 @@ func viewUsersRoute(conn *pgxpool.Pool) http.HandlerFunc {
 			kauth.Unauthorized(w, ErrNotAuthenticated)
 			return
 		}
-		users, code, err := UserListQuery(ctx, conn)
+		sessions, code, err := SessionListQuery(ctx, conn)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		identities, code, err := IdentityListQuery(ctx, conn)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		apiTokens, code, err := APITokenListQuery(ctx, conn)
 		if err != nil {
 			http.Error(w, err.Error(), code)
 			return
 		}
-		page := UsersPage(login, users)
+		totpEnrollment, err := login.User.TOTPEnrollment(keyring)
+		kcore.Expect(err, "")
+		page := MePage(login, sessions, identities, apiTokens, totpEnrollment, providerName(provider))
 		kcore.RenderPage(ctx, page, w)
 	}
 }
 
-func viewUserMeRoute() http.HandlerFunc {
+func registerRoute(conn *pgxpool.Pool, mailer mail.Mailer, config config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		login := LoginFromContext(r.Context())
-		if !login.Ok {
-			kauth.Unauthorized(w, ErrNotAuthenticated)
+		ctx := r.Context()
+		code, err := RegisterUserCommand(ctx, conn, mailer, config.BaseURL, r.FormValue("username"), r.FormValue("email"), r.FormValue("password"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		}
+	}
+}
+
+// updateUserLanguageRoute also sets the cookie, so that the language is kept after logging out
+func updateUserLanguageRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := UpdateUserLanguageCommand(ctx, conn, r.FormValue("language"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		setLanguageCookie(w, r.FormValue("language"))
+		http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+	}
+}
+
+// setLanguageRoute is the language selector of anonymous visitors
+func setLanguageRoute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(Languages, r.FormValue("language")) {
+			slog.Warn(ErrLanguageUnsupported.Error(), slog.String("language", r.FormValue("language")))
+			http.Error(w, ErrLanguageUnsupported.Error(), http.StatusBadRequest)
 			return
 		}
-		page := MePage(login)
+		setLanguageCookie(w, r.FormValue("language"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+func updateProfileRoute(conn *pgxpool.Pool, mailer mail.Mailer, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		details := ContactDetails{
+			FullName: r.FormValue("full_name"),
+			Gender:   Gender(r.FormValue("gender")),
+			Phone:    r.FormValue("phone"),
+		}
+		if r.FormValue("birth_date") != "" {
+			birthDate, err := time.Parse("2006-01-02", r.FormValue("birth_date"))
+			if err != nil {
+				err = kcore.Wrap(err, "error parsing birth_date")
+				slog.Warn(err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			details.BirthDate = birthDate
+		}
+		code, err := UpdateProfileCommand(ctx, conn, mailer, config.BaseURL, r.FormValue("email"), details)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		}
+	}
+}
+
+func sendEmailVerificationRoute(conn *pgxpool.Pool, mailer mail.Mailer, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := SendEmailVerificationCommand(ctx, conn, mailer, config.BaseURL)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		}
+	}
+}
+
+// viewEmailVerificationRoute asks for a confirmation, so that link previews do not use the token
+func viewEmailVerificationRoute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := LoginFromContext(r.Context())
+		page := EmailVerificationPage(login, chi.URLParam(r, "token"))
 		kcore.RenderPage(r.Context(), page, w)
 	}
 }
 
-func registerRoute(conn *pgxpool.Pool) http.HandlerFunc {
+func verifyEmailRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		code, err := RegisterUserCommand(ctx, conn, r.FormValue("username"), r.FormValue("password"))
+		code, err := VerifyEmailCommand(ctx, conn, chi.URLParam(r, "token"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else if LoginFromContext(ctx).Ok {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		} else {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		}
+	}
+}
+
+func changePasswordRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := ChangePasswordCommand(ctx, conn, r.FormValue("old_password"), r.FormValue("new_password"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		}
+	}
+}
+
+func viewPasswordResetRequestRoute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := LoginFromContext(r.Context())
+		page := PasswordResetRequestPage(login, r.URL.Query().Has("sent"))
+		kcore.RenderPage(r.Context(), page, w)
+	}
+}
+
+func requestPasswordResetRoute(conn *pgxpool.Pool, mailer mail.Mailer, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := RequestPasswordResetCommand(ctx, conn, mailer, config.BaseURL, r.FormValue("email"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/password_reset?sent", http.StatusSeeOther)
+		}
+	}
+}
+
+func viewPasswordResetRoute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := LoginFromContext(r.Context())
+		page := PasswordResetPage(login, chi.URLParam(r, "token"))
+		kcore.RenderPage(r.Context(), page, w)
+	}
+}
+
+func resetPasswordRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := ResetPasswordCommand(ctx, conn, chi.URLParam(r, "token"), r.FormValue("new_password"))
 		if err != nil {
 			http.Error(w, err.Error(), code)
 		} else {
@@ -78,57 +261,367 @@ func registerRoute(conn *pgxpool.Pool) http.HandlerFunc {
 func logInRoute(conn *pgxpool.Pool, config config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		user, code, err := AuthenticateUser(ctx, conn, r.FormValue("username"), r.FormValue("password"))
+		user, code, err := AuthenticateUser(ctx, conn, r.FormValue("username"), r.FormValue("password"), clientIP(r))
 		if err != nil {
 			http.Error(w, err.Error(), code)
 			return
+		}
+		startSession(w, r, conn, config, user)
+	}
+}
+
+// startSession asks for the second factor first when the user enabled it
+func startSession(w http.ResponseWriter, r *http.Request, conn *pgxpool.Pool, config config.Config, user User) {
+	ctx := r.Context()
+	if user.TOTPEnabled {
+		pendingLogin, err := newPendingLogin(ctx, conn, user.Id, time.Now())
+		kcore.Expect(err, "error saving pending login")
+		http.SetCookie(w, &http.Cookie{
+			Name:     pendingLoginCookie,
+			Value:    pendingLogin,
+			Path:     "/users/log_in",
+			MaxAge:   int(PendingLoginLifetime.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/users/log_in/totp", http.StatusSeeOther)
+		return
+	}
+	session := NewSession(user.Id, r.UserAgent(), clientIP(r), time.Now())
+	err := session.Save(ctx, conn)
+	kcore.Expect(err, "error saving session")
+	cookie := kauth.CraftCookie(session.Id, config.Auth)
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func providerName(provider *oidc.Provider) string {
+	if provider == nil {
+		return ""
+	}
+	return provider.Name
+}
+
+// oidcLogInRoute keeps the state, the nonce and the PKCE verifier in a cookie until the issuer redirects back
+func oidcLogInRoute(provider *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		secrets := make([]string, 3)
+		for i := range secrets {
+			secret, err := oidc.RandomString()
+			kcore.Expect(err, "")
+			secrets[i] = secret
+		}
+		authCodeURL, err := provider.AuthCodeURL(ctx, secrets[0], secrets[1], secrets[2])
+		if err != nil {
+			slog.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcLoginCookie,
+			Value:    strings.Join(secrets, "."),
+			Path:     "/users/oidc",
+			MaxAge:   int((10 * time.Minute).Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authCodeURL, http.StatusSeeOther)
+	}
+}
+
+// oidcCallbackRoute logs in, or links the identity when the user is already logged in
+func oidcCallbackRoute(conn *pgxpool.Pool, provider *oidc.Provider, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		claims, err := oidcClaims(w, r, provider)
+		if err != nil {
+			slog.Warn(err.Error())
+			http.Error(w, ErrOIDCLoginFailed.Error(), http.StatusUnauthorized)
+			return
+		}
+		if login := LoginFromContext(ctx); login.Ok {
+			code, err := LinkIdentityCommand(ctx, conn, provider.Issuer(), claims)
+			if err != nil {
+				http.Error(w, err.Error(), code)
+			} else {
+				http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+			}
+			return
+		}
+		user, code, err := OIDCLogInCommand(ctx, conn, provider.Issuer(), claims)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		startSession(w, r, conn, config, user)
+	}
+}
+
+// oidcClaims checks the state against the cookie before redeeming the code, the cookie is used only once
+func oidcClaims(w http.ResponseWriter, r *http.Request, provider *oidc.Provider) (oidc.Claims, error) {
+	cookie, err := r.Cookie(oidcLoginCookie)
+	if err != nil {
+		return oidc.Claims{}, kcore.Wrap(err, "missing oidc login cookie")
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcLoginCookie, Value: "", Path: "/users/oidc", MaxAge: -1})
+	secrets := strings.Split(cookie.Value, ".")
+	if len(secrets) != 3 || subtle.ConstantTimeCompare([]byte(secrets[0]), []byte(r.URL.Query().Get("state"))) != 1 {
+		return oidc.Claims{}, ErrOIDCStateMismatch
+	}
+	if r.URL.Query().Get("error") != "" {
+		return oidc.Claims{}, errors.New(r.URL.Query().Get("error"))
+	}
+	return provider.Exchange(r.Context(), r.URL.Query().Get("code"), secrets[2], secrets[1])
+}
+
+func viewTOTPLogInRoute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := LoginFromContext(r.Context())
+		page := TOTPLogInPage(login)
+		kcore.RenderPage(r.Context(), page, w)
+	}
+}
+
+func totpLogInRoute(conn *pgxpool.Pool, keyring media.Keyring, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		pendingLogin, err := r.Cookie(pendingLoginCookie)
+		if err != nil {
+			slog.Warn(ErrPendingLoginInvalid.Error())
+			http.Error(w, ErrPendingLoginInvalid.Error(), http.StatusUnauthorized)
+			return
+		}
+		session, code, err := CompleteTOTPLoginCommand(ctx, conn, keyring, pendingLogin.Value, r.FormValue("code"), r.UserAgent(), clientIP(r))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: pendingLoginCookie, Value: "", Path: "/users/log_in", MaxAge: -1})
+		cookie := kauth.CraftCookie(session.Id, config.Auth)
+		http.SetCookie(w, &cookie)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+func startTOTPEnrollmentRoute(conn *pgxpool.Pool, keyring media.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := StartTOTPEnrollmentCommand(ctx, conn, keyring)
+		if err != nil {
+			http.Error(w, err.Error(), code)
 		} else {
-			cookie := kauth.CraftCookie(user.Id, config.Auth)
-			http.SetCookie(w, &cookie)
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
 		}
 	}
 }
 
-func logOutRoute() http.HandlerFunc {
+// confirmTOTPEnrollmentRoute renders the recovery codes instead of redirecting, as they are not stored in clear
+func confirmTOTPEnrollmentRoute(conn *pgxpool.Pool, keyring media.Keyring) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		_, ok := kauth.UserFromContext[User](ctx)
+		recoveryCodes, code, err := ConfirmTOTPEnrollmentCommand(ctx, conn, keyring, r.FormValue("code"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		page := RecoveryCodesPage(LoginFromContext(ctx), recoveryCodes)
+		kcore.RenderPage(ctx, page, w)
+	}
+}
+
+func disableTOTPRoute(conn *pgxpool.Pool, keyring media.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := DisableTOTPCommand(ctx, conn, keyring, r.FormValue("code"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		}
+	}
+}
+
+func logOutRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := UserFromContext(ctx)
 		if !ok {
 			kauth.Unauthorized(w, ErrNotAuthenticated)
 			return
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:    "authentication",
-			Value:   "",
-			Expires: time.Unix(0, 0),
-			Path:    "/",
-		})
+		code, err := RevokeSessionCommand(ctx, conn, user.SessionId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		clearAuthenticationCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+func logOutEverywhereRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code, err := RevokeAllSessionsCommand(ctx, conn)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		clearAuthenticationCookie(w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
-func AuthenticateUser(ctx context.Context, conn *pgxpool.Pool, username string, password string) (User, int, error) {
-	slog.Info("Authenticating user", slog.String("username", username))
+func revokeSessionRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		sessionId, err := kcore.ParseID(chi.URLParam(r, "sessionId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing sessionId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := RevokeSessionCommand(ctx, conn, sessionId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		}
+	}
+}
+
+func createAPITokenRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		secret, code, err := CreateAPITokenCommand(ctx, conn, r.FormValue("name"), APITokenScope(r.FormValue("scope")))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		page := APITokenCreatedPage(LoginFromContext(ctx), secret)
+		kcore.RenderPage(ctx, page, w)
+	}
+}
+
+func revokeAPITokenRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tokenId, err := kcore.ParseID(chi.URLParam(r, "tokenId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing tokenId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := RevokeAPITokenCommand(ctx, conn, tokenId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		}
+	}
+}
+
+// clientIP is only informative, the server is expected to be reached directly
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// dummyPasswordHash is compared for unknown usernames, so that they take as long as a bad password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// AuthenticateUser answers the same for an unknown username and a bad password, so that it can not be used to find accounts.
+// Attempts are throttled by username and by IP, and repeated failures lock the account.
+func AuthenticateUser(ctx context.Context, conn *pgxpool.Pool, username string, password string, ip string) (User, int, error) {
+	logger := slog.With(slog.String("username", username), slog.String("ip", ip))
+	logger.Info("Authenticating user")
+	now := time.Now()
+	failures, err := reserveLoginAttempts(ctx, conn, username, ip, now)
+	if err != nil {
+		logger.Warn(err.Error())
+		return User{}, http.StatusTooManyRequests, err
+	}
 	var user User
-	err := conn.QueryRow(ctx, `
-		SELECT id, username, password_hash
+	var lockedUntil *time.Time
+	err = conn.QueryRow(ctx, `
+		SELECT id, username, password_hash, is_disabled, totp_enabled, locked_until
 		FROM users
 		WHERE username = $1
-	`, username).Scan(&user.Id, &user.Username, &user.PasswordHash)
+	`, username).Scan(&user.Id, &user.Username, &user.PasswordHash, &user.IsDisabled, &user.TOTPEnabled, &lockedUntil)
 	if errors.Is(err, pgx.ErrNoRows) {
-		slog.Warn(ErrUserNotFound.Error())
-		return User{}, http.StatusNotFound, ErrUserNotFound
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		logger.Warn(ErrUserNotFound.Error())
+		return failLogin(ctx, conn, logger, nil, failures, ip, now)
 	}
 	kcore.Expect(err, "error querying user")
 
+	// Users created through OpenID Connect have an empty hash until they set a password
 	err = bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password))
-	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-		slog.Warn(ErrBadPassword.Error())
-		return User{}, http.StatusBadRequest, ErrBadPassword
+	// The lock is checked after the hash, whatever the password, so that locked accounts answer like throttled ones in the same time
+	if lockedUntil != nil && now.Before(*lockedUntil) {
+		releaseLoginAttempts(ctx, conn, username, ip)
+		logger.Warn(ErrTooManyLoginAttempts.Error(), slog.Time("lockedUntil", *lockedUntil))
+		return User{}, http.StatusTooManyRequests, ErrTooManyLoginAttempts
+	}
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrHashTooShort) {
+		logger.Warn(ErrBadPassword.Error())
+		return failLogin(ctx, conn, logger, &user, failures, ip, now)
 	}
 	kcore.Expect(err, "error comparing password hash")
+	if user.IsDisabled {
+		releaseLoginAttempts(ctx, conn, username, ip)
+		logger.Warn(ErrUserDisabled.Error())
+		return User{}, http.StatusForbidden, ErrUserDisabled
+	}
+	// With two-factor authentication, the failures are only forgotten once the second factor is checked
+	if user.TOTPEnabled {
+		releaseLoginAttempts(ctx, conn, username, ip)
+	} else {
+		err = clearLoginThrottle(ctx, conn, usernameThrottle, username)
+		kcore.Expect(err, "")
+		err = releaseLoginAttempt(ctx, conn, ipThrottle, ip)
+		kcore.Expect(err, "")
+	}
 
-	slog.Info("User authenticated", slog.String("username", username))
+	logger.Info("User authenticated")
 	return user, http.StatusOK, nil
 }
+
+// reserveLoginAttempts counts the attempt on the IP and the username, and returns the failures of the username
+func reserveLoginAttempts(ctx context.Context, conn *pgxpool.Pool, username string, ip string, now time.Time) (int, error) {
+	_, err := reserveLoginAttempt(ctx, conn, ipThrottle, ip, now)
+	if errors.Is(err, ErrTooManyLoginAttempts) {
+		return 0, err
+	}
+	kcore.Expect(err, "")
+	failures, err := reserveLoginAttempt(ctx, conn, usernameThrottle, username, now)
+	if errors.Is(err, ErrTooManyLoginAttempts) {
+		err = releaseLoginAttempt(ctx, conn, ipThrottle, ip)
+		kcore.Expect(err, "")
+		return 0, ErrTooManyLoginAttempts
+	}
+	kcore.Expect(err, "")
+	return failures, nil
+}
+
+// releaseLoginAttempts is for attempts that were refused for another reason than the credentials
+func releaseLoginAttempts(ctx context.Context, conn *pgxpool.Pool, username string, ip string) {
+	kcore.Expect(releaseLoginAttempt(ctx, conn, ipThrottle, ip), "")
+	kcore.Expect(releaseLoginAttempt(ctx, conn, usernameThrottle, username), "")
+}
+
+// failLogin keeps the reserved attempt as a failure and locks the account after too many, user is nil when the username is unknown
+func failLogin(ctx context.Context, conn *pgxpool.Pool, logger *slog.Logger, user *User, failures int, ip string, now time.Time) (User, int, error) {
+	if user != nil && failures >= LockoutFailures {
+		err := lockUser(ctx, conn, *user, ip, now)
+		kcore.Expect(err, "")
+		logger.Warn("account locked", slog.Int("failures", failures))
+	}
+	return User{}, http.StatusUnauthorized, ErrBadCredentials
+}
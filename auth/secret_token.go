@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+// newSecretToken is given to the user once, only its hash is stored
+func newSecretToken() (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", kcore.Wrap(err, "error generating secret token")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashSecretToken does not need a salt, as secret tokens are random
+func hashSecretToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
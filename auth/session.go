@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionLifetime matches the expiration of the cookies crafted by kauth, that hold the session id instead of the user id
+const SessionLifetime = 24 * time.Hour
+
+// lastSeenPrecision limits the writes made by every authenticated request
+const lastSeenPrecision = time.Minute
+
+type Session struct {
+	Id         kcore.ID
+	UserId     kcore.ID
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// IsSecondFactorVerified is set when the login checked a TOTP code
+	IsSecondFactorVerified bool
+}
+
+func NewSession(userId kcore.ID, userAgent string, ip string, now time.Time) Session {
+	return Session{
+		Id:         kcore.NewID(),
+		UserId:     userId,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionLifetime),
+	}
+}
+
+func (session Session) Save(ctx context.Context, conn *pgxpool.Pool) error {
+	_, err := conn.Exec(ctx, `
+	INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, is_second_factor_verified)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, session.Id, session.UserId, session.UserAgent, session.IP, session.CreatedAt, session.LastSeenAt, session.ExpiresAt, session.IsSecondFactorVerified)
+	if err != nil {
+		return kcore.Wrap(err, "error inserting sessions table")
+	}
+	return nil
+}
+
+// LoadSessionUser is given to kauth.CookieAuthMiddleware, revoked and expired sessions are not found
+func LoadSessionUser(ctx context.Context, conn *pgxpool.Pool, sessionId kcore.ID, now time.Time) (User, error) {
+	var userId kcore.ID
+	var lastSeenAt time.Time
+	var isSecondFactorVerified bool
+	err := conn.QueryRow(ctx, `
+	SELECT user_id, last_seen_at, is_second_factor_verified FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2
+	`, sessionId, now).Scan(&userId, &lastSeenAt, &isSecondFactorVerified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrSessionNotFound
+	} else if err != nil {
+		return User{}, kcore.Wrap(err, "error selecting sessions table")
+	}
+	if now.Sub(lastSeenAt) > lastSeenPrecision {
+		_, err = conn.Exec(ctx, `UPDATE sessions SET last_seen_at = $2 WHERE id = $1`, sessionId, now)
+		if err != nil {
+			return User{}, kcore.Wrap(err, "error updating sessions table")
+		}
+	}
+	user, err := LoadUser(ctx, conn, userId)
+	if err != nil {
+		return User{}, err
+	}
+	user.SessionId = sessionId
+	user.isSessionSecondFactorVerified = isSecondFactorVerified
+	return user, nil
+}
+
+// verifySessionSecondFactor is used when the enrollment is confirmed, as the session just checked a code
+func verifySessionSecondFactor(ctx context.Context, conn *pgxpool.Pool, sessionId kcore.ID) error {
+	_, err := conn.Exec(ctx, `UPDATE sessions SET is_second_factor_verified = true WHERE id = $1`, sessionId)
+	if err != nil {
+		return kcore.Wrap(err, "error updating sessions table")
+	}
+	return nil
+}
+
+func revokeSession(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, sessionId kcore.ID, now time.Time) error {
+	tag, err := conn.Exec(ctx, `
+	UPDATE sessions SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionId, userId, now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating sessions table")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func revokeOtherSessions(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, sessionId kcore.ID, now time.Time) (int, error) {
+	tag, err := conn.Exec(ctx, `
+	UPDATE sessions SET revoked_at = $3 WHERE user_id = $1 AND id != $2 AND revoked_at IS NULL AND expires_at > $3
+	`, userId, sessionId, now)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error updating sessions table")
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func revokeAllSessions(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, now time.Time) (int, error) {
+	tag, err := conn.Exec(ctx, `
+	UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+	`, userId, now)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error updating sessions table")
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -2,9 +2,6 @@ package auth
 
 import (
 	"net/http"
-
-	"github.com/martinlehoux/kagamigo/kauth"
-	"github.com/martinlehoux/kagamigo/kcore"
 )
 
 type TemplateData[T any] struct {
@@ -15,11 +12,11 @@ type TemplateData[T any] struct {
 }
 
 func GetTemplateData[T any](r *http.Request, data T) TemplateData[T] {
-	user, ok := kauth.UserFromContext[User](r.Context())
+	login := LoginFromContext(r.Context())
 	return TemplateData[T]{
-		Ok:          ok,
-		CurrentUser: user,
-		T:           kcore.GetTr(user),
+		Ok:          login.Ok,
+		CurrentUser: login.User,
+		T:           login.Tr,
 		Data:        data,
 	}
 }
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"bike_race/media"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // RFC 6238 defaults to SHA-1, that authenticator apps expect
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotStarted     = errors.New("two-factor authentication enrollment was not started")
+	ErrTOTPCodeInvalid    = errors.New("two-factor authentication code is invalid")
+	ErrTOTPRequired       = errors.New("log in with two-factor authentication for this action")
+)
+
+// TOTP codes follow RFC 6238 with the defaults of authenticator apps
+const (
+	totpIssuer     = "Bike Race"
+	totpSecretSize = 20
+	totpPeriod     = 30 * time.Second
+	totpDigits     = 6
+	// totpSkew accepts the codes of the previous and next periods, for clocks that drifted
+	totpSkew = 1
+	// RecoveryCodesCount are given once, when the enrollment is confirmed
+	RecoveryCodesCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// verifyTOTP returns the step of the matching code, it must be after lastStep so that a code can not be replayed
+func verifyTOTP(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func newTOTPSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error generating totp secret")
+	}
+	return secret, nil
+}
+
+// newRecoveryCodes are random enough to only be stored as a sha256 hash
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodesCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error generating recovery code")
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// TOTPEnrollmentModel is only shown while enrolling, the secret is encrypted with the media keyring the rest of the time
+type TOTPEnrollmentModel struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+func (user User) TOTPEnrollment(keyring media.Keyring) (TOTPEnrollmentModel, error) {
+	if !user.IsEnrollingTOTP() {
+		return TOTPEnrollmentModel{}, nil
+	}
+	secret, err := keyring.DecryptBytes(user.totpSecret)
+	if err != nil {
+		return TOTPEnrollmentModel{}, kcore.Wrap(err, "error decrypting totp secret")
+	}
+	encodedSecret := totpEncoding.EncodeToString(secret)
+	return TOTPEnrollmentModel{Secret: encodedSecret, ProvisioningURI: totpProvisioningURI(user.Username, encodedSecret)}, nil
+}
+
+// totpProvisioningURI is the content of the QR code read by authenticator apps
+func totpProvisioningURI(username string, encodedSecret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	query := url.Values{}
+	query.Set("secret", encodedSecret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// HasVerifiedSecondFactor is only true when the current session was created with a TOTP code, not only when it is enabled
+func (user User) HasVerifiedSecondFactor() bool {
+	return user.TOTPEnabled && user.isSessionSecondFactorVerified
+}
+
+// IsEnrollingTOTP is true between the start of the enrollment and its confirmation with a first code
+func (user User) IsEnrollingTOTP() bool {
+	return user.totpSecret != nil && !user.TOTPEnabled
+}
+
+func (user *User) StartTOTPEnrollment(keyring media.Keyring) error {
+	if user.TOTPEnabled {
+		return ErrTOTPAlreadyEnabled
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return err
+	}
+	encryptedSecret, err := keyring.EncryptBytes(secret)
+	if err != nil {
+		return kcore.Wrap(err, "error encrypting totp secret")
+	}
+	user.totpSecret = encryptedSecret
+	user.totpLastStep = 0
+	return nil
+}
+
+func (user *User) ConfirmTOTPEnrollment(keyring media.Keyring, code string, now time.Time) error {
+	if user.TOTPEnabled {
+		return ErrTOTPAlreadyEnabled
+	}
+	if user.totpSecret == nil {
+		return ErrTOTPNotStarted
+	}
+	err := user.CheckTOTP(keyring, code, now)
+	if err != nil {
+		return err
+	}
+	user.TOTPEnabled = true
+	return nil
+}
+
+// CheckTOTP decrypts the secret, secrets stored before encryption are still read as plaintext
+func (user *User) CheckTOTP(keyring media.Keyring, code string, now time.Time) error {
+	secret, err := keyring.DecryptBytes(user.totpSecret)
+	if err != nil {
+		return kcore.Wrap(err, "error decrypting totp secret")
+	}
+	step, ok := verifyTOTP(secret, strings.TrimSpace(code), now, user.totpLastStep)
+	if !ok {
+		return ErrTOTPCodeInvalid
+	}
+	user.totpLastStep = step
+	return nil
+}
+
+func (user *User) DisableTOTP(keyring media.Keyring, code string, now time.Time) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	err := user.CheckTOTP(keyring, code, now)
+	if err != nil {
+		return err
+	}
+	user.TOTPEnabled = false
+	user.totpSecret = nil
+	user.totpLastStep = 0
+	return nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrPendingLoginInvalid = errors.New("login expired, log in again")
+)
+
+const (
+	PendingLoginLifetime = 5 * time.Minute
+	// pendingLoginAttempts limits the guesses of the 6 digits codes
+	pendingLoginAttempts = 5
+)
+
+// saveRecoveryCodes replaces the previous codes of the user
+func saveRecoveryCodes(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, codes []string) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return kcore.Wrap(err, "error deleting totp_recovery_codes table")
+	}
+	for _, code := range codes {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userId, hashSecretToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return kcore.Wrap(err, "error inserting totp_recovery_codes table")
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
+
+func useRecoveryCode(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, code string, now time.Time) error {
+	tag, err := conn.Exec(ctx, `
+	UPDATE totp_recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userId, hashSecretToken(normalizeRecoveryCode(code)), now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating totp_recovery_codes table")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPCodeInvalid
+	}
+	return nil
+}
+
+// newPendingLogin returns the token to keep in a cookie until the second factor is checked
+func newPendingLogin(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID, now time.Time) (string, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Exec(ctx, `
+	INSERT INTO pending_logins (token_hash, user_id, expires_at) VALUES ($1, $2, $3)
+	`, hashSecretToken(token), userId, now.Add(PendingLoginLifetime))
+	if err != nil {
+		return "", kcore.Wrap(err, "error inserting pending_logins table")
+	}
+	return token, nil
+}
+
+// attemptPendingLogin counts the attempt before the code is checked, the pending login is forgotten after too many of them
+func attemptPendingLogin(ctx context.Context, conn *pgxpool.Pool, token string, now time.Time) (kcore.ID, error) {
+	var userId kcore.ID
+	err := conn.QueryRow(ctx, `
+	UPDATE pending_logins SET attempts = attempts + 1
+	WHERE token_hash = $1 AND expires_at > $2 AND attempts < $3
+	RETURNING user_id
+	`, hashSecretToken(token), now, pendingLoginAttempts).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return kcore.ID{}, ErrPendingLoginInvalid
+	} else if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error updating pending_logins table")
+	}
+	return userId, nil
+}
+
+func deletePendingLogin(ctx context.Context, conn *pgxpool.Pool, token string) error {
+	_, err := conn.Exec(ctx, `DELETE FROM pending_logins WHERE token_hash = $1`, hashSecretToken(token))
+	if err != nil {
+		return kcore.Wrap(err, "error deleting pending_logins table")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// The RFC 6238 vectors have 8 digits, authenticator apps show their last 6
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, vector := range vectors {
+		step := vector.unix / int64(totpPeriod.Seconds())
+		expected := vector.code[len(vector.code)-totpDigits:]
+		if code := totpCode(secret, step); code != expected {
+			t.Errorf("at %d expected %s, got %s", vector.unix, expected, code)
+		}
+	}
+}
+
+func TestVerifyTOTPRefusesReplayedCodes(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step, ok := verifyTOTP(secret, "050471", now, 0)
+	if !ok {
+		t.Fatal("expected the code to be valid")
+	}
+	_, ok = verifyTOTP(secret, "050471", now, step)
+	if ok {
+		t.Error("expected the replayed code to be refused")
+	}
+}
@@ -2,6 +2,11 @@ package auth
 
 import (
 	"errors"
+	"net/mail"
+	"regexp"
+	"slices"
+	"time"
+	"unicode/utf8"
 
 	"github.com/martinlehoux/kagamigo/kcore"
 	"golang.org/x/crypto/bcrypt"
@@ -10,34 +15,148 @@ import (
 var (
 	ErrBadPassword          = errors.New("incorrect password")
 	ErrUserUsernameTooShort = errors.New("username must be at least 3 characters")
+	ErrUserDisabled         = errors.New("user account is disabled")
+	ErrUserNotAdmin         = errors.New("user is not an admin")
+	ErrAdminTargetsSelf     = errors.New("admins cannot moderate their own account")
+	ErrUserEmailInvalid     = errors.New("email address is invalid")
+	ErrUserEmailNotVerified = errors.New("email address is not verified")
+	ErrPasswordTooShort     = errors.New("password must be at least 8 characters")
+	ErrUserFullNameTooLong  = errors.New("full name must be at most 255 characters")
+	ErrUserBirthDateInvalid = errors.New("date of birth must be in the past")
+	ErrUserGenderInvalid    = errors.New("gender is invalid")
+	ErrUserPhoneInvalid     = errors.New("phone number is invalid")
 )
 
+type Gender string
+
+const (
+	GenderUnspecified Gender = ""
+	GenderFemale      Gender = "female"
+	GenderMale        Gender = "male"
+	GenderOther       Gender = "other"
+)
+
+var Genders = []Gender{GenderFemale, GenderMale, GenderOther}
+
+// MinPasswordLength is counted in characters, not bytes
+const MinPasswordLength = 8
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ().-]{4,31}$`)
+
+// ContactDetails are all optional, they are shown to the organizers of the races the user registered for
+type ContactDetails struct {
+	FullName  string
+	BirthDate time.Time
+	Gender    Gender
+	Phone     string
+}
+
 type User struct {
 	Id           kcore.ID
 	Username     string
 	PasswordHash []byte
 	language     string
+	// Email is optional, it is needed to reset the password
+	Email           string
+	emailVerifiedAt *time.Time
+	ContactDetails  ContactDetails
+	// SessionId is the session of the current request, it is not saved with the user
+	SessionId kcore.ID
+	// isSessionSecondFactorVerified is true when the session of the current request checked a TOTP code
+	isSessionSecondFactorVerified bool
+	// APITokenScope is set when the current request is authenticated with an API token instead of a session
+	APITokenScope APITokenScope
+	// Two-factor authentication
+	TOTPEnabled  bool
+	totpSecret   []byte
+	totpLastStep int64
+	// Moderation
+	IsAdmin    bool
+	IsDisabled bool
 }
 
 func (user User) Language() string {
 	return user.language
 }
 
-func NewUser(username string) (User, error) {
+func NewUser(username string, language string) (User, error) {
 	var user User
 	if len(username) < 3 {
 		return user, ErrUserUsernameTooShort
 	}
 	user.Id = kcore.NewID()
 	user.Username = username
-	user.language = "en"
+	err := user.SetLanguage(language)
+	if err != nil {
+		return user, err
+	}
 	return user, nil
 }
 
+func (user *User) SetLanguage(language string) error {
+	if !slices.Contains(Languages, language) {
+		return ErrLanguageUnsupported
+	}
+	user.language = language
+	return nil
+}
+
+func (user *User) SetEmail(email string) error {
+	if email == "" {
+		user.Email = ""
+		user.emailVerifiedAt = nil
+		return nil
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ErrUserEmailInvalid
+	}
+	if email != user.Email {
+		user.emailVerifiedAt = nil
+	}
+	user.Email = email
+	return nil
+}
+
+func (user User) IsEmailVerified() bool {
+	return user.Email != "" && user.emailVerifiedAt != nil
+}
+
+// VerifyEmail is called once the user followed the link sent to the address, or when the identity provider verified it
+func (user *User) VerifyEmail(now time.Time) {
+	user.emailVerifiedAt = &now
+}
+
+func (user *User) SetContactDetails(details ContactDetails, now time.Time) error {
+	if len(details.FullName) > 255 {
+		return ErrUserFullNameTooLong
+	}
+	if !details.BirthDate.IsZero() && !details.BirthDate.Before(now) {
+		return ErrUserBirthDateInvalid
+	}
+	if details.Gender != GenderUnspecified && !slices.Contains(Genders, details.Gender) {
+		return ErrUserGenderInvalid
+	}
+	if details.Phone != "" && !phonePattern.MatchString(details.Phone) {
+		return ErrUserPhoneInvalid
+	}
+	user.ContactDetails = details
+	return nil
+}
+
+// SetPassword does not check the old password of users created through OpenID Connect, as they have none
 func (user *User) SetPassword(oldPassword string, newPassword string) error {
-	if user.PasswordHash != nil && bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(oldPassword)) != nil {
+	if len(user.PasswordHash) > 0 && bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(oldPassword)) != nil {
 		return ErrBadPassword
 	}
+	return user.ResetPassword(newPassword)
+}
+
+// ResetPassword skips the old password check, the user proved who they are with a reset token
+func (user *User) ResetPassword(newPassword string) error {
+	if utf8.RuneCountInString(newPassword) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
 	newPasswordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return kcore.Wrap(err, "failed to generate password hash")
@@ -45,3 +164,14 @@ func (user *User) SetPassword(oldPassword string, newPassword string) error {
 	user.PasswordHash = newPasswordHash
 	return nil
 }
+
+// Moderate is checked before any action of the admin console, that must not lock the admin out
+func (user User) Moderate(target kcore.ID) error {
+	if !user.IsAdmin {
+		return ErrUserNotAdmin
+	}
+	if user.Id == target {
+		return ErrAdminTargetsSelf
+	}
+	return nil
+}
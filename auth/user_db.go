@@ -1,41 +1,136 @@
 package auth
 
 import (
+	"bike_race/media"
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/martinlehoux/kagamigo/kcore"
 )
 
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrUserEmailTaken = errors.New("email address is already used")
 )
 
 func LoadUser(ctx context.Context, conn *pgxpool.Pool, userId kcore.ID) (User, error) {
 	var user User
+	var birthDate *time.Time
 	err := conn.QueryRow(ctx, `
-		SELECT id, username, password_hash, language
+		SELECT id, username, password_hash, language, is_admin, is_disabled, coalesce(email, ''), email_verified_at, totp_enabled, totp_secret, totp_last_step,
+			full_name, birth_date, gender, phone
 		FROM users
 		WHERE id = $1
-	`, userId).Scan(&user.Id, &user.Username, &user.PasswordHash, &user.language)
+	`, userId).Scan(&user.Id, &user.Username, &user.PasswordHash, &user.language, &user.IsAdmin, &user.IsDisabled, &user.Email, &user.emailVerifiedAt, &user.TOTPEnabled, &user.totpSecret, &user.totpLastStep,
+		&user.ContactDetails.FullName, &birthDate, &user.ContactDetails.Gender, &user.ContactDetails.Phone)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return User{}, ErrUserNotFound
 	} else if err != nil {
 		return User{}, kcore.Wrap(err, "error querying user")
 	}
+	if birthDate != nil {
+		user.ContactDetails.BirthDate = *birthDate
+	}
 	return user, nil
 }
 
 func (user *User) Save(ctx context.Context, conn *pgxpool.Pool) error {
 	_, err := conn.Exec(ctx, `
-		INSERT INTO users (id, username, password_hash, language)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (id) DO UPDATE SET username = $2, password_hash = $3, language = $4
-	`, user.Id, user.Username, user.PasswordHash, user.Language)
-	if err != nil {
+		INSERT INTO users (id, username, password_hash, language, is_admin, is_disabled, email, totp_enabled, totp_secret, totp_last_step,
+			email_verified_at, full_name, birth_date, gender, phone)
+		VALUES ($1, $2, $3, $4, $5, $6, nullif($7, ''), $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (id) DO UPDATE SET username = $2, password_hash = $3, language = $4, is_admin = $5, is_disabled = $6, email = nullif($7, ''),
+			totp_enabled = $8, totp_secret = $9, totp_last_step = $10, email_verified_at = $11, full_name = $12, birth_date = $13, gender = $14, phone = $15
+	`, user.Id, user.Username, user.PasswordHash, user.Language(), user.IsAdmin, user.IsDisabled, user.Email, user.TOTPEnabled, user.totpSecret, user.totpLastStep,
+		user.emailVerifiedAt, user.ContactDetails.FullName, nullableDate(user.ContactDetails.BirthDate), string(user.ContactDetails.Gender), user.ContactDetails.Phone)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "users_email_verified_key" {
+		return ErrUserEmailTaken
+	} else if err != nil {
 		return kcore.Wrap(err, "error inserting user table")
 	}
 	return nil
 }
+
+// execer is either the pool or a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// reclaimEmail removes the email from the accounts that did not verify it, as it is verified by userId
+func reclaimEmail(ctx context.Context, conn execer, userId kcore.ID, email string) error {
+	_, err := conn.Exec(ctx, `UPDATE users SET email = NULL WHERE email = $1 AND id != $2 AND email_verified_at IS NULL`, email, userId)
+	if err != nil {
+		return kcore.Wrap(err, "error updating users table")
+	}
+	return nil
+}
+
+func nullableDate(date time.Time) *time.Time {
+	if date.IsZero() {
+		return nil
+	}
+	return &date
+}
+
+// GrantAdmin bootstraps the first admin from the command line, as only admins can see the console
+func GrantAdmin(ctx context.Context, conn *pgxpool.Pool, username string) error {
+	tag, err := conn.Exec(ctx, `UPDATE users SET is_admin = true WHERE username = $1`, username)
+	if err != nil {
+		return kcore.Wrap(err, "error updating users table")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// LoadUserByEmail returns ErrUserNotFound for an unknown email, callers must not reveal it
+// Only verified emails are unique, so the others do not identify a user
+func LoadUserByEmail(ctx context.Context, conn *pgxpool.Pool, email string) (User, error) {
+	var userId kcore.ID
+	err := conn.QueryRow(ctx, `SELECT id FROM users WHERE email = $1 AND email_verified_at IS NOT NULL`, email).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	} else if err != nil {
+		return User{}, kcore.Wrap(err, "error querying user")
+	}
+	return LoadUser(ctx, conn, userId)
+}
+
+// RotateTOTPSecretsKey encrypts the totp secrets again with the primary key, including secrets stored in plaintext
+func RotateTOTPSecretsKey(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring) (int, error) {
+	rows, err := conn.Query(ctx, `SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL`)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error selecting users table")
+	}
+	type totpSecretRow struct {
+		Id         kcore.ID
+		TOTPSecret []byte
+	}
+	secrets, err := pgx.CollectRows(rows, pgx.RowToStructByPos[totpSecretRow])
+	if err != nil {
+		return 0, kcore.Wrap(err, "error scanning users table")
+	}
+	rotated := 0
+	for _, secret := range secrets {
+		encryptedSecret, isRotated, err := keyring.RewrapBytes(secret.TOTPSecret)
+		if err != nil {
+			return rotated, kcore.Wrap(err, fmt.Sprintf("error rotating totp secret of %s", secret.Id))
+		}
+		if !isRotated {
+			continue
+		}
+		_, err = conn.Exec(ctx, `UPDATE users SET totp_secret = $2 WHERE id = $1`, secret.Id, encryptedSecret)
+		if err != nil {
+			return rotated, kcore.Wrap(err, "error updating users table")
+		}
+		rotated++
+	}
+	return rotated, nil
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrIdentityNotFound      = errors.New("identity is not linked to any user")
+	ErrIdentityAlreadyLinked = errors.New("identity is already linked to another user")
+)
+
+func loadIdentityUserId(ctx context.Context, conn *pgxpool.Pool, issuer string, subject string) (kcore.ID, error) {
+	var userId kcore.ID
+	err := conn.QueryRow(ctx, `SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`, issuer, subject).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return kcore.ID{}, ErrIdentityNotFound
+	} else if err != nil {
+		return kcore.ID{}, kcore.Wrap(err, "error querying user identity")
+	}
+	return userId, nil
+}
+
+func saveUserIdentity(ctx context.Context, conn *pgxpool.Pool, issuer string, subject string, userId kcore.ID, now time.Time) error {
+	_, err := conn.Exec(ctx, `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, issuer, subject, userId, now)
+	if err != nil {
+		return kcore.Wrap(err, "error inserting user_identities table")
+	}
+	return nil
+}
+
+// availableUsername derives a username from the identity claims, with a numeric suffix when it is taken
+func availableUsername(ctx context.Context, conn *pgxpool.Pool, preferredUsername string, email string) (string, error) {
+	base := preferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+	username := base
+	for suffix := 2; ; suffix++ {
+		var taken bool
+		err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, username).Scan(&taken)
+		if err != nil {
+			return "", kcore.Wrap(err, "error querying users")
+		}
+		if !taken {
+			return username, nil
+		}
+		username = fmt.Sprintf("%s%d", base, suffix)
+	}
+}
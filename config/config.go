@@ -2,8 +2,11 @@ package config
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/exaring/otelpgx"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -14,12 +17,46 @@ import (
 )
 
 var (
-	ErrCookieBadLength = errors.New("cookie secret must be 32 bytes")
+	ErrCookieBadLength       = errors.New("cookie secret must be 32 bytes")
+	ErrMasterKeyBadLength    = errors.New("media master key must be 32 bytes")
+	ErrTimingSecretBadLength = errors.New("timing secret must be 32 bytes")
 )
 
 type Config struct {
 	DatabaseURL string
 	Auth        kauth.AuthConfig
+	// MediaMasterKey wraps the keys of encrypted uploads, MediaPreviousMasterKey is only set while rotating
+	MediaMasterKey         []byte
+	MediaPreviousMasterKey []byte
+	// TimingSecret signs the timing tokens of the races, it is separate from the cookie secret so that changing one does not change the other
+	TimingSecret []byte
+	// MediaAllowPlaintext is only for development, it runs without a master key and serves the files uploaded before encryption
+	MediaAllowPlaintext bool
+	// MedicalCertificateRetention starts at the start of the race
+	MedicalCertificateRetention time.Duration
+	// BaseURL prefixes the links sent by email, it must not be taken from the request host
+	BaseURL string
+	Mail    MailConfig
+	// RequireTOTPForMedicalCertificates keeps organizers without two-factor authentication away from riders' medical certificates
+	RequireTOTPForMedicalCertificates bool
+	OIDC                              OIDCConfig
+}
+
+// OIDCConfig enables the OpenID Connect login when Issuer is set, Name is shown on the log in button
+type OIDCConfig struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	Name         string
+}
+
+// MailConfig sends through SMTP when SMTPAddr is set, mails are only written to Dir otherwise
+type MailConfig struct {
+	From         string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	Dir          string
 }
 
 func LoadConfig() Config {
@@ -34,13 +71,83 @@ func LoadConfig() Config {
 		slog.Error("DATABASE_URL environment variable is required")
 		os.Exit(1)
 	}
-	return Config{
+	config := Config{
 		DatabaseURL: databaseURL,
 		Auth: kauth.AuthConfig{
 			Domain:       domain,
 			CookieSecret: kauth.LoadCookieSecret(os.Getenv("COOKIE_SECRET")),
 		},
+		MedicalCertificateRetention:       loadRetentionDays(os.Getenv("MEDICAL_CERTIFICATE_RETENTION_DAYS")),
+		BaseURL:                           loadWithDefault(os.Getenv("BASE_URL"), "http://localhost:3000"),
+		RequireTOTPForMedicalCertificates: os.Getenv("REQUIRE_TOTP_FOR_MEDICAL_CERTIFICATES") == "true",
+		Mail: MailConfig{
+			From:         loadWithDefault(os.Getenv("MAIL_FROM"), "noreply@"+domain),
+			SMTPAddr:     os.Getenv("SMTP_ADDR"),
+			SMTPUsername: os.Getenv("SMTP_USERNAME"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+			Dir:          loadWithDefault(os.Getenv("MAIL_DIR"), "mails"),
+		},
+		OIDC: OIDCConfig{
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientId:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			Name:         loadWithDefault(os.Getenv("OIDC_NAME"), "OpenID Connect"),
+		},
+	}
+	if os.Getenv("TIMING_SECRET") == "" {
+		slog.Error("TIMING_SECRET environment variable is required")
+		os.Exit(1)
+	}
+	config.TimingSecret = loadSecret(os.Getenv("TIMING_SECRET"), "timing secret", ErrTimingSecretBadLength)
+	config.MediaAllowPlaintext = os.Getenv("MEDIA_ALLOW_PLAINTEXT") == "true"
+	if os.Getenv("MEDIA_MASTER_KEY") != "" {
+		config.MediaMasterKey = loadSecret(os.Getenv("MEDIA_MASTER_KEY"), "media master key", ErrMasterKeyBadLength)
+	} else if !config.MediaAllowPlaintext {
+		slog.Error("MEDIA_MASTER_KEY environment variable is required, unless MEDIA_ALLOW_PLAINTEXT=true in development")
+		os.Exit(1)
+	}
+	if config.MediaAllowPlaintext {
+		slog.Warn("MEDIA_ALLOW_PLAINTEXT is set, medical certificates and TOTP secrets may be stored and served unencrypted")
+	}
+	if os.Getenv("MEDIA_PREVIOUS_MASTER_KEY") != "" {
+		config.MediaPreviousMasterKey = loadSecret(os.Getenv("MEDIA_PREVIOUS_MASTER_KEY"), "media master key", ErrMasterKeyBadLength)
+	}
+	return config
+}
+
+func loadWithDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// loadRetentionDays defaults to 30 days
+func loadRetentionDays(retentionDaysString string) time.Duration {
+	if retentionDaysString == "" {
+		return 30 * 24 * time.Hour
+	}
+	retentionDays, err := strconv.Atoi(retentionDaysString)
+	if err != nil || retentionDays < 0 {
+		slog.Error("MEDICAL_CERTIFICATE_RETENTION_DAYS must be a positive number of days")
+		os.Exit(1)
+	}
+	return time.Duration(retentionDays) * 24 * time.Hour
+}
+
+// loadSecret decodes a hex encoded 32 bytes secret
+func loadSecret(secretString string, name string, errBadLength error) []byte {
+	secret, err := hex.DecodeString(secretString)
+	if err != nil {
+		err = kcore.Wrap(err, "error decoding "+name)
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	if len(secret) != 32 {
+		slog.Error(errBadLength.Error())
+		os.Exit(1)
 	}
+	return secret
 }
 
 func loadEnv() {
@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+// FileMailer stands in for SMTP in development and tests, each mail is written to its own file in Dir
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+func (mailer FileMailer) Send(ctx context.Context, message Message) error {
+	err := os.MkdirAll(mailer.Dir, 0o700)
+	if err != nil {
+		return kcore.Wrap(err, "error creating mail directory")
+	}
+	path := filepath.Join(mailer.Dir, fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), kcore.NewID()))
+	err = os.WriteFile(path, message.bytes(mailer.From), 0o600)
+	if err != nil {
+		return kcore.Wrap(err, "error writing mail")
+	}
+	slog.Info("mail written", slog.String("to", message.To), slog.String("subject", message.Subject), slog.String("path", path))
+	return nil
+}
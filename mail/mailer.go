@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"bike_race/config"
+	"context"
+
+	"golang.org/x/exp/slog"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailer falls back to writing mails to files, so that development does not need an SMTP server.
+// It is called once at startup, so that the warning is not missed in production.
+func NewMailer(conf config.MailConfig) Mailer {
+	if conf.SMTPAddr == "" {
+		slog.Warn("SMTP_ADDR is not set, mails are written to files instead of being sent", slog.String("dir", conf.Dir))
+		return FileMailer{From: conf.From, Dir: conf.Dir}
+	}
+	return SMTPMailer{From: conf.From, Addr: conf.SMTPAddr, Username: conf.SMTPUsername, Password: conf.SMTPPassword}
+}
+
+func (message Message) bytes(from string) []byte {
+	return []byte("From: " + from + "\r\n" +
+		"To: " + message.To + "\r\n" +
+		"Subject: " + message.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		message.Body + "\r\n")
+}
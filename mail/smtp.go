@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+type SMTPMailer struct {
+	From     string
+	Addr     string
+	Username string
+	Password string
+}
+
+// Send uses STARTTLS when the server offers it, authentication is only sent once the connection is encrypted
+func (mailer SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		host, _, err := net.SplitHostPort(mailer.Addr)
+		if err != nil {
+			return kcore.Wrap(err, "error parsing smtp address")
+		}
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, host)
+	}
+	err := smtp.SendMail(mailer.Addr, auth, mailer.From, []string{message.To}, message.bytes(mailer.From))
+	if err != nil {
+		return kcore.Wrap(err, "error sending mail")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bike_race/auth"
+	"bike_race/config"
+	"bike_race/media"
+	"bike_race/oidc"
+	"bike_race/race"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+// runCli handles maintenance commands, given as arguments of the server binary
+func runCli(ctx context.Context, conn *pgxpool.Pool, conf config.Config, args []string) {
+	switch args[0] {
+	case "rotate-media-key":
+		rotateMediaKey(ctx, conn, conf)
+	case "grant-admin":
+		grantAdmin(ctx, conn, args[1:])
+	case "mock-oidc":
+		mockOIDC()
+	default:
+		slog.Error(fmt.Sprintf("unknown command %s", args[0]))
+		os.Exit(1)
+	}
+}
+
+// rotateMediaKey is run with the new key as MEDIA_MASTER_KEY and the old one as MEDIA_PREVIOUS_MASTER_KEY
+func rotateMediaKey(ctx context.Context, conn *pgxpool.Pool, conf config.Config) {
+	keyring := media.NewKeyring(conf.MediaMasterKey, conf.MediaPreviousMasterKey)
+	rotated, err := race.RotateMedicalCertificatesKey(ctx, conn, keyring)
+	kcore.Expect(err, fmt.Sprintf("error rotating media key after %d files", rotated))
+	slog.Info("media files rotated", slog.Int("count", rotated))
+	rotated, err = auth.RotateTOTPSecretsKey(ctx, conn, keyring)
+	kcore.Expect(err, fmt.Sprintf("error rotating media key after %d totp secrets", rotated))
+	slog.Info("totp secrets rotated", slog.Int("count", rotated))
+}
+
+// grantAdmin bootstraps the first admin, the next ones can be granted the same way
+func grantAdmin(ctx context.Context, conn *pgxpool.Pool, args []string) {
+	if len(args) != 1 {
+		slog.Error("usage: grant-admin <username>")
+		os.Exit(1)
+	}
+	err := auth.GrantAdmin(ctx, conn, args[0])
+	kcore.Expect(err, "error granting admin")
+	slog.Info("admin granted", slog.String("username", args[0]))
+}
+
+// mockOIDC serves a development issuer, to be configured with OIDC_ISSUER=http://localhost:3002 and OIDC_CLIENT_ID=bike_race
+func mockOIDC() {
+	slog.Info("mock oidc issuer listening on http://localhost:3002")
+	server := http.Server{
+		Addr:              "localhost:3002",
+		ReadHeaderTimeout: 1 * time.Second,
+		Handler:           oidc.NewMockIssuer("http://localhost:3002"),
+	}
+	err := server.ListenAndServe()
+	kcore.Expect(err, "error listening and serving")
+}
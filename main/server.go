@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bike_race/admin"
+	"bike_race/api"
 	"bike_race/auth"
 	"bike_race/config"
+	"bike_race/mail"
 	"bike_race/race"
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -45,40 +49,61 @@ func getTracerProvider(ctx context.Context, serviceName string) *trace.TracerPro
 }
 
 func main() {
-	i18n.SetDefaultLanguage("en-US")
+	i18n.SetDefaultLanguage(auth.DefaultLanguage)
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
 	ctx := context.Background()
 	conf := config.LoadConfig()
 	conn := config.LoadDatabasePool(ctx, conf)
 	defer conn.Close()
+	if len(os.Args) > 1 {
+		runCli(ctx, conn, conf, os.Args[1:])
+		return
+	}
 
 	serviceName := "bike_race"
 	tracerProvider := getTracerProvider(ctx, serviceName)
 	otel.SetTracerProvider(tracerProvider)
 	defer tracerProvider.Shutdown(ctx) //nolint:errcheck
 
+	mailer := mail.NewMailer(conf.Mail)
+	go race.RunMedicalCertificatesRetention(ctx, conn, conf.MedicalCertificateRetention, time.Hour)
+	go race.RunRegistrationsClosing(ctx, conn, time.Minute)
+	go race.RunNotificationsSending(ctx, conn, mailer, conf.BaseURL, time.Minute)
+
 	router := chi.NewRouter()
 	router.Use(kcore.RecoverMiddleware)
 	router.Use(otelchi.Middleware(serviceName)) // otelchi.WithChiRoutes(router)
-	loadUser := func(ctx context.Context, userId kcore.ID) (any, error) {
-		user, err := auth.LoadUser(ctx, conn, userId)
-		return user, kcore.Wrap(err, "error loading user")
+	// Cookies hold the session id, revoked sessions and deleted users are anonymous from now on
+	loadUser := func(ctx context.Context, sessionId kcore.ID) (any, error) {
+		user, err := auth.LoadSessionUser(ctx, conn, sessionId, time.Now())
+		if errors.Is(err, auth.ErrSessionNotFound) || errors.Is(err, auth.ErrUserNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, kcore.Wrap(err, "error loading user")
+		}
+		return user, nil
 	}
 	router.Use(kauth.CookieAuthMiddleware(loadUser, conf.Auth))
+	router.Use(auth.RefuseDisabledUserMiddleware)
+	router.Use(auth.LanguageMiddleware)
 
 	router.With(middleware.SetHeader("Cache-Control", "max-age=3600")).Handle("/favicon.ico", http.FileServer(http.Dir("static")))
 	router.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	router.Handle("/media/*", http.StripPrefix("/media/", http.FileServer(http.Dir("media"))))
+	// Files can be private, like medical certificates, they are served by their own routes
+	router.Handle("/media/images/*", http.StripPrefix("/media/images/", http.FileServer(http.Dir("media/images"))))
 
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		login := auth.LoginFromContext(ctx)
-		page := IndexPage(login)
+		page := IndexPage(login, conf.OIDC)
 		kcore.RenderPage(r.Context(), page, w)
 	})
 
-	router.Mount("/users", auth.Router(conn, conf))
-	router.Mount("/races", race.Router(conn))
+	router.Mount("/users", auth.Router(conn, conf, mailer))
+	router.Mount("/admin", admin.Router(conn))
+	live := race.NewLiveTiming(conn)
+	router.Mount("/races", race.Router(conn, conf, live))
+	router.Mount("/api/v1", api.Router(conn, conf, mailer, live))
 
 	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
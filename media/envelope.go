@@ -0,0 +1,374 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrMasterKeyUnknown = errors.New("file was encrypted with an unknown master key")
+	ErrChunkTruncated   = errors.New("encrypted file is truncated")
+	ErrMasterKeyMissing = errors.New("media master key is not configured")
+	ErrFileTooShort     = errors.New("file is shorter than an encryption header")
+	ErrFileNotEncrypted = errors.New("file is not encrypted")
+)
+
+// Encrypted files start with a header holding the data key, wrapped with the master key, then the content in authenticated chunks
+//
+//	magic (8) | master key fingerprint (8) | wrap nonce (12) | wrapped data key (48) | stream nonce prefix (8) | chunks...
+const (
+	magic           = "BRMEDIA1"
+	fingerprintSize = 8
+	keySize         = 32
+	overhead        = 16
+	prefixSize      = 8
+	counterSize     = 4
+	nonceSize       = prefixSize + counterSize
+	headerSize      = len(magic) + fingerprintSize + nonceSize + keySize + overhead + prefixSize
+	chunkSize       = 64 * 1024
+)
+
+type fingerprint [fingerprintSize]byte
+
+func fingerprintOf(masterKey []byte) fingerprint {
+	sum := sha256.Sum256(masterKey)
+	var keyId fingerprint
+	copy(keyId[:], sum[:])
+	return keyId
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error creating cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error creating gcm")
+	}
+	return gcm, nil
+}
+
+// Keyring encrypts with the primary master key, and decrypts with any of its keys so that files can be rotated while the server runs.
+// Plaintext is only written and read when it is explicitly allowed, for development.
+type Keyring struct {
+	primary        []byte
+	keys           map[fingerprint][]byte
+	allowPlaintext bool
+}
+
+func NewKeyring(primary []byte, previous ...[]byte) Keyring {
+	keyring := Keyring{primary: primary, keys: map[fingerprint][]byte{}}
+	for _, key := range append([][]byte{primary}, previous...) {
+		if len(key) > 0 {
+			keyring.keys[fingerprintOf(key)] = key
+		}
+	}
+	return keyring
+}
+
+// WithPlaintext lets development run without a master key, and read the files uploaded before encryption
+func (keyring Keyring) WithPlaintext(allowPlaintext bool) Keyring {
+	keyring.allowPlaintext = allowPlaintext
+	return keyring
+}
+
+func (keyring Keyring) wrapHeader(dataKey []byte, prefix []byte) ([]byte, error) {
+	gcm, err := newGCM(keyring.primary)
+	if err != nil {
+		return nil, err
+	}
+	keyId := fingerprintOf(keyring.primary)
+	header := append([]byte(magic), keyId[:]...)
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error generating nonce")
+	}
+	header = append(header, nonce...)
+	// The fingerprint and the stream prefix are authenticated with the data key
+	header = gcm.Seal(header, nonce, dataKey, append(keyId[:], prefix...))
+	return append(header, prefix...), nil
+}
+
+func (keyring Keyring) unwrapHeader(header []byte) ([]byte, []byte, error) {
+	var keyId fingerprint
+	copy(keyId[:], header[len(magic):])
+	masterKey, ok := keyring.keys[keyId]
+	if !ok {
+		return nil, nil, ErrMasterKeyUnknown
+	}
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonceStart := len(magic) + fingerprintSize
+	wrappedStart := nonceStart + gcm.NonceSize()
+	prefix := header[headerSize-prefixSize:]
+	dataKey, err := gcm.Open(nil, header[nonceStart:wrappedStart], header[wrappedStart:headerSize-prefixSize], append(keyId[:], prefix...))
+	if err != nil {
+		return nil, nil, kcore.Wrap(err, "error unwrapping data key")
+	}
+	return dataKey, prefix, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	return nonce
+}
+
+// chunkData authenticates which chunk is the last one, so that a file cut at a chunk boundary is detected
+func chunkData(isLast bool) []byte {
+	if isLast {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// Encrypt generates a data key for each file
+func (keyring Keyring) Encrypt(dst io.Writer, src io.Reader) error {
+	if len(keyring.primary) == 0 {
+		if !keyring.allowPlaintext {
+			return ErrMasterKeyMissing
+		}
+		_, err := io.Copy(dst, src)
+		if err != nil {
+			return kcore.Wrap(err, "error copying plaintext")
+		}
+		return nil
+	}
+	dataKey := make([]byte, keySize)
+	prefix := make([]byte, prefixSize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return kcore.Wrap(err, "error generating data key")
+	}
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return kcore.Wrap(err, "error generating nonce prefix")
+	}
+	header, err := keyring.wrapHeader(dataKey, prefix)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(header)
+	if err != nil {
+		return kcore.Wrap(err, "error writing header")
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	return sealChunks(gcm, prefix, dst, bufio.NewReaderSize(src, chunkSize))
+}
+
+func sealChunks(gcm cipher.AEAD, prefix []byte, dst io.Writer, src *bufio.Reader) error {
+	chunk := make([]byte, chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, chunk)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return kcore.Wrap(err, "error reading plaintext")
+		}
+		_, peekErr := src.Peek(1)
+		isLast := peekErr != nil
+		_, err = dst.Write(gcm.Seal(nil, chunkNonce(prefix, counter), chunk[:n], chunkData(isLast)))
+		if err != nil {
+			return kcore.Wrap(err, "error writing chunk")
+		}
+		if isLast {
+			return nil
+		}
+	}
+}
+
+// Decrypt writes each chunk only once it is authenticated
+func (keyring Keyring) Decrypt(dst io.Writer, src io.Reader) error {
+	reader := bufio.NewReaderSize(src, chunkSize+overhead)
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(reader, header)
+	if !bytes.HasPrefix(header[:n], []byte(magic)) {
+		// Files uploaded before encryption are refused until rotate-media-key has encrypted them
+		if !keyring.allowPlaintext {
+			return ErrFileNotEncrypted
+		}
+		_, err = dst.Write(header[:n])
+		if err != nil {
+			return kcore.Wrap(err, "error writing plaintext")
+		}
+		_, err = io.Copy(dst, reader)
+		if err != nil {
+			return kcore.Wrap(err, "error copying plaintext")
+		}
+		return nil
+	}
+	if err != nil {
+		return ErrChunkTruncated
+	}
+	dataKey, prefix, err := keyring.unwrapHeader(header)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	return openChunks(gcm, prefix, dst, reader)
+}
+
+func openChunks(gcm cipher.AEAD, prefix []byte, dst io.Writer, src *bufio.Reader) error {
+	chunk := make([]byte, chunkSize+overhead)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, chunk)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrChunkTruncated
+		}
+		_, peekErr := src.Peek(1)
+		isLast := peekErr != nil
+		plaintext, err := gcm.Open(nil, chunkNonce(prefix, counter), chunk[:n], chunkData(isLast))
+		if err != nil {
+			return kcore.Wrap(err, "error decrypting chunk")
+		}
+		_, err = dst.Write(plaintext)
+		if err != nil {
+			return kcore.Wrap(err, "error writing chunk")
+		}
+		if isLast {
+			return nil
+		}
+	}
+}
+
+func (keyring Keyring) SaveFile(path string, src io.Reader) error {
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // #nosec G304
+	if err != nil {
+		return kcore.Wrap(err, "error creating file")
+	}
+	defer dst.Close()
+	return keyring.Encrypt(dst, src)
+}
+
+func (keyring Keyring) OpenFile(path string, dst io.Writer) error {
+	src, err := os.Open(path) // #nosec G304
+	if err != nil {
+		return kcore.Wrap(err, "error opening file")
+	}
+	defer src.Close()
+	return keyring.Decrypt(dst, src)
+}
+
+// Rewrap only rewrites the header of files encrypted with a previous master key, and encrypts files that are still in plaintext.
+// Files shorter than a header are refused, they are more likely a truncated encrypted file than a plaintext upload.
+func (keyring Keyring) Rewrap(path string) (bool, error) {
+	if len(keyring.primary) == 0 {
+		return false, ErrMasterKeyMissing
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0600) // #nosec G304
+	if err != nil {
+		return false, kcore.Wrap(err, "error opening file")
+	}
+	defer file.Close()
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if bytes.HasPrefix(header[:n], []byte(magic)) {
+			return false, ErrChunkTruncated
+		}
+		return false, ErrFileTooShort
+	} else if err != nil {
+		return false, kcore.Wrap(err, "error reading header")
+	}
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return true, keyring.encryptInPlace(path)
+	}
+	primary := fingerprintOf(keyring.primary)
+	if bytes.Equal(header[len(magic):len(magic)+fingerprintSize], primary[:]) {
+		return false, nil
+	}
+	dataKey, prefix, err := keyring.unwrapHeader(header)
+	if err != nil {
+		return false, err
+	}
+	header, err = keyring.wrapHeader(dataKey, prefix)
+	if err != nil {
+		return false, err
+	}
+	_, err = file.WriteAt(header, 0)
+	if err != nil {
+		return false, kcore.Wrap(err, "error writing header")
+	}
+	return true, nil
+}
+
+func (keyring Keyring) encryptInPlace(path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	src, err := os.Open(path) // #nosec G304
+	if err != nil {
+		return kcore.Wrap(err, "error opening file")
+	}
+	defer src.Close()
+	err = keyring.SaveFile(tmp, src)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return kcore.Wrap(err, "error replacing file")
+	}
+	return nil
+}
+
+// EncryptBytes is for small secrets stored in the database, with the same format as files
+func (keyring Keyring) EncryptBytes(plaintext []byte) ([]byte, error) {
+	var dst bytes.Buffer
+	err := keyring.Encrypt(&dst, bytes.NewReader(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	return dst.Bytes(), nil
+}
+
+func (keyring Keyring) DecryptBytes(ciphertext []byte) ([]byte, error) {
+	var dst bytes.Buffer
+	err := keyring.Decrypt(&dst, bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return dst.Bytes(), nil
+}
+
+// RewrapBytes is Rewrap for secrets stored in the database, they are small enough to be encrypted again
+func (keyring Keyring) RewrapBytes(data []byte) ([]byte, bool, error) {
+	if len(keyring.primary) == 0 {
+		return nil, false, ErrMasterKeyMissing
+	}
+	primary := fingerprintOf(keyring.primary)
+	if len(data) >= headerSize && bytes.HasPrefix(data, []byte(magic)) && bytes.Equal(data[len(magic):len(magic)+fingerprintSize], primary[:]) {
+		return data, false, nil
+	}
+	plaintext := data
+	if bytes.HasPrefix(data, []byte(magic)) {
+		var err error
+		plaintext, err = keyring.DecryptBytes(data)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	data, err := keyring.EncryptBytes(plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
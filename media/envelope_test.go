@@ -0,0 +1,132 @@
+package media
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestKey(t *testing.T) []byte {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encryptTestContent(t *testing.T, keyring Keyring, content []byte) []byte {
+	var encrypted bytes.Buffer
+	err := keyring.Encrypt(&encrypted, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+// Sizes around the chunk boundaries, where the last chunk flag matters
+func TestEnvelopeRoundTrip(t *testing.T) {
+	keyring := NewKeyring(newTestKey(t))
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted := encryptTestContent(t, keyring, content)
+		var decrypted bytes.Buffer
+		err = keyring.Decrypt(&decrypted, bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), content) {
+			t.Errorf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestEnvelopeDecryptsWithPreviousKey(t *testing.T) {
+	previous := newTestKey(t)
+	encrypted := encryptTestContent(t, NewKeyring(previous), []byte("certificate"))
+	var decrypted bytes.Buffer
+	err := NewKeyring(newTestKey(t), previous).Decrypt(&decrypted, bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.String() != "certificate" {
+		t.Errorf("expected certificate, got %q", decrypted.String())
+	}
+}
+
+func TestEnvelopeRefusesShortReads(t *testing.T) {
+	keyring := NewKeyring(newTestKey(t))
+	encrypted := encryptTestContent(t, keyring, make([]byte, 2*chunkSize))
+	for _, length := range []int{len(magic) + 1, headerSize - 1} {
+		err := keyring.Decrypt(&bytes.Buffer{}, bytes.NewReader(encrypted[:length]))
+		if !errors.Is(err, ErrChunkTruncated) {
+			t.Errorf("length %d: expected %v, got %v", length, ErrChunkTruncated, err)
+		}
+	}
+	// A file cut within a chunk fails its authentication, a file cut at a chunk boundary misses the last chunk flag
+	for _, length := range []int{headerSize + 10, headerSize + chunkSize + overhead, len(encrypted) - 1} {
+		err := keyring.Decrypt(&bytes.Buffer{}, bytes.NewReader(encrypted[:length]))
+		if err == nil {
+			t.Errorf("length %d: expected the truncated file to be refused", length)
+		}
+	}
+}
+
+func TestRewrapRefusesFilesShorterThanHeader(t *testing.T) {
+	keyring := NewKeyring(newTestKey(t))
+	encrypted := encryptTestContent(t, keyring, []byte("certificate"))
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{"truncated": encrypted[:headerSize-1], "short": []byte("short")} {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, content, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isRotated, err := keyring.Rewrap(path)
+		if err == nil || isRotated {
+			t.Errorf("%s: expected the file to be refused", name)
+		}
+		onDisk, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(onDisk, content) {
+			t.Errorf("%s: file was modified", name)
+		}
+	}
+}
+
+func TestEnvelopeRefusesPlaintextUnlessAllowed(t *testing.T) {
+	err := NewKeyring(nil).Encrypt(&bytes.Buffer{}, bytes.NewReader([]byte("certificate")))
+	if !errors.Is(err, ErrMasterKeyMissing) {
+		t.Errorf("expected %v, got %v", ErrMasterKeyMissing, err)
+	}
+	err = NewKeyring(newTestKey(t)).Decrypt(&bytes.Buffer{}, bytes.NewReader([]byte("certificate")))
+	if !errors.Is(err, ErrFileNotEncrypted) {
+		t.Errorf("expected %v, got %v", ErrFileNotEncrypted, err)
+	}
+	var decrypted bytes.Buffer
+	err = NewKeyring(newTestKey(t)).WithPlaintext(true).Decrypt(&decrypted, bytes.NewReader([]byte("certificate")))
+	if err != nil || decrypted.String() != "certificate" {
+		t.Errorf("expected the plaintext to be served, got %q and %v", decrypted.String(), err)
+	}
+}
+
+func TestRewrapBytesEncryptsPlaintextSecrets(t *testing.T) {
+	keyring := NewKeyring(newTestKey(t))
+	data, isRotated, err := keyring.RewrapBytes([]byte("secret"))
+	if err != nil || !isRotated {
+		t.Fatalf("expected the secret to be encrypted, got %v", err)
+	}
+	plaintext, err := keyring.DecryptBytes(data)
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("expected secret, got %q and %v", plaintext, err)
+	}
+}
@@ -0,0 +1,29 @@
+-- migrate:up
+ALTER TYPE race_registrations__status ADD VALUE 'waitlisted';
+
+ALTER TABLE
+  races
+ADD
+  COLUMN version INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:down
+ALTER TABLE
+  races DROP COLUMN version;
+
+UPDATE
+  race_registrations
+SET
+  status = 'registered'
+WHERE
+  status = 'waitlisted';
+
+ALTER TYPE race_registrations__status RENAME TO race_registrations__status_old;
+
+CREATE TYPE race_registrations__status AS ENUM ('registered', 'submitted', 'approved');
+
+ALTER TABLE
+  race_registrations
+ALTER COLUMN
+  status TYPE race_registrations__status USING status::text::race_registrations__status;
+
+DROP TYPE race_registrations__status_old;
@@ -0,0 +1,31 @@
+-- migrate:up
+ALTER TYPE race_registrations__status ADD VALUE 'rejected';
+
+ALTER TYPE race_registrations__status ADD VALUE 'withdrawn';
+
+ALTER TYPE race_registrations__status ADD VALUE 'cancelled';
+
+ALTER TABLE
+  race_registrations
+ADD
+  COLUMN rejection_reason TEXT;
+
+-- migrate:down
+ALTER TABLE
+  race_registrations DROP COLUMN rejection_reason;
+
+DELETE FROM
+  race_registrations
+WHERE
+  status IN ('rejected', 'withdrawn', 'cancelled');
+
+ALTER TYPE race_registrations__status RENAME TO race_registrations__status_old;
+
+CREATE TYPE race_registrations__status AS ENUM ('registered', 'submitted', 'approved', 'waitlisted');
+
+ALTER TABLE
+  race_registrations
+ALTER COLUMN
+  status TYPE race_registrations__status USING status::text::race_registrations__status;
+
+DROP TYPE race_registrations__status_old;
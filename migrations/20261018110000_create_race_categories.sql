@@ -0,0 +1,22 @@
+-- migrate:up
+CREATE TABLE race_categories (
+  id UUID PRIMARY KEY,
+  race_id UUID NOT NULL REFERENCES races(id),
+  name VARCHAR(255) NOT NULL,
+  start_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  maximum_participants INTEGER NOT NULL,
+  minimum_age INTEGER NOT NULL DEFAULT 0,
+  maximum_age INTEGER NOT NULL DEFAULT 0,
+  UNIQUE (race_id, name)
+);
+
+ALTER TABLE
+  race_registrations
+ADD
+  COLUMN category_id UUID REFERENCES race_categories(id);
+
+-- migrate:down
+ALTER TABLE
+  race_registrations DROP COLUMN category_id;
+
+DROP TABLE race_categories;
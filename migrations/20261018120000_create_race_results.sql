@@ -0,0 +1,17 @@
+-- migrate:up
+CREATE TYPE race_results__status AS ENUM ('finished', 'dnf', 'dns', 'dsq');
+
+CREATE TABLE race_results (
+  race_id UUID NOT NULL,
+  user_id UUID NOT NULL,
+  status race_results__status NOT NULL,
+  finish_time_ms BIGINT,
+  rank INTEGER,
+  PRIMARY KEY (race_id, user_id),
+  FOREIGN KEY (race_id, user_id) REFERENCES race_registrations(race_id, user_id)
+);
+
+-- migrate:down
+DROP TABLE race_results;
+
+DROP TYPE race_results__status;
@@ -0,0 +1,12 @@
+-- migrate:up
+CREATE TABLE race_passages (
+  race_id UUID NOT NULL REFERENCES races(id),
+  bib VARCHAR(32) NOT NULL,
+  checkpoint VARCHAR(64) NOT NULL,
+  passed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  received_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (race_id, bib, checkpoint)
+);
+
+-- migrate:down
+DROP TABLE race_passages;
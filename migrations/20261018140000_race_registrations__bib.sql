@@ -0,0 +1,29 @@
+-- migrate:up
+ALTER TABLE
+  race_categories
+ADD
+  COLUMN first_bib INTEGER NOT NULL DEFAULT 0,
+ADD
+  COLUMN last_bib INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE
+  race_registrations
+ADD
+  COLUMN bib INTEGER;
+
+-- Deferred so that a save can move bibs between riders whatever the order of the updates, a swap goes through a free bib
+ALTER TABLE
+  race_registrations
+ADD
+  CONSTRAINT race_registrations_race_id_bib_key UNIQUE (race_id, bib) DEFERRABLE INITIALLY DEFERRED;
+
+-- migrate:down
+ALTER TABLE
+  race_registrations DROP CONSTRAINT race_registrations_race_id_bib_key;
+
+ALTER TABLE
+  race_registrations DROP COLUMN bib;
+
+ALTER TABLE
+  race_categories DROP COLUMN first_bib,
+  DROP COLUMN last_bib;
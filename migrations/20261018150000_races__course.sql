@@ -0,0 +1,21 @@
+-- migrate:up
+ALTER TABLE
+  races
+ADD
+  COLUMN course_file TEXT,
+ADD
+  COLUMN course_distance DOUBLE PRECISION NOT NULL DEFAULT 0,
+ADD
+  COLUMN course_elevation_gain DOUBLE PRECISION NOT NULL DEFAULT 0,
+ADD
+  COLUMN course_elevation_loss DOUBLE PRECISION NOT NULL DEFAULT 0,
+ADD
+  COLUMN course_max_gradient DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- migrate:down
+ALTER TABLE
+  races DROP COLUMN course_file,
+  DROP COLUMN course_distance,
+  DROP COLUMN course_elevation_gain,
+  DROP COLUMN course_elevation_loss,
+  DROP COLUMN course_max_gradient;
@@ -0,0 +1,13 @@
+-- migrate:up
+CREATE TABLE medical_certificate_purges (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  race_id UUID NOT NULL REFERENCES races(id),
+  user_id UUID NOT NULL,
+  medical_certificate TEXT NOT NULL,
+  purged_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX medical_certificate_purges_race_id_idx ON medical_certificate_purges (race_id);
+
+-- migrate:down
+DROP TABLE medical_certificate_purges;
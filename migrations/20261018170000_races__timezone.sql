@@ -0,0 +1,9 @@
+-- migrate:up
+ALTER TABLE
+  races
+ADD
+  COLUMN timezone TEXT NOT NULL DEFAULT 'Europe/Paris';
+
+-- migrate:down
+ALTER TABLE
+  races DROP COLUMN timezone;
@@ -0,0 +1,18 @@
+-- migrate:up
+ALTER TABLE
+  races
+ADD
+  COLUMN registration_opens_at TIMESTAMPTZ,
+ADD
+  COLUMN registration_closes_at TIMESTAMPTZ;
+
+CREATE INDEX races_registration_closes_at_idx ON races (registration_closes_at)
+WHERE
+  is_open_for_registration;
+
+-- migrate:down
+DROP INDEX races_registration_closes_at_idx;
+
+ALTER TABLE
+  races DROP COLUMN registration_opens_at,
+  DROP COLUMN registration_closes_at;
@@ -0,0 +1,34 @@
+-- migrate:up
+CREATE TYPE races__status AS ENUM ('draft', 'published', 'cancelled', 'finished');
+
+-- Races organized before drafts existed were already public
+ALTER TABLE
+  races
+ADD
+  COLUMN status races__status NOT NULL DEFAULT 'published';
+
+ALTER TABLE
+  races
+ALTER COLUMN
+  status DROP DEFAULT;
+
+CREATE TABLE notifications (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES users(id),
+  race_id UUID NOT NULL REFERENCES races(id),
+  kind TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  sent_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX notifications_pending_idx ON notifications (created_at)
+WHERE
+  sent_at IS NULL;
+
+-- migrate:down
+DROP TABLE notifications;
+
+ALTER TABLE
+  races DROP COLUMN status;
+
+DROP TYPE races__status;
@@ -0,0 +1,29 @@
+-- migrate:up
+CREATE TYPE race_organizers__role AS ENUM ('owner', 'editor', 'document_reviewer');
+
+-- Organizers added before roles existed could do everything
+ALTER TABLE
+  race_organizers
+ADD
+  COLUMN role race_organizers__role NOT NULL DEFAULT 'owner';
+
+ALTER TABLE
+  race_organizers
+ALTER COLUMN
+  role DROP DEFAULT;
+
+CREATE TABLE race_organizer_invitations (
+  race_id UUID NOT NULL REFERENCES races(id),
+  user_id UUID NOT NULL REFERENCES users(id),
+  role race_organizers__role NOT NULL,
+  invited_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (race_id, user_id)
+);
+
+-- migrate:down
+DROP TABLE race_organizer_invitations;
+
+ALTER TABLE
+  race_organizers DROP COLUMN role;
+
+DROP TYPE race_organizers__role;
@@ -0,0 +1,12 @@
+-- migrate:up
+ALTER TABLE
+  users
+ADD
+  COLUMN is_admin BOOLEAN NOT NULL DEFAULT false,
+ADD
+  COLUMN is_disabled BOOLEAN NOT NULL DEFAULT false;
+
+-- migrate:down
+ALTER TABLE
+  users DROP COLUMN is_admin,
+  DROP COLUMN is_disabled;
@@ -0,0 +1,19 @@
+-- migrate:up
+ALTER TABLE
+  users
+ADD
+  COLUMN email character varying(255) UNIQUE;
+
+CREATE TABLE password_reset_tokens (
+  token_hash BYTEA PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE
+);
+
+-- migrate:down
+DROP TABLE password_reset_tokens;
+
+ALTER TABLE
+  users DROP COLUMN email;
@@ -0,0 +1,33 @@
+-- migrate:up
+CREATE TABLE login_throttles (
+  scope TEXT NOT NULL,
+  key TEXT NOT NULL,
+  failures INTEGER NOT NULL,
+  last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (scope, key)
+);
+
+ALTER TABLE
+  users
+ADD
+  COLUMN locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Kept when the user is deleted, like the medical certificate purges
+CREATE TABLE authentication_audit_events (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL,
+  username TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX authentication_audit_events_user_id_idx ON authentication_audit_events (user_id);
+
+-- migrate:down
+DROP TABLE authentication_audit_events;
+
+ALTER TABLE
+  users DROP COLUMN locked_until;
+
+DROP TABLE login_throttles;
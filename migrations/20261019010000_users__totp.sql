@@ -0,0 +1,34 @@
+-- migrate:up
+ALTER TABLE
+  users
+ADD
+  COLUMN totp_secret BYTEA,
+ADD
+  COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT false,
+ADD
+  COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE totp_recovery_codes (
+  user_id UUID NOT NULL REFERENCES users(id),
+  code_hash BYTEA NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE,
+  PRIMARY KEY (user_id, code_hash)
+);
+
+-- Logins waiting for the second factor, after a successful password check
+CREATE TABLE pending_logins (
+  token_hash BYTEA PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0
+);
+
+-- migrate:down
+DROP TABLE pending_logins;
+
+DROP TABLE totp_recovery_codes;
+
+ALTER TABLE
+  users DROP COLUMN totp_secret,
+  DROP COLUMN totp_enabled,
+  DROP COLUMN totp_last_step;
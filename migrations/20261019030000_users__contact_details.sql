@@ -0,0 +1,32 @@
+-- migrate:up
+ALTER TABLE
+  users
+ADD
+  COLUMN email_verified_at TIMESTAMP WITH TIME ZONE,
+ADD
+  COLUMN full_name character varying(255) NOT NULL DEFAULT '',
+ADD
+  COLUMN birth_date DATE,
+ADD
+  COLUMN gender TEXT NOT NULL DEFAULT '',
+ADD
+  COLUMN phone character varying(32) NOT NULL DEFAULT '';
+
+CREATE TABLE email_verification_tokens (
+  token_hash BYTEA PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id),
+  email character varying(255) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE
+);
+
+-- migrate:down
+DROP TABLE email_verification_tokens;
+
+ALTER TABLE
+  users DROP COLUMN email_verified_at,
+  DROP COLUMN full_name,
+  DROP COLUMN birth_date,
+  DROP COLUMN gender,
+  DROP COLUMN phone;
@@ -0,0 +1,5 @@
+-- migrate:up
+UPDATE users SET language = 'en-GB' WHERE language = 'en';
+
+-- migrate:down
+UPDATE users SET language = 'en' WHERE language = 'en-GB';
@@ -0,0 +1,12 @@
+-- migrate:up
+ALTER TABLE
+  races
+ADD
+  COLUMN course_profile TEXT NOT NULL DEFAULT '',
+ADD
+  COLUMN course_outline TEXT NOT NULL DEFAULT '';
+
+-- migrate:down
+ALTER TABLE
+  races DROP COLUMN course_profile,
+  DROP COLUMN course_outline;
@@ -0,0 +1,9 @@
+-- migrate:up
+ALTER TABLE
+  sessions
+ADD
+  COLUMN is_second_factor_verified BOOLEAN NOT NULL DEFAULT false;
+
+-- migrate:down
+ALTER TABLE
+  sessions DROP COLUMN is_second_factor_verified;
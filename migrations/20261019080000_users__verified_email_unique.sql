@@ -0,0 +1,15 @@
+-- migrate:up
+ALTER TABLE
+  users DROP CONSTRAINT users_email_key;
+
+CREATE UNIQUE INDEX users_email_verified_key ON users (email)
+WHERE
+  email_verified_at IS NOT NULL;
+
+-- migrate:down
+DROP INDEX users_email_verified_key;
+
+ALTER TABLE
+  users
+ADD
+  CONSTRAINT users_email_key UNIQUE (email);
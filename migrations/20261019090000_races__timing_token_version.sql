@@ -0,0 +1,9 @@
+-- migrate:up
+ALTER TABLE
+  races
+ADD
+  COLUMN timing_token_version INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:down
+ALTER TABLE
+  races DROP COLUMN timing_token_version;
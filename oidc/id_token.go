@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrIdTokenMalformed    = errors.New("id token is malformed")
+	ErrIdTokenSignature    = errors.New("id token signature is invalid")
+	ErrIdTokenUnknownKey   = errors.New("id token is signed with an unknown key")
+	ErrIdTokenInvalidClaim = errors.New("id token has an invalid claim")
+)
+
+// keysRefetchInterval stops tokens with made up key ids from hammering the issuer
+const keysRefetchInterval = time.Minute
+
+// Claims only holds what is needed to link and create users
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+}
+
+// audience can be a single string or an array
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	*aud = multiple
+	return err
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func decodeSegment(segment string, target any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return kcore.Wrap(ErrIdTokenMalformed, err.Error())
+	}
+	err = json.Unmarshal(raw, target)
+	if err != nil {
+		return kcore.Wrap(ErrIdTokenMalformed, err.Error())
+	}
+	return nil
+}
+
+func (provider *Provider) verifyIdToken(ctx context.Context, idToken string, nonce string, now time.Time) (Claims, error) {
+	segments := strings.Split(idToken, ".")
+	if len(segments) != 3 {
+		return Claims{}, ErrIdTokenMalformed
+	}
+	var tokenHeader header
+	err := decodeSegment(segments[0], &tokenHeader)
+	if err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return Claims{}, kcore.Wrap(ErrIdTokenMalformed, err.Error())
+	}
+	key, err := provider.loadKey(ctx, tokenHeader.KeyId, now)
+	if err != nil {
+		return Claims{}, err
+	}
+	err = verifySignature(tokenHeader.Algorithm, key, segments[0]+"."+segments[1], signature)
+	if err != nil {
+		return Claims{}, err
+	}
+	var claims Claims
+	err = decodeSegment(segments[1], &claims)
+	if err != nil {
+		return Claims{}, err
+	}
+	return claims, provider.checkClaims(claims, nonce, now)
+}
+
+func (provider *Provider) checkClaims(claims Claims, nonce string, now time.Time) error {
+	if claims.Issuer != provider.issuer {
+		return kcore.Wrap(ErrIdTokenInvalidClaim, "iss")
+	}
+	if !slices.Contains(claims.Audience, provider.clientId) {
+		return kcore.Wrap(ErrIdTokenInvalidClaim, "aud")
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return kcore.Wrap(ErrIdTokenInvalidClaim, "exp")
+	}
+	if claims.Nonce != nonce {
+		return kcore.Wrap(ErrIdTokenInvalidClaim, "nonce")
+	}
+	if claims.Subject == "" {
+		return kcore.Wrap(ErrIdTokenInvalidClaim, "sub")
+	}
+	return nil
+}
+
+// verifySignature never trusts "none" nor HMAC algorithms
+func verifySignature(algorithm string, key any, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm != "RS256" || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) != nil {
+			return ErrIdTokenSignature
+		}
+	case *ecdsa.PublicKey:
+		if algorithm != "ES256" || len(signature) != 64 {
+			return ErrIdTokenSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, hash[:], r, s) {
+			return ErrIdTokenSignature
+		}
+	default:
+		return ErrIdTokenSignature
+	}
+	return nil
+}
+
+// loadKey fetches the key set again when the key is unknown, to follow the rotations of the issuer, at most once per keysRefetchInterval
+func (provider *Provider) loadKey(ctx context.Context, keyId string, now time.Time) (any, error) {
+	provider.mutex.Lock()
+	key, ok := provider.keys[keyId]
+	if ok {
+		provider.mutex.Unlock()
+		return key, nil
+	}
+	if !provider.keysFetchedAt.IsZero() && now.Sub(provider.keysFetchedAt) < keysRefetchInterval {
+		provider.mutex.Unlock()
+		return nil, ErrIdTokenUnknownKey
+	}
+	// Failed fetches count too, an issuer that is down is not retried on every login
+	provider.keysFetchedAt = now
+	provider.mutex.Unlock()
+	discovered, err := provider.loadDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = provider.getJSON(ctx, discovered.JWKSURI, &keySet)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any)
+	for _, webKey := range keySet.Keys {
+		publicKey, err := parseJSONWebKey(webKey)
+		if err == nil {
+			keys[webKey.KeyId] = publicKey
+		}
+	}
+	provider.mutex.Lock()
+	provider.keys = keys
+	provider.mutex.Unlock()
+	key, ok = keys[keyId]
+	if !ok {
+		return nil, ErrIdTokenUnknownKey
+	}
+	return key, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error decoding key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func parseJSONWebKey(webKey jsonWebKey) (any, error) {
+	switch {
+	case webKey.KeyType == "RSA":
+		n, err := decodeBigInt(webKey.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(webKey.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case webKey.KeyType == "EC" && webKey.Curve == "P-256":
+		x, err := decodeBigInt(webKey.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(webKey.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, ErrIdTokenUnknownKey
+	}
+}
@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+// MockIssuer is a development issuer that signs in anyone under the username they type, it must never be exposed
+type MockIssuer struct {
+	issuer string
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	clientId      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	username      string
+}
+
+const mockKeyId = "mock"
+
+var mockAuthorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+	<body>
+		<form method="post">
+			{{ range $name, $values := . }}<input type="hidden" name="{{ $name }}" value="{{ index $values 0 }}"/>{{ end }}
+			<input type="text" name="username" placeholder="username" required autofocus/>
+			<input type="submit" value="Sign in"/>
+		</form>
+	</body>
+</html>`))
+
+func NewMockIssuer(issuer string) *MockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	kcore.Expect(err, "error generating mock issuer key")
+	return &MockIssuer{issuer: issuer, key: key, grants: make(map[string]mockGrant)}
+}
+
+func (mock *MockIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, map[string]any{
+			"issuer":                                mock.issuer,
+			"authorization_endpoint":                mock.issuer + "/authorize",
+			"token_endpoint":                        mock.issuer + "/token",
+			"jwks_uri":                              mock.issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockKeyId,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(mock.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(mock.key.E)).Bytes()),
+		}}})
+	case "/authorize":
+		mock.authorize(w, r)
+	case "/token":
+		mock.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	kcore.Expect(json.NewEncoder(w).Encode(body), "error encoding json")
+}
+
+func (mock *MockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	kcore.Expect(r.ParseForm(), "error parsing form")
+	if r.Form.Get("response_type") != "code" || r.Form.Get("code_challenge_method") != "S256" {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet {
+		kcore.Expect(mockAuthorizeTemplate.Execute(w, r.URL.Query()), "error rendering template")
+		return
+	}
+	code, err := RandomString()
+	kcore.Expect(err, "error generating code")
+	mock.mutex.Lock()
+	mock.grants[code] = mockGrant{
+		clientId:      r.Form.Get("client_id"),
+		redirectURI:   r.Form.Get("redirect_uri"),
+		codeChallenge: r.Form.Get("code_challenge"),
+		nonce:         r.Form.Get("nonce"),
+		username:      r.Form.Get("username"),
+	}
+	mock.mutex.Unlock()
+	query := url.Values{"code": {code}, "state": {r.Form.Get("state")}}
+	http.Redirect(w, r, r.Form.Get("redirect_uri")+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+func (mock *MockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	kcore.Expect(r.ParseForm(), "error parsing form")
+	mock.mutex.Lock()
+	grant, ok := mock.grants[r.PostForm.Get("code")]
+	delete(mock.grants, r.PostForm.Get("code"))
+	mock.mutex.Unlock()
+	clientId, _, hasBasicAuth := r.BasicAuth()
+	if hasBasicAuth {
+		clientId, _ = url.QueryUnescape(clientId)
+	} else {
+		clientId = r.PostForm.Get("client_id")
+	}
+	if !ok || clientId != grant.clientId || r.PostForm.Get("redirect_uri") != grant.redirectURI || codeChallenge(r.PostForm.Get("code_verifier")) != grant.codeChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeJSON(w, map[string]string{"token_type": "Bearer", "access_token": "mock", "id_token": mock.sign(grant)})
+}
+
+func (mock *MockIssuer) sign(grant mockGrant) string {
+	tokenHeader, err := json.Marshal(header{Algorithm: "RS256", KeyId: mockKeyId})
+	kcore.Expect(err, "error encoding header")
+	claims, err := json.Marshal(map[string]any{
+		"iss":                mock.issuer,
+		"sub":                "mock|" + grant.username,
+		"aud":                grant.clientId,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":              grant.nonce,
+		"preferred_username": grant.username,
+		"email":              grant.username + "@example.com",
+		"email_verified":     true,
+	})
+	kcore.Expect(err, "error encoding claims")
+	signed := base64.RawURLEncoding.EncodeToString(tokenHeader) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, mock.key, crypto.SHA256, hash[:])
+	kcore.Expect(err, "error signing id token")
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,24 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+// RandomString is used for the state, the nonce and the PKCE verifier
+func RandomString() (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", kcore.Wrap(err, "error generating random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
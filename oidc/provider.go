@@ -0,0 +1,160 @@
+package oidc
+
+import (
+	"bike_race/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrProviderNotConfigured = errors.New("oidc provider is not configured")
+	ErrTokenExchangeFailed   = errors.New("oidc token exchange failed")
+)
+
+// Provider is discovered lazily, so that the server starts even when the issuer is down
+type Provider struct {
+	Name         string
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectURL  string
+	httpClient   *http.Client
+
+	mutex         sync.Mutex
+	discovery     *discovery
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider returns nil when no issuer is configured
+func NewProvider(conf config.OIDCConfig, baseURL string) *Provider {
+	if conf.Issuer == "" {
+		return nil
+	}
+	return &Provider{
+		Name:         conf.Name,
+		issuer:       strings.TrimSuffix(conf.Issuer, "/"),
+		clientId:     conf.ClientId,
+		clientSecret: conf.ClientSecret,
+		redirectURL:  baseURL + "/users/oidc/callback",
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (provider *Provider) Issuer() string {
+	return provider.issuer
+}
+
+func (provider *Provider) getJSON(ctx context.Context, endpoint string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return kcore.Wrap(err, "error creating request")
+	}
+	res, err := provider.httpClient.Do(req)
+	if err != nil {
+		return kcore.Wrap(err, "error requesting "+endpoint)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, endpoint)
+	}
+	err = json.NewDecoder(res.Body).Decode(target)
+	if err != nil {
+		return kcore.Wrap(err, "error decoding "+endpoint)
+	}
+	return nil
+}
+
+func (provider *Provider) loadDiscovery(ctx context.Context) (discovery, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.discovery != nil {
+		return *provider.discovery, nil
+	}
+	var loaded discovery
+	err := provider.getJSON(ctx, provider.issuer+"/.well-known/openid-configuration", &loaded)
+	if err != nil {
+		return discovery{}, err
+	}
+	if loaded.Issuer != provider.issuer {
+		return discovery{}, fmt.Errorf("discovered issuer %s does not match %s", loaded.Issuer, provider.issuer)
+	}
+	provider.discovery = &loaded
+	return loaded, nil
+}
+
+// AuthCodeURL starts the authorization code flow, with the S256 PKCE challenge of verifier
+func (provider *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	discovered, err := provider.loadDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.clientId)
+	query.Set("redirect_uri", provider.redirectURL)
+	query.Set("scope", "openid profile email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	return discovered.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+type tokenResponse struct {
+	IdToken string `json:"id_token"`
+}
+
+// Exchange redeems the code and returns the verified claims of the ID token
+func (provider *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Claims, error) {
+	discovered, err := provider.loadDiscovery(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.redirectURL)
+	form.Set("code_verifier", verifier)
+	if provider.clientSecret == "" {
+		form.Set("client_id", provider.clientId)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovered.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, kcore.Wrap(err, "error creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if provider.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(provider.clientId), url.QueryEscape(provider.clientSecret))
+	}
+	res, err := provider.httpClient.Do(req)
+	if err != nil {
+		return Claims{}, kcore.Wrap(err, "error requesting token")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Claims{}, kcore.Wrap(ErrTokenExchangeFailed, fmt.Sprintf("status %d", res.StatusCode))
+	}
+	var token tokenResponse
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return Claims{}, kcore.Wrap(err, "error decoding token response")
+	}
+	return provider.verifyIdToken(ctx, token.IdToken, nonce, time.Now())
+}
@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"bike_race/config"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newMockServer also counts the key set requests
+func newMockServer(t *testing.T) (*Provider, *atomic.Int32) {
+	var mock *MockIssuer
+	var keysRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			keysRequests.Add(1)
+		}
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	mock = NewMockIssuer(server.URL)
+	provider := NewProvider(config.OIDCConfig{Issuer: server.URL, ClientId: "bike_race", Name: "Mock"}, "http://localhost:3000")
+	return provider, &keysRequests
+}
+
+func TestAuthorizationCodeFlowWithMockIssuer(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := newMockServer(t)
+	state, nonce, verifier := "state", "nonce", "verifier"
+	authCodeURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorize, err := url.Parse(authCodeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := authorize.Query()
+	form.Set("username", "alice")
+	authorize.RawQuery = ""
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.PostForm(authorize.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(callback.String(), "http://localhost:3000/users/oidc/callback?") {
+		t.Fatalf("unexpected redirect %s", callback)
+	}
+	if callback.Query().Get("state") != state {
+		t.Errorf("expected state %s, got %s", state, callback.Query().Get("state"))
+	}
+
+	_, err = provider.Exchange(ctx, callback.Query().Get("code"), "other verifier", nonce)
+	if !errors.Is(err, ErrTokenExchangeFailed) {
+		t.Errorf("expected a wrong verifier to fail, got %v", err)
+	}
+	res, err = client.PostForm(authorize.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callback, err = url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := provider.Exchange(ctx, callback.Query().Get("code"), verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "mock|alice" || claims.PreferredUsername != "alice" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestUnknownKeyRefetchesTheKeySetOncePerInterval(t *testing.T) {
+	ctx := context.Background()
+	provider, keysRequests := newMockServer(t)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"forged"}`)) + ".e30.c2ln"
+	now := time.Now()
+
+	for _, at := range []time.Time{now, now.Add(keysRefetchInterval / 2), now.Add(keysRefetchInterval)} {
+		_, err := provider.verifyIdToken(ctx, forged, "", at)
+		if !errors.Is(err, ErrIdTokenUnknownKey) {
+			t.Fatalf("expected an unknown key, got %v", err)
+		}
+	}
+	if count := keysRequests.Load(); count != 2 {
+		t.Errorf("expected 2 key set requests, got %d", count)
+	}
+}
@@ -2,16 +2,17 @@ package race
 
 import (
 	"bike_race/auth"
+	"bike_race/media"
 	"context"
 	"errors"
 	"mime/multipart"
 	"net/http"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/martinlehoux/kagamigo/kauth"
 	"github.com/martinlehoux/kagamigo/kcore"
-	"github.com/samber/lo"
 	"golang.org/x/exp/slog"
 )
 
@@ -19,35 +20,151 @@ var (
 	ErrUserNotOrganizer = errors.New("user not an organizer")
 )
 
-func OrganizeRaceCommand(ctx context.Context, conn *pgxpool.Pool, name string) (int, error) {
+// OrganizeRaceCommand returns the id of the new race
+func OrganizeRaceCommand(ctx context.Context, conn *pgxpool.Pool, name string) (kcore.ID, int, error) {
 	logger := slog.With(slog.String("command", "OrganizeRaceCommand"))
 	currentUser, ok := auth.UserFromContext(ctx)
 	if !ok {
 		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
-		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+		return kcore.ID{}, http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
 	}
 	race, err := NewRace(name)
 	if err != nil {
 		err = kcore.Wrap(err, "error creating race")
 		logger.Warn(err.Error())
-		return http.StatusBadRequest, err
+		return kcore.ID{}, http.StatusBadRequest, err
 	}
 	err = race.AddOrganizer(currentUser)
 	if err != nil {
 		err = kcore.Wrap(err, "error adding organizer")
 		logger.Warn(err.Error())
-		return http.StatusBadRequest, err
+		return kcore.ID{}, http.StatusBadRequest, err
 	}
 	err = race.Save(ctx, conn)
 	if err != nil {
 		err = kcore.Wrap(err, "error saving race")
 		logger.Error(err.Error())
-		return http.StatusInternalServerError, err
+		return kcore.ID{}, http.StatusInternalServerError, err
 	}
-	return http.StatusCreated, nil
+	return race.Id, http.StatusCreated, nil
+}
+
+func PublishRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "PublishRaceCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("publishing race")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.Publish()
+	if err != nil {
+		err = kcore.Wrap(err, "error publishing race")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("race published")
+	return http.StatusOK, nil
+}
+
+func CancelRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "CancelRaceCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("cancelling race")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.Cancel()
+	if err != nil {
+		err = kcore.Wrap(err, "error cancelling race")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	notificationsCount := len(race.notifications)
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("race cancelled", slog.Int("notifications", notificationsCount))
+	return http.StatusOK, nil
+}
+
+func FinishRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "FinishRaceCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("finishing race")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.Finish(time.Now())
+	if err != nil {
+		err = kcore.Wrap(err, "error finishing race")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("race finished")
+	return http.StatusOK, nil
 }
 
-func OpenRaceForRegistration(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, maximumParticipants int) (int, error) {
+func OpenRaceForRegistration(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, startAt time.Time, timezone string, maximumParticipants int, opensAt time.Time, closesAt time.Time) (int, error) {
 	logger := slog.With(slog.String("raceId", raceId.String()))
 	logger.Info("opening race for registration")
 	currentUser, ok := auth.UserFromContext(ctx)
@@ -63,32 +180,45 @@ func OpenRaceForRegistration(ctx context.Context, conn *pgxpool.Pool, raceId kco
 	}
 	kcore.Expect(err, "")
 
-	if !lo.ContainsBy(race.Organizers, func(userId kcore.ID) bool { return userId == currentUser.Id }) {
-		logger.Warn(ErrUserNotOrganizer.Error())
-		return http.StatusUnauthorized, ErrUserNotOrganizer
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
 	}
 
-	err = race.OpenForRegistration(maximumParticipants)
+	now := time.Now()
+	err = race.Schedule(startAt, timezone, now)
+	if err != nil {
+		err = kcore.Wrap(err, "error scheduling race")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.OpenForRegistration(maximumParticipants, opensAt, closesAt, now)
 	if err != nil {
 		err = kcore.Wrap(err, "error opening race for registration")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
 
-	kcore.Expect(race.Save(ctx, conn), "")
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
 
 	logger.Info("race opened for registration")
 	return http.StatusOK, nil
 }
 
-func RegisterForRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
-	logger := slog.With(slog.String("command", "RegisterForRaceCommand"), slog.String("raceId", raceId.String()))
-	user, ok := auth.UserFromContext(ctx)
+func CloseRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "CloseRegistrationCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("closing race registrations")
+	currentUser, ok := auth.UserFromContext(ctx)
 	if !ok {
 		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
 		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
 	}
-	logger = logger.With(slog.String("userId", user.Id.String()))
 	race, err := LoadRace(ctx, conn, raceId)
 	if errors.Is(err, pgx.ErrNoRows) {
 		logger.Warn(err.Error())
@@ -96,22 +226,32 @@ func RegisterForRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcor
 	}
 	kcore.Expect(err, "")
 
-	err = race.Register(user)
+	err = race.CheckRole(currentUser, EditorRole)
 	if err != nil {
-		err = kcore.Wrap(err, "error registering user")
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.CloseRegistration()
+	if err != nil {
+		err = kcore.Wrap(err, "error closing registrations")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
 
-	kcore.Expect(race.Save(ctx, conn), "")
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
 
-	logger.Info("user registered to race")
+	logger.Info("race registrations closed")
 	return http.StatusOK, nil
 }
 
-func ApproveRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
-	logger := slog.With(slog.String("command", "ApproveRaceRegistrationCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
-	logger.Info("approving user registration")
+func AddRaceCategoryCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, name string, startAt time.Time, maximumParticipants int, minimumAge int, maximumAge int, bibs BibRange) (int, error) {
+	logger := slog.With(slog.String("command", "AddRaceCategoryCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("adding race category")
 	currentUser, ok := auth.UserFromContext(ctx)
 	if !ok {
 		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
@@ -124,29 +264,75 @@ func ApproveRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, rac
 	}
 	kcore.Expect(err, "")
 
-	if !race.IsOrganizer(currentUser) {
-		logger.Warn(ErrUserNotOrganizer.Error())
-		return http.StatusUnauthorized, ErrUserNotOrganizer
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
 	}
-	if !race.IsOpenForRegistration {
-		logger.Warn(ErrRegistrationsClosed.Error())
-		return http.StatusBadRequest, ErrRegistrationsClosed
+	category, err := NewRaceCategory(name, startAt, maximumParticipants, minimumAge, maximumAge, bibs)
+	if err != nil {
+		err = kcore.Wrap(err, "error creating category")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
 	}
-	err = race.ApproveRegistration(userId)
+	logger = logger.With(slog.String("categoryId", category.Id.String()))
+	err = race.AddCategory(category)
 	if err != nil {
-		err = kcore.Wrap(err, "error approving registration")
+		err = kcore.Wrap(err, "error adding category")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("race category added")
+	return http.StatusCreated, nil
+}
+
+func UpdateRaceCategoryCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, categoryId kcore.ID, name string, startAt time.Time, maximumParticipants int, minimumAge int, maximumAge int, bibs BibRange) (int, error) {
+	logger := slog.With(slog.String("command", "UpdateRaceCategoryCommand"), slog.String("raceId", raceId.String()), slog.String("categoryId", categoryId.String()))
+	logger.Info("updating race category")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.UpdateCategory(categoryId, name, startAt, maximumParticipants, minimumAge, maximumAge, bibs)
+	if err != nil {
+		err = kcore.Wrap(err, "error updating category")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
-	kcore.Expect(race.Save(ctx, conn), "")
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
 
-	logger.Info("user registration approved")
+	logger.Info("race category updated")
 	return http.StatusOK, nil
 }
 
-func ApproveRegistrationMedicalCertificateCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
-	logger := slog.With(slog.String("command", "ApproveRegistrationMedicalCertificateCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
-	logger.Info("approving user registration medical certificate")
+func RemoveRaceCategoryCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, categoryId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RemoveRaceCategoryCommand"), slog.String("raceId", raceId.String()), slog.String("categoryId", categoryId.String()))
+	logger.Info("removing race category")
 	currentUser, ok := auth.UserFromContext(ctx)
 	if !ok {
 		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
@@ -159,30 +345,39 @@ func ApproveRegistrationMedicalCertificateCommand(ctx context.Context, conn *pgx
 	}
 	kcore.Expect(err, "")
 
-	if !race.IsOrganizer(currentUser) {
-		logger.Warn(ErrUserNotOrganizer.Error())
-		return http.StatusUnauthorized, ErrUserNotOrganizer
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
 	}
-	err = race.ApproveMedicalCertificate(userId)
+	err = race.RemoveCategory(categoryId)
 	if err != nil {
-		err = kcore.Wrap(err, "error approving medical certificate")
+		err = kcore.Wrap(err, "error removing category")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
-	kcore.Expect(race.Save(ctx, conn), "")
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
 
-	logger.Info("user registration medical certificate approved")
+	logger.Info("race category removed")
 	return http.StatusOK, nil
 }
 
-func UpdateRaceDescriptionCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, clearCoverImage bool, coverImageFile multipart.File) (int, error) {
-	logger := slog.With(slog.String("command", "UpdateRaceDescriptionCommand"), slog.String("raceId", raceId.String()))
-	logger.Info("updating race description")
+func RegisterForRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, categoryId *kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RegisterForRaceCommand"), slog.String("raceId", raceId.String()))
 	currentUser, ok := auth.UserFromContext(ctx)
 	if !ok {
 		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
 		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
 	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	// The age limits are checked against the birth date of the profile, not one typed in the registration form
+	user, err := auth.LoadUser(ctx, conn, currentUser.Id)
+	kcore.Expect(err, "")
 	race, err := LoadRace(ctx, conn, raceId)
 	if errors.Is(err, pgx.ErrNoRows) {
 		logger.Warn(err.Error())
@@ -190,47 +385,70 @@ func UpdateRaceDescriptionCommand(ctx context.Context, conn *pgxpool.Pool, raceI
 	}
 	kcore.Expect(err, "")
 
-	if !race.IsOrganizer(currentUser) {
-		logger.Warn(ErrUserNotOrganizer.Error())
-		return http.StatusUnauthorized, ErrUserNotOrganizer
+	err = race.Register(user, categoryId, time.Now())
+	if err != nil {
+		err = kcore.Wrap(err, "error registering user")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
 	}
 
-	if clearCoverImage && race.CoverImage != nil {
-		err = race.CoverImage.Delete()
-		if err != nil {
-			err = kcore.Wrap(err, "error deleting old cover_image")
-			logger.Warn(err.Error())
-			return http.StatusBadRequest, err
-		}
-		race.CoverImage = nil
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
 	}
+	kcore.Expect(err, "")
 
-	if coverImageFile != nil {
-		coverImage := kcore.NewImage()
-		err = coverImage.Save(coverImageFile)
-		if err != nil {
-			err = kcore.Wrap(err, "error saving cover_image")
-			logger.Warn(err.Error())
-			return http.StatusBadRequest, err
-		}
-		race.CoverImage = &coverImage
+	logger.Info("user registered to race", slog.String("status", string(race.Registrations[user.Id].Status)))
+	return http.StatusOK, nil
+}
+
+func ApproveRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "ApproveRaceRegistrationCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+	logger.Info("approving user registration")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
 	}
+	kcore.Expect(err, "")
 
-	kcore.Expect(race.Save(ctx, conn), "")
+	err = race.CheckRole(currentUser, DocumentReviewerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.ApproveRegistration(userId)
+	if err != nil {
+		err = kcore.Wrap(err, "error approving registration")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	// Two approvals at the same time would pick the same bib
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
 
-	logger.Info("updated race description")
+	logger.Info("user registration approved", slog.Int("bib", race.Registrations[userId].Bib))
 	return http.StatusOK, nil
 }
 
-func UploadRegistrationMedicalCertificateCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, medicalCertificateFile multipart.File, medicalCertificateExt string) (int, error) {
-	logger := slog.With(slog.String("command", "UploadRegistrationMedicalCertificateCommand"), slog.String("raceId", raceId.String()))
-	logger.Info("uploading registration medical certificate")
+func AssignRaceBibCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID, bib int) (int, error) {
+	logger := slog.With(slog.String("command", "AssignRaceBibCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()), slog.Int("bib", bib))
+	logger.Info("assigning bib")
 	currentUser, ok := auth.UserFromContext(ctx)
 	if !ok {
 		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
 		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
 	}
-	logger = logger.With(slog.String("userId", currentUser.Id.String()))
 	race, err := LoadRace(ctx, conn, raceId)
 	if errors.Is(err, pgx.ErrNoRows) {
 		logger.Warn(err.Error())
@@ -238,23 +456,746 @@ func UploadRegistrationMedicalCertificateCommand(ctx context.Context, conn *pgxp
 	}
 	kcore.Expect(err, "")
 
-	medicalCertificate := kcore.NewFile(medicalCertificateExt)
-	err = medicalCertificate.Save(medicalCertificateFile)
+	err = race.CheckRole(currentUser, DocumentReviewerRole)
 	if err != nil {
-		err = kcore.Wrap(err, "error saving medical_certificate")
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.AssignBib(userId, bib)
+	if err != nil {
+		err = kcore.Wrap(err, "error assigning bib")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
-	err = race.UploadMedicalCertificate(currentUser.Id, medicalCertificate)
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("bib assigned")
+	return http.StatusOK, nil
+}
+
+func RejectRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID, reason string) (int, error) {
+	logger := slog.With(slog.String("command", "RejectRaceRegistrationCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+	logger.Info("rejecting user registration")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, DocumentReviewerRole)
 	if err != nil {
-		kcore.Expect(medicalCertificate.Delete(), "error deleting medical certificate")
-		err = kcore.Wrap(err, "error uploading medical certificate")
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.RejectRegistration(userId, reason)
+	if err != nil {
+		err = kcore.Wrap(err, "error rejecting registration")
 		logger.Warn(err.Error())
 		return http.StatusBadRequest, err
 	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
 
-	kcore.Expect(race.Save(ctx, conn), "")
+	logger.Info("user registration rejected")
+	return http.StatusOK, nil
+}
+
+func CancelRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "CancelRaceRegistrationCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+	logger.Info("cancelling user registration")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, DocumentReviewerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.CancelRegistration(userId)
+	if err != nil {
+		err = kcore.Wrap(err, "error cancelling registration")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("user registration cancelled")
+	return http.StatusOK, nil
+}
+
+func SubmitRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "SubmitRaceRegistrationCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("submitting registration")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.SubmitRegistration(currentUser.Id)
+	if err != nil {
+		err = kcore.Wrap(err, "error submitting registration")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("registration submitted")
+	return http.StatusOK, nil
+}
+
+func WithdrawRaceRegistrationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "WithdrawRaceRegistrationCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("withdrawing registration")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.WithdrawRegistration(currentUser.Id)
+	if err != nil {
+		err = kcore.Wrap(err, "error withdrawing registration")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("registration withdrawn")
+	return http.StatusOK, nil
+}
+
+func ApproveRegistrationMedicalCertificateCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "ApproveRegistrationMedicalCertificateCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+	logger.Info("approving user registration medical certificate")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, DocumentReviewerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.ApproveMedicalCertificate(userId)
+	if err != nil {
+		err = kcore.Wrap(err, "error approving medical certificate")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("user registration medical certificate approved")
+	return http.StatusOK, nil
+}
+
+func UpdateRaceDescriptionCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, clearCoverImage bool, coverImageFile multipart.File, clearCourse bool, courseFile multipart.File) (int, error) {
+	logger := slog.With(slog.String("command", "UpdateRaceDescriptionCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("updating race description")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+
+	// The replaced cover image is only deleted once the race is saved, and the new one if it is not
+	previousCoverImage := race.CoverImage
+	var newCoverImage *kcore.Image
+	if clearCoverImage {
+		race.CoverImage = nil
+	}
+
+	if coverImageFile != nil {
+		coverImage := kcore.NewImage()
+		err = coverImage.Save(coverImageFile)
+		if err != nil {
+			err = kcore.Wrap(err, "error saving cover_image")
+			logger.Warn(err.Error())
+			return http.StatusBadRequest, err
+		}
+		race.CoverImage = &coverImage
+		newCoverImage = &coverImage
+	}
+
+	previousCourse, err := updateRaceCourse(&race, clearCourse, courseFile)
+	if err != nil {
+		if newCoverImage != nil {
+			kcore.Expect(newCoverImage.Delete(), "error deleting cover image")
+		}
+		err = kcore.Wrap(err, "error updating course")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if err != nil {
+		if newCoverImage != nil {
+			kcore.Expect(newCoverImage.Delete(), "error deleting cover image")
+		}
+		if courseFile != nil {
+			kcore.Expect(race.Course.Delete(), "error deleting course")
+		}
+	}
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+	if previousCoverImage != nil && race.CoverImage != previousCoverImage {
+		err = previousCoverImage.Delete()
+		if err != nil {
+			logger.Warn(kcore.Wrap(err, "error deleting old cover image").Error())
+		}
+	}
+	if previousCourse != nil {
+		err = previousCourse.Delete()
+		if err != nil {
+			logger.Warn(kcore.Wrap(err, "error deleting old course").Error())
+		}
+	}
+
+	logger.Info("updated race description")
+	return http.StatusOK, nil
+}
+
+func UploadRegistrationMedicalCertificateCommand(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring, raceId kcore.ID, medicalCertificateFile multipart.File, medicalCertificateExt string) (int, error) {
+	logger := slog.With(slog.String("command", "UploadRegistrationMedicalCertificateCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("uploading registration medical certificate")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	logger = logger.With(slog.String("userId", currentUser.Id.String()))
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	medicalCertificate := kcore.NewFile(medicalCertificateExt)
+	err = keyring.SaveFile(medicalCertificate.Path(), medicalCertificateFile)
+	if err != nil {
+		err = kcore.Wrap(err, "error saving medical_certificate")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	previousCertificate, err := race.UploadMedicalCertificate(currentUser.Id, medicalCertificate)
+	if err != nil {
+		kcore.Expect(medicalCertificate.Delete(), "error deleting medical certificate")
+		err = kcore.Wrap(err, "error uploading medical certificate")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if err != nil {
+		kcore.Expect(medicalCertificate.Delete(), "error deleting medical certificate")
+	}
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+	if previousCertificate != nil {
+		err = previousCertificate.Delete()
+		if err != nil {
+			logger.Warn(kcore.Wrap(err, "error deleting old medical certificate").Error())
+		}
+	}
 
 	logger.Info("registration medical certificate uploaded")
 	return http.StatusOK, nil
 }
+
+func ImportRaceResultsCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, resultsFile multipart.File, mapping ResultsColumnMapping) (int, error) {
+	logger := slog.With(slog.String("command", "ImportRaceResultsCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("importing race results")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	rows, err := ParseResultsCSV(resultsFile, mapping)
+	if err != nil {
+		err = kcore.Wrap(err, "error parsing results")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	usernames, err := loadRegistrationUsernames(ctx, conn, raceId)
+	kcore.Expect(err, "")
+	results, err := resolveResultsRiders(race, rows, usernames)
+	if err != nil {
+		err = kcore.Wrap(err, "error matching results with registrations")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.ImportResults(results)
+	if err != nil {
+		err = kcore.Wrap(err, "error importing results")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("race results imported", slog.Int("count", len(results)))
+	return http.StatusOK, nil
+}
+
+func RecordRacePassagesCommand(ctx context.Context, conn *pgxpool.Pool, live *LiveTiming, timingSecret []byte, raceId kcore.ID, timingToken string, passages []Passage) (int, error) {
+	logger := slog.With(slog.String("command", "RecordRacePassagesCommand"), slog.String("raceId", raceId.String()))
+	status, version, err := loadRaceTiming(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+	err = checkTimingToken(timingSecret, raceId, version, timingToken)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	// Late reads of a finished or cancelled race would overwrite its final leaderboard
+	if status != RacePublished {
+		logger.Warn(ErrRaceNotLive.Error(), slog.String("status", string(status)))
+		return http.StatusConflict, ErrRaceNotLive
+	}
+	for _, passage := range passages {
+		err = passage.Validate()
+		if err != nil {
+			err = kcore.Wrap(err, "error validating passage")
+			logger.Warn(err.Error())
+			return http.StatusBadRequest, err
+		}
+	}
+	kcore.Expect(live.Record(ctx, raceId, passages), "")
+
+	logger.Info("race passages recorded", slog.Int("count", len(passages)))
+	return http.StatusAccepted, nil
+}
+
+func RevokeTimingTokenCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RevokeTimingTokenCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("revoking timing token")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, EditorRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	race.RevokeTimingToken()
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("timing token revoked", slog.Int("version", race.TimingTokenVersion))
+	return http.StatusOK, nil
+}
+
+func InviteOrganizerCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, username string, role OrganizerRole) (int, error) {
+	logger := slog.With(slog.String("command", "InviteOrganizerCommand"), slog.String("raceId", raceId.String()), slog.String("username", username), slog.String("role", string(role)))
+	logger.Info("inviting organizer")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	userId, err := loadUserIdByUsername(ctx, conn, username)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+	err = race.InviteOrganizer(userId, role)
+	if err != nil {
+		err = kcore.Wrap(err, "error inviting organizer")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("organizer invited")
+	return http.StatusOK, nil
+}
+
+func AcceptOrganizerInvitationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "AcceptOrganizerInvitationCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("accepting organizer invitation")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.AcceptOrganizerInvitation(currentUser.Id)
+	if err != nil {
+		err = kcore.Wrap(err, "error accepting organizer invitation")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("organizer invitation accepted")
+	return http.StatusOK, nil
+}
+
+func DeclineOrganizerInvitationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "DeclineOrganizerInvitationCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("declining organizer invitation")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.DeclineOrganizerInvitation(currentUser.Id)
+	if err != nil {
+		err = kcore.Wrap(err, "error declining organizer invitation")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("organizer invitation declined")
+	return http.StatusOK, nil
+}
+
+func RevokeOrganizerInvitationCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RevokeOrganizerInvitationCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+	logger.Info("revoking organizer invitation")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.DeclineOrganizerInvitation(userId)
+	if err != nil {
+		err = kcore.Wrap(err, "error revoking organizer invitation")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("organizer invitation revoked")
+	return http.StatusOK, nil
+}
+
+func RemoveOrganizerCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "RemoveOrganizerCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+	logger.Info("removing organizer")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.RemoveOrganizer(userId)
+	if err != nil {
+		err = kcore.Wrap(err, "error removing organizer")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("organizer removed")
+	return http.StatusOK, nil
+}
+
+func ChangeOrganizerRoleCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID, role OrganizerRole) (int, error) {
+	logger := slog.With(slog.String("command", "ChangeOrganizerRoleCommand"), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()), slog.String("role", string(role)))
+	logger.Info("changing organizer role")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.CheckRole(currentUser, OwnerRole)
+	if err != nil {
+		logger.Warn(err.Error())
+		return http.StatusUnauthorized, err
+	}
+	err = race.ChangeOrganizerRole(userId, role)
+	if err != nil {
+		err = kcore.Wrap(err, "error changing organizer role")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("organizer role changed")
+	return http.StatusOK, nil
+}
+
+func UnpublishRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "UnpublishRaceCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("unpublishing race")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	if !currentUser.IsAdmin {
+		logger.Warn(auth.ErrUserNotAdmin.Error())
+		return http.StatusUnauthorized, auth.ErrUserNotAdmin
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = race.Unpublish()
+	if err != nil {
+		err = kcore.Wrap(err, "error unpublishing race")
+		logger.Warn(err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	err = race.Save(ctx, conn)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+
+	logger.Info("race unpublished")
+	return http.StatusOK, nil
+}
+
+func DeleteRaceCommand(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+	logger := slog.With(slog.String("command", "DeleteRaceCommand"), slog.String("raceId", raceId.String()))
+	logger.Info("deleting race")
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		logger.Warn(kauth.ErrUserNotLoggedIn.Error())
+		return http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	if !currentUser.IsAdmin {
+		logger.Warn(auth.ErrUserNotAdmin.Error())
+		return http.StatusUnauthorized, auth.ErrUserNotAdmin
+	}
+	race, err := LoadRace(ctx, conn, raceId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn(err.Error())
+		return http.StatusNotFound, err
+	}
+	kcore.Expect(err, "")
+
+	err = deleteRace(ctx, conn, race)
+	if errors.Is(err, ErrRaceConcurrentUpdate) {
+		logger.Warn(err.Error())
+		return http.StatusConflict, err
+	}
+	kcore.Expect(err, "")
+	for _, file := range raceFiles(race) {
+		err = file.Delete()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	logger.Info("race deleted")
+	return http.StatusOK, nil
+}
@@ -0,0 +1,220 @@
+package race
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"os"
+	"strings"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrCourseTooShort = errors.New("course must have at least 2 points")
+)
+
+const (
+	earthRadius = 6371000.0
+	// elevationThreshold filters GPS noise out of elevation gain and loss, as most GPS units do
+	elevationThreshold = 3.0
+	// gradientWindow avoids reporting the gradient of a single noisy segment
+	gradientWindow = 100.0
+)
+
+type CoursePoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Elevation float64 `xml:"ele"`
+}
+
+type gpxDocument struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []CoursePoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []CoursePoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// ParseGPX reads tracks, or routes when the file has no track
+func ParseGPX(raw io.Reader) ([]CoursePoint, error) {
+	var document gpxDocument
+	err := xml.NewDecoder(raw).Decode(&document)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error decoding gpx")
+	}
+	points := []CoursePoint{}
+	for _, track := range document.Tracks {
+		for _, segment := range track.Segments {
+			points = append(points, segment.Points...)
+		}
+	}
+	if len(points) == 0 {
+		for _, route := range document.Routes {
+			points = append(points, route.Points...)
+		}
+	}
+	if len(points) < 2 {
+		return nil, ErrCourseTooShort
+	}
+	return points, nil
+}
+
+func distanceBetween(from CoursePoint, to CoursePoint) float64 {
+	lat1 := from.Latitude * math.Pi / 180
+	lat2 := to.Latitude * math.Pi / 180
+	deltaLat := lat2 - lat1
+	deltaLon := (to.Longitude - from.Longitude) * math.Pi / 180
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// cumulativeDistances gives the distance from the start at each point, in meters
+func cumulativeDistances(points []CoursePoint) []float64 {
+	distances := make([]float64, len(points))
+	for i := 1; i < len(points); i++ {
+		distances[i] = distances[i-1] + distanceBetween(points[i-1], points[i])
+	}
+	return distances
+}
+
+// CourseStats are in meters, and percents for the gradient
+type CourseStats struct {
+	Distance      float64
+	ElevationGain float64
+	ElevationLoss float64
+	MaxGradient   float64
+	// Drawing is computed with the stats, so that pages do not parse the file
+	Drawing CourseDrawing
+}
+
+func NewCourseStats(points []CoursePoint) CourseStats {
+	distances := cumulativeDistances(points)
+	stats := CourseStats{Distance: distances[len(distances)-1]}
+	reference := points[0].Elevation
+	for _, point := range points[1:] {
+		delta := point.Elevation - reference
+		if delta >= elevationThreshold {
+			stats.ElevationGain += delta
+			reference = point.Elevation
+		} else if delta <= -elevationThreshold {
+			stats.ElevationLoss -= delta
+			reference = point.Elevation
+		}
+	}
+	start := 0
+	for end := range points {
+		for start+1 < end && distances[end]-distances[start+1] >= gradientWindow {
+			start++
+		}
+		if window := distances[end] - distances[start]; window >= gradientWindow {
+			stats.MaxGradient = math.Max(stats.MaxGradient, (points[end].Elevation-points[start].Elevation)/window*100)
+		}
+	}
+	return stats
+}
+
+// saveCourse keeps the uploaded file as is, so that riders can download it for their GPS
+func saveCourse(courseFile multipart.File) (kcore.File, CourseStats, error) {
+	points, err := ParseGPX(courseFile)
+	if err != nil {
+		return "", CourseStats{}, err
+	}
+	_, err = courseFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", CourseStats{}, kcore.Wrap(err, "error rewinding course file")
+	}
+	file := kcore.NewFile(".gpx")
+	err = file.Save(courseFile)
+	if err != nil {
+		return "", CourseStats{}, kcore.Wrap(err, "error saving course file")
+	}
+	stats := NewCourseStats(points)
+	stats.Drawing = NewCourseDrawing(points)
+	return file, stats, nil
+}
+
+// CourseDrawing holds SVG polyline points, in a 1000x250 box for the profile and a 500x500 box for the outline
+type CourseDrawing struct {
+	Profile string
+	Outline string
+}
+
+// maxDrawingPoints keeps pages light for courses recorded every second
+const maxDrawingPoints = 500
+
+func scale(value float64, low float64, high float64, size float64) float64 {
+	if high == low {
+		return size / 2
+	}
+	return (value - low) / (high - low) * size
+}
+
+func NewCourseDrawing(points []CoursePoint) CourseDrawing {
+	distances := cumulativeDistances(points)
+	step := len(points)/maxDrawingPoints + 1
+	minEle, maxEle := math.Inf(1), math.Inf(-1)
+	minLat, maxLat, minLon, maxLon := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, point := range points {
+		minEle, maxEle = math.Min(minEle, point.Elevation), math.Max(maxEle, point.Elevation)
+		minLat, maxLat = math.Min(minLat, point.Latitude), math.Max(maxLat, point.Latitude)
+		minLon, maxLon = math.Min(minLon, point.Longitude), math.Max(maxLon, point.Longitude)
+	}
+	// Longitudes are shrunk so that the outline is not stretched away from the equator
+	lonFactor := math.Cos((minLat + maxLat) / 2 * math.Pi / 180)
+	span := math.Max(maxLat-minLat, (maxLon-minLon)*lonFactor)
+	indexes := []int{}
+	for i := 0; i < len(points)-1; i += step {
+		indexes = append(indexes, i)
+	}
+	indexes = append(indexes, len(points)-1)
+	profile := []string{}
+	outline := []string{}
+	for _, i := range indexes {
+		point := points[i]
+		profile = append(profile, fmt.Sprintf("%.1f,%.1f", scale(distances[i], 0, distances[len(distances)-1], 1000), 250-scale(point.Elevation, minEle, maxEle, 250)))
+		outline = append(outline, fmt.Sprintf("%.1f,%.1f", scale((point.Longitude-minLon)*lonFactor, 0, span, 500), 500-scale(point.Latitude-minLat, 0, span, 500)))
+	}
+	return CourseDrawing{Profile: strings.Join(profile, " "), Outline: strings.Join(outline, " ")}
+}
+
+// updateRaceCourse replaces the course when a file is uploaded, or removes it when asked to.
+// The replaced file is returned, it must only be deleted once the race is saved.
+func updateRaceCourse(race *Race, clearCourse bool, courseFile multipart.File) (*kcore.File, error) {
+	if courseFile == nil {
+		if clearCourse {
+			return race.ClearCourse(), nil
+		}
+		return nil, nil
+	}
+	file, stats, err := saveCourse(courseFile)
+	if err != nil {
+		return nil, err
+	}
+	previous := race.ClearCourse()
+	race.Course = &file
+	race.CourseStats = stats
+	return previous, nil
+}
+
+// LoadCourseDrawing is for courses uploaded before their drawing was saved, a missing file has no drawing
+func LoadCourseDrawing(file kcore.File) (CourseDrawing, error) {
+	raw, err := os.Open(file.Path()) // #nosec G304
+	if errors.Is(err, os.ErrNotExist) {
+		return CourseDrawing{}, nil
+	} else if err != nil {
+		return CourseDrawing{}, kcore.Wrap(err, "error opening course file")
+	}
+	defer raw.Close()
+	points, err := ParseGPX(raw)
+	if err != nil {
+		return CourseDrawing{}, err
+	}
+	return NewCourseDrawing(points), nil
+}
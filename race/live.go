@@ -0,0 +1,363 @@
+package race
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrPassageBibMissing        = errors.New("passage bib is missing")
+	ErrPassageCheckpointMissing = errors.New("passage checkpoint is missing")
+	ErrPassageTimestampMissing  = errors.New("passage timestamp is missing")
+	ErrTimingTokenInvalid       = errors.New("timing token is invalid")
+	ErrRaceNotLive              = errors.New("passages can only be recorded for a published race")
+)
+
+// Passage is a rider crossing a timing mat
+type Passage struct {
+	Bib        string    `json:"bib"`
+	Checkpoint string    `json:"checkpoint"`
+	PassedAt   time.Time `json:"timestamp"`
+}
+
+func (passage Passage) Validate() error {
+	switch {
+	case passage.Bib == "":
+		return ErrPassageBibMissing
+	case passage.Checkpoint == "":
+		return ErrPassageCheckpointMissing
+	case passage.PassedAt.IsZero():
+		return ErrPassageTimestampMissing
+	}
+	return nil
+}
+
+// timingToken is given to the timing system of a race, it does not need to be stored because it is derived from the timing secret.
+// The version is part of it, so that organizers can revoke the token of their race.
+func timingToken(secret []byte, raceId kcore.ID, version int) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("timing:" + raceId.String() + ":" + strconv.Itoa(version)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkTimingToken(secret []byte, raceId kcore.ID, version int, token string) error {
+	if !hmac.Equal([]byte(timingToken(secret, raceId, version)), []byte(token)) {
+		return ErrTimingTokenInvalid
+	}
+	return nil
+}
+
+// RevokeTimingToken replaces the timing token, the timing system has to be given the new one
+func (race *Race) RevokeTimingToken() {
+	race.TimingTokenVersion++
+}
+
+// loadRaceTiming is lighter than LoadRace, passages are recorded several times per second during a race
+func loadRaceTiming(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (RaceStatus, int, error) {
+	var status RaceStatus
+	var version int
+	err := conn.QueryRow(ctx, `SELECT status, timing_token_version FROM races WHERE id = $1`, raceId).Scan(&status, &version)
+	if err != nil {
+		return "", 0, kcore.Wrap(err, "error selecting races table")
+	}
+	return status, version, nil
+}
+
+type LeaderboardEntry struct {
+	Bib            string
+	Rider          string
+	Checkpoints    int
+	LastCheckpoint string
+	LastPassedAt   time.Time
+	Elapsed        time.Duration
+}
+
+// computeLeaderboard ranks riders by number of checkpoints passed, then by time of their last passage
+func computeLeaderboard(passages map[string]map[string]time.Time, riders map[string]string) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(passages))
+	for bib, checkpoints := range passages {
+		entry := LeaderboardEntry{Bib: bib, Rider: riders[bib], Checkpoints: len(checkpoints)}
+		var firstPassedAt time.Time
+		for checkpoint, passedAt := range checkpoints {
+			if firstPassedAt.IsZero() || passedAt.Before(firstPassedAt) {
+				firstPassedAt = passedAt
+			}
+			if passedAt.After(entry.LastPassedAt) {
+				entry.LastPassedAt = passedAt
+				entry.LastCheckpoint = checkpoint
+			}
+		}
+		entry.Elapsed = entry.LastPassedAt.Sub(firstPassedAt)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Checkpoints != entries[j].Checkpoints {
+			return entries[i].Checkpoints > entries[j].Checkpoints
+		}
+		if !entries[i].LastPassedAt.Equal(entries[j].LastPassedAt) {
+			return entries[i].LastPassedAt.Before(entries[j].LastPassedAt)
+		}
+		return entries[i].Bib < entries[j].Bib
+	})
+	return entries
+}
+
+// raceLeaderboard is only kept while someone watches it, refs counts the subscribers and the requests loading it
+type raceLeaderboard struct {
+	mutex       sync.Mutex
+	isLoaded    bool
+	refs        int
+	passages    map[string]map[string]time.Time
+	riders      map[string]string
+	subscribers map[chan []LeaderboardEntry]struct{}
+}
+
+func (leaderboard *raceLeaderboard) record(passage Passage) {
+	checkpoints, ok := leaderboard.passages[passage.Bib]
+	if !ok {
+		checkpoints = map[string]time.Time{}
+		leaderboard.passages[passage.Bib] = checkpoints
+	}
+	// Mats often read the same chip several times, the first read is the passage
+	if passedAt, ok := checkpoints[passage.Checkpoint]; !ok || passage.PassedAt.Before(passedAt) {
+		checkpoints[passage.Checkpoint] = passage.PassedAt
+	}
+}
+
+// load must be called with the leaderboard mutex held, passages recorded during the load are applied after it as they wait for the mutex
+func (leaderboard *raceLeaderboard) load(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) error {
+	if leaderboard.isLoaded {
+		return nil
+	}
+	passages, err := loadRacePassages(ctx, conn, raceId)
+	if err != nil {
+		return err
+	}
+	riders, err := loadRaceRiders(ctx, conn, raceId)
+	if err != nil {
+		return err
+	}
+	leaderboard.riders = riders
+	for _, passage := range passages {
+		leaderboard.record(passage)
+	}
+	leaderboard.isLoaded = true
+	return nil
+}
+
+// LiveTiming keeps the leaderboards of running races in memory while they are watched, they are rebuilt from the database otherwise.
+// The mutex only guards the boards map, each leaderboard has its own mutex held during its database loads.
+type LiveTiming struct {
+	conn   *pgxpool.Pool
+	mutex  sync.Mutex
+	boards map[kcore.ID]*raceLeaderboard
+}
+
+func NewLiveTiming(conn *pgxpool.Pool) *LiveTiming {
+	return &LiveTiming{
+		conn:   conn,
+		boards: map[kcore.ID]*raceLeaderboard{},
+	}
+}
+
+func (live *LiveTiming) acquire(raceId kcore.ID) *raceLeaderboard {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	leaderboard, ok := live.boards[raceId]
+	if !ok {
+		leaderboard = &raceLeaderboard{
+			passages:    map[string]map[string]time.Time{},
+			subscribers: map[chan []LeaderboardEntry]struct{}{},
+		}
+		live.boards[raceId] = leaderboard
+	}
+	leaderboard.refs++
+	return leaderboard
+}
+
+// release evicts the leaderboard when nobody watches it anymore
+func (live *LiveTiming) release(raceId kcore.ID, leaderboard *raceLeaderboard) {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	leaderboard.refs--
+	if leaderboard.refs == 0 && live.boards[raceId] == leaderboard {
+		delete(live.boards, raceId)
+	}
+}
+
+func (live *LiveTiming) Record(ctx context.Context, raceId kcore.ID, passages []Passage) error {
+	err := saveRacePassages(ctx, live.conn, raceId, passages)
+	if err != nil {
+		return err
+	}
+	live.mutex.Lock()
+	leaderboard, ok := live.boards[raceId]
+	live.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	// Bibs can be reassigned until the start
+	riders, err := loadRaceRiders(ctx, live.conn, raceId)
+	if err != nil {
+		return err
+	}
+	leaderboard.mutex.Lock()
+	defer leaderboard.mutex.Unlock()
+	if !leaderboard.isLoaded {
+		return nil
+	}
+	leaderboard.riders = riders
+	for _, passage := range passages {
+		leaderboard.record(passage)
+	}
+	entries := computeLeaderboard(leaderboard.passages, leaderboard.riders)
+	for subscriber := range leaderboard.subscribers {
+		// Slow subscribers skip intermediate updates and only get the latest one
+		select {
+		case <-subscriber:
+		default:
+		}
+		subscriber <- entries
+	}
+	return nil
+}
+
+// Leaderboard returns the current leaderboard, without keeping it in memory when nobody subscribed to it
+func (live *LiveTiming) Leaderboard(ctx context.Context, raceId kcore.ID) ([]LeaderboardEntry, error) {
+	leaderboard := live.acquire(raceId)
+	defer live.release(raceId, leaderboard)
+	leaderboard.mutex.Lock()
+	defer leaderboard.mutex.Unlock()
+	err := leaderboard.load(ctx, live.conn, raceId)
+	if err != nil {
+		return nil, err
+	}
+	return computeLeaderboard(leaderboard.passages, leaderboard.riders), nil
+}
+
+// Subscribe returns the current leaderboard, and a channel receiving the next ones until unsubscribe is called or the race ends
+func (live *LiveTiming) Subscribe(ctx context.Context, raceId kcore.ID) ([]LeaderboardEntry, <-chan []LeaderboardEntry, func(), error) {
+	leaderboard := live.acquire(raceId)
+	leaderboard.mutex.Lock()
+	defer leaderboard.mutex.Unlock()
+	err := leaderboard.load(ctx, live.conn, raceId)
+	if err != nil {
+		live.release(raceId, leaderboard)
+		return nil, nil, nil, err
+	}
+	updates := make(chan []LeaderboardEntry, 1)
+	leaderboard.subscribers[updates] = struct{}{}
+	unsubscribe := func() {
+		leaderboard.mutex.Lock()
+		delete(leaderboard.subscribers, updates)
+		leaderboard.mutex.Unlock()
+		live.release(raceId, leaderboard)
+	}
+	return computeLeaderboard(leaderboard.passages, leaderboard.riders), updates, unsubscribe, nil
+}
+
+// End closes the streams of a race that no longer runs and evicts its leaderboard
+func (live *LiveTiming) End(raceId kcore.ID) {
+	live.mutex.Lock()
+	leaderboard, ok := live.boards[raceId]
+	delete(live.boards, raceId)
+	live.mutex.Unlock()
+	if !ok {
+		return
+	}
+	leaderboard.mutex.Lock()
+	defer leaderboard.mutex.Unlock()
+	for subscriber := range leaderboard.subscribers {
+		close(subscriber)
+		delete(leaderboard.subscribers, subscriber)
+	}
+}
+
+// Ending wraps the commands that end a race, like finishing or cancelling it, to end its live leaderboard when they succeed
+func (live *LiveTiming) Ending(command func(context.Context, *pgxpool.Pool, kcore.ID) (int, error)) func(context.Context, *pgxpool.Pool, kcore.ID) (int, error) {
+	return func(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (int, error) {
+		code, err := command(ctx, conn, raceId)
+		if err == nil {
+			live.End(raceId)
+		}
+		return code, err
+	}
+}
+
+func loadRacePassages(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) ([]Passage, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT bib, checkpoint, passed_at
+	FROM race_passages
+	WHERE race_id = $1
+	`, raceId)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_passages table")
+	}
+	defer rows.Close()
+	passages := []Passage{}
+	for rows.Next() {
+		var passage Passage
+		err := rows.Scan(&passage.Bib, &passage.Checkpoint, &passage.PassedAt)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error scanning race_passages table")
+		}
+		passages = append(passages, passage)
+	}
+	return passages, nil
+}
+
+func loadRaceRiders(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (map[string]string, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT race_registrations.bib::text, users.username
+	FROM race_registrations
+	INNER JOIN users ON users.id = race_registrations.user_id
+	WHERE race_registrations.race_id = $1 AND race_registrations.bib IS NOT NULL
+	`, raceId)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_registrations table")
+	}
+	defer rows.Close()
+	riders := map[string]string{}
+	for rows.Next() {
+		var bib, username string
+		err := rows.Scan(&bib, &username)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error scanning race_registrations table")
+		}
+		riders[bib] = username
+	}
+	return riders, nil
+}
+
+func saveRacePassages(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, passages []Passage) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	for _, passage := range passages {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO race_passages (race_id, bib, checkpoint, passed_at, received_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (race_id, bib, checkpoint) DO UPDATE SET passed_at = least(race_passages.passed_at, $4)
+		`, raceId, passage.Bib, passage.Checkpoint, passage.PassedAt)
+		if err != nil {
+			return kcore.Wrap(err, "error upserting race_passages table")
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
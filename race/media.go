@@ -0,0 +1,61 @@
+package race
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrMediaUrlExpired = errors.New("media url has expired")
+	ErrMediaUrlInvalid = errors.New("media url signature is invalid")
+)
+
+// mediaUrlTTL is long enough to open a document from the page, and short enough for a shared link to become useless
+const mediaUrlTTL = 15 * time.Minute
+
+func medicalCertificatePath(raceId kcore.ID, userId kcore.ID) string {
+	return fmt.Sprintf("/races/%s/registrations/%s/medical_certificate", raceId.String(), userId.String())
+}
+
+func mediaSignature(secret []byte, path string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(fmt.Sprintf("%s:%d", path, expiresAt)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signMediaUrl(secret []byte, path string, now time.Time) string {
+	expiresAt := now.Add(mediaUrlTTL).Unix()
+	return fmt.Sprintf("%s?expires=%d&signature=%s", path, expiresAt, mediaSignature(secret, path, expiresAt))
+}
+
+func checkMediaUrl(secret []byte, r *http.Request, now time.Time) error {
+	expiresAt, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return ErrMediaUrlInvalid
+	}
+	if !hmac.Equal([]byte(mediaSignature(secret, r.URL.Path, expiresAt)), []byte(r.URL.Query().Get("signature"))) {
+		return ErrMediaUrlInvalid
+	}
+	if now.Unix() > expiresAt {
+		return ErrMediaUrlExpired
+	}
+	return nil
+}
+
+// signMedicalCertificateUrls only gives links to users allowed to download the certificates
+func signMedicalCertificateUrls(secret []byte, raceId kcore.ID, registrations []RaceRegistrationModel) {
+	now := time.Now()
+	for i, registration := range registrations {
+		if registration.Permissions.CanViewMedicalCertificate {
+			registrations[i].MedicalCertificateUrl = signMediaUrl(secret, medicalCertificatePath(raceId, registration.User.Id), now)
+		}
+	}
+}
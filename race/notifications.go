@@ -0,0 +1,119 @@
+package race
+
+import (
+	"bike_race/mail"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+const notificationsBatchSize = 100
+
+type pendingNotification struct {
+	Id              kcore.ID
+	Kind            NotificationKind
+	Username        string
+	UserLanguage    string
+	Email           string
+	IsEmailVerified bool
+	RaceId          kcore.ID
+	RaceName        string
+}
+
+func (notification pendingNotification) Language() string {
+	return notification.UserLanguage
+}
+
+func loadPendingNotifications(ctx context.Context, conn *pgxpool.Pool) ([]pendingNotification, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT notifications.id, notifications.kind, users.username, users.language, coalesce(users.email, ''), users.email_verified_at IS NOT NULL, races.id, races.name
+	FROM notifications
+	INNER JOIN users ON users.id = notifications.user_id
+	INNER JOIN races ON races.id = notifications.race_id
+	WHERE notifications.sent_at IS NULL
+	ORDER BY notifications.created_at
+	LIMIT $1
+	`, notificationsBatchSize)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting notifications table")
+	}
+	notifications, err := pgx.CollectRows(rows, pgx.RowToStructByPos[pendingNotification])
+	if err != nil {
+		return nil, kcore.Wrap(err, "error scanning notifications table")
+	}
+	return notifications, nil
+}
+
+func markNotificationSent(ctx context.Context, conn *pgxpool.Pool, notificationId kcore.ID, now time.Time) error {
+	_, err := conn.Exec(ctx, `UPDATE notifications SET sent_at = $2 WHERE id = $1`, notificationId, now)
+	if err != nil {
+		return kcore.Wrap(err, "error updating notifications table")
+	}
+	return nil
+}
+
+func notificationMessage(notification pendingNotification, baseURL string) (mail.Message, bool) {
+	tr := kcore.GetTr(notification)
+	link := baseURL + raceDetailsUrl(notification.RaceId)
+	switch notification.Kind {
+	case RaceCancelledNotification:
+		return mail.Message{
+			To:      notification.Email,
+			Subject: tr("raceCancelledMailSubject", notification.RaceName),
+			Body:    tr("raceCancelledMailBody", notification.Username, notification.RaceName, link),
+		}, true
+	}
+	return mail.Message{}, false
+}
+
+// sendPendingNotifications leaves the notifications whose mail failed pending, so that they are sent again on the next run.
+// Riders without a verified email can not be notified, their notifications are marked as sent.
+func sendPendingNotifications(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string) {
+	notifications, err := loadPendingNotifications(ctx, conn)
+	if err != nil {
+		slog.Error(kcore.Wrap(err, "error loading notifications").Error())
+		return
+	}
+	sent := 0
+	for _, notification := range notifications {
+		logger := slog.With(slog.String("notificationId", notification.Id.String()), slog.String("kind", string(notification.Kind)))
+		message, ok := notificationMessage(notification, baseURL)
+		if !ok {
+			logger.Error("unknown notification kind")
+		} else if !notification.IsEmailVerified {
+			logger.Info("notification skipped without a verified email")
+		} else {
+			err = mailer.Send(ctx, message)
+			if err != nil {
+				logger.Error(kcore.Wrap(err, "error sending notification").Error())
+				continue
+			}
+			sent++
+		}
+		err = markNotificationSent(ctx, conn, notification.Id, time.Now())
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	if sent > 0 {
+		slog.Info("notifications sent", slog.Int("count", sent))
+	}
+}
+
+// RunNotificationsSending mails the notifications queued with the race changes, until ctx is done
+func RunNotificationsSending(ctx context.Context, conn *pgxpool.Pool, mailer mail.Mailer, baseURL string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sendPendingNotifications(ctx, conn, mailer, baseURL)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -12,47 +12,117 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/martinlehoux/kagamigo/kauth"
 	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/samber/lo"
 )
 
 var (
-	ErrRaceNotFound = errors.New("race not found")
+	ErrRaceNotFound                 = errors.New("race not found")
+	ErrMedicalCertificateNotAllowed = errors.New("only the rider and the organizers can download a medical certificate")
 )
 
+type RaceCategoryModel struct {
+	Id                  kcore.ID
+	Name                string
+	StartAt             time.Time
+	RegisteredCount     int
+	MaximumParticipants int
+	MinimumAge          int
+	MaximumAge          int
+	FirstBib            int
+	LastBib             int
+}
+
+func (category RaceCategoryModel) IsFull() bool {
+	return category.RegisteredCount >= category.MaximumParticipants
+}
+
+func (category RaceCategoryModel) HasAgeLimits() bool {
+	return category.MinimumAge > 0 || category.MaximumAge > 0
+}
+
+func raceCategoryModels(ctx context.Context, conn *pgxpool.Pool, raceIds []kcore.ID) map[kcore.ID][]RaceCategoryModel {
+	rows, err := conn.Query(ctx, `
+		SELECT
+			race_categories.race_id, race_categories.id, race_categories.name, race_categories.start_at,
+			race_categories.maximum_participants, race_categories.minimum_age, race_categories.maximum_age, race_categories.first_bib, race_categories.last_bib,
+			count(race_registrations.user_id) filter (where race_registrations.status IN ('registered', 'submitted', 'approved'))
+		FROM race_categories
+		LEFT JOIN race_registrations ON race_registrations.category_id = race_categories.id
+		WHERE race_categories.race_id = ANY($1)
+		GROUP BY race_categories.id
+		ORDER BY race_categories.start_at, race_categories.name
+		`, raceIds)
+	kcore.Expect(err, "error querying race_categories")
+	defer rows.Close()
+
+	categories := map[kcore.ID][]RaceCategoryModel{}
+	for rows.Next() {
+		var raceId kcore.ID
+		var category RaceCategoryModel
+		kcore.Expect(rows.Scan(&raceId, &category.Id, &category.Name, &category.StartAt, &category.MaximumParticipants, &category.MinimumAge, &category.MaximumAge, &category.FirstBib, &category.LastBib, &category.RegisteredCount), "error scanning race_categories")
+		categories[raceId] = append(categories[raceId], category)
+	}
+	return categories
+}
+
 type RaceListModel struct {
 	Id                    kcore.ID
 	Name                  string
+	Status                RaceStatus
 	StartAt               time.Time
+	Location              *time.Location
 	IsOpenForRegistration bool
+	RegistrationClosesAt  time.Time
 	Organizers            string
 	RegisteredCount       int
+	WaitlistedCount       int
 	MaximumParticipants   int
 	CoverImage            string
+	Categories            []RaceCategoryModel
 	// Permissions
 	CanRegister bool
 }
 
+func (race RaceListModel) IsFull() bool {
+	return race.RegisteredCount >= race.MaximumParticipants
+}
+
+func (race RaceListModel) HasAgeLimits() bool {
+	return lo.ContainsBy(race.Categories, RaceCategoryModel.HasAgeLimits)
+}
+
 func RaceListQuery(ctx context.Context, conn *pgxpool.Pool) ([]RaceListModel, int, error) {
 	currentUser, isLoggedIn := auth.UserFromContext(ctx)
 	var hasUserRegisteredSelect string
+	// Drafts are only listed for their organizers
+	var visibleWhere string
 	var queryArgs []interface{}
 	if isLoggedIn {
-		hasUserRegisteredSelect = `coalesce(bool_or(race_registrations.user_id = $1), false)`
+		hasUserRegisteredSelect = `coalesce(bool_or(race_registrations.user_id = $1 AND race_registrations.status IN ('registered', 'submitted', 'approved', 'waitlisted')), false)`
+		visibleWhere = `races.status != 'draft' OR races.id IN (SELECT race_id FROM race_organizers WHERE user_id = $1)`
 		queryArgs = append(queryArgs, currentUser.Id)
 	} else {
 		hasUserRegisteredSelect = `false`
+		visibleWhere = `races.status != 'draft'`
 	}
 	rows, err := conn.Query(ctx, fmt.Sprintf(`
 		SELECT
-			races.id, races.name, races.start_at, races.is_open_for_registration, races.maximum_participants, coalesce(races.cover_image_id::text, ''),
+			races.id, races.name, races.status, races.start_at, races.timezone, races.maximum_participants, coalesce(races.cover_image_id::text, ''),
+			races.is_open_for_registration
+				AND coalesce(races.registration_opens_at <= now(), true)
+				AND coalesce(races.registration_closes_at > now(), true),
+			coalesce(races.registration_closes_at, '0001-01-01T00:00:00Z'),
 			string_agg(users.username, ', '),
-			count(distinct race_registrations.user_id) filter (where race_registrations.user_id is not null),
+			count(distinct race_registrations.user_id) filter (where race_registrations.status IN ('registered', 'submitted', 'approved')),
+			count(distinct race_registrations.user_id) filter (where race_registrations.status = 'waitlisted'),
 			%s
 		FROM races
 		LEFT JOIN race_organizers ON races.id = race_organizers.race_id
 		LEFT JOIN users ON race_organizers.user_id = users.id
 		LEFT JOIN race_registrations on races.id = race_registrations.race_id
+		WHERE %s
 		GROUP BY races.id, races.name
-		`, hasUserRegisteredSelect), queryArgs...)
+		`, hasUserRegisteredSelect, visibleWhere), queryArgs...)
 	kcore.Expect(err, "error querying races")
 	defer rows.Close()
 
@@ -60,84 +130,315 @@ func RaceListQuery(ctx context.Context, conn *pgxpool.Pool) ([]RaceListModel, in
 	for rows.Next() {
 		var hasUserRegistered bool
 		var row RaceListModel
-		kcore.Expect(rows.Scan(&row.Id, &row.Name, &row.StartAt, &row.IsOpenForRegistration, &row.MaximumParticipants, &row.CoverImage, &row.Organizers, &row.RegisteredCount, &hasUserRegistered), "error scanning races")
-		row.CanRegister = isLoggedIn && row.IsOpenForRegistration && row.RegisteredCount < 100 && !hasUserRegistered
+		var timezone string
+		kcore.Expect(rows.Scan(&row.Id, &row.Name, &row.Status, &row.StartAt, &timezone, &row.MaximumParticipants, &row.CoverImage, &row.IsOpenForRegistration, &row.RegistrationClosesAt, &row.Organizers, &row.RegisteredCount, &row.WaitlistedCount, &hasUserRegistered), "error scanning races")
+		row.CanRegister = isLoggedIn && row.IsOpenForRegistration && !hasUserRegistered
+		row.Location = raceLocation(timezone)
 		races = append(races, row)
 	}
+	categories := raceCategoryModels(ctx, conn, lo.Map(races, func(race RaceListModel, _ int) kcore.ID { return race.Id }))
+	for i := range races {
+		races[i].Categories = categories[races[i].Id]
+	}
 	return races, http.StatusOK, nil
 }
 
+type OrganizerInvitationModel struct {
+	RaceId   kcore.ID
+	RaceName string
+	Role     OrganizerRole
+}
+
+// OrganizerInvitationsQuery lists the invitations of the current user, including for draft races
+func OrganizerInvitationsQuery(ctx context.Context, conn *pgxpool.Pool) ([]OrganizerInvitationModel, int, error) {
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return []OrganizerInvitationModel{}, http.StatusOK, nil
+	}
+	rows, err := conn.Query(ctx, `
+		SELECT races.id, races.name, race_organizer_invitations.role
+		FROM race_organizer_invitations
+		INNER JOIN races ON races.id = race_organizer_invitations.race_id
+		WHERE race_organizer_invitations.user_id = $1
+		ORDER BY race_organizer_invitations.invited_at
+		`, currentUser.Id)
+	kcore.Expect(err, "error querying race_organizer_invitations")
+	invitations, err := pgx.CollectRows(rows, pgx.RowToStructByPos[OrganizerInvitationModel])
+	kcore.Expect(err, "error scanning race_organizer_invitations")
+	return invitations, http.StatusOK, nil
+}
+
+type AdminRaceListModel struct {
+	Id         kcore.ID
+	Name       string
+	Status     RaceStatus
+	Organizers string
+}
+
+// AdminRaceListQuery lists every race, drafts included, for the moderation console
+func AdminRaceListQuery(ctx context.Context, conn *pgxpool.Pool) ([]AdminRaceListModel, int, error) {
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok || !currentUser.IsAdmin {
+		return nil, http.StatusUnauthorized, auth.ErrUserNotAdmin
+	}
+	rows, err := conn.Query(ctx, `
+		SELECT races.id, races.name, races.status, coalesce(string_agg(users.username, ', ' ORDER BY users.username), '')
+		FROM races
+		LEFT JOIN race_organizers ON race_organizers.race_id = races.id
+		LEFT JOIN users ON users.id = race_organizers.user_id
+		GROUP BY races.id
+		ORDER BY races.name
+		`)
+	kcore.Expect(err, "error querying races")
+	races, err := pgx.CollectRows(rows, pgx.RowToStructByPos[AdminRaceListModel])
+	kcore.Expect(err, "error scanning races")
+	return races, http.StatusOK, nil
+}
+
+// raceLocation only expects valid timezones, as they are checked before being saved
+func raceLocation(timezone string) *time.Location {
+	location, err := LoadRaceLocation(timezone)
+	kcore.Expect(err, "error loading race location")
+	return location
+}
+
+func RaceLocationQuery(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (*time.Location, int, error) {
+	var timezone string
+	err := conn.QueryRow(ctx, `SELECT timezone FROM races WHERE id = $1`, raceId).Scan(&timezone)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, http.StatusNotFound, ErrRaceNotFound
+	}
+	kcore.Expect(err, "error querying race timezone")
+	return raceLocation(timezone), http.StatusOK, nil
+}
+
+func medicalCertificatesPurgeModel(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) *MedicalCertificatesPurgeModel {
+	var purge MedicalCertificatesPurgeModel
+	var purgedAt *time.Time
+	err := conn.QueryRow(ctx, `
+		SELECT count(*), max(purged_at)
+		FROM medical_certificate_purges
+		WHERE race_id = $1
+		`, raceId).Scan(&purge.Count, &purgedAt)
+	kcore.Expect(err, "error querying medical_certificate_purges")
+	if purgedAt == nil {
+		return nil
+	}
+	purge.PurgedAt = *purgedAt
+	return &purge
+}
+
 type RacePermissionsModel struct {
 	CanUpdateDescription    bool
+	CanPublish              bool
+	CanCancel               bool
+	CanFinish               bool
 	CanOpenForRegistration  bool
+	CanCloseRegistration    bool
 	CanApproveRegistrations bool
+	CanManageCategories     bool
+	CanManageTiming         bool
+	CanManageOrganizers     bool
+	CanViewContactDetails   bool
+	// Rider
+	CanRegister bool
+}
+
+// newRacePermissionsModel maps the organizer role of the current user, which is empty for other users
+func newRacePermissionsModel(role OrganizerRole, status RaceStatus, isOpenForRegistration bool) RacePermissionsModel {
+	isOwner := role == OwnerRole
+	isEditor := isOwner || role == EditorRole
+	isDocumentReviewer := isOwner || role == DocumentReviewerRole
+	return RacePermissionsModel{
+		CanPublish:              isOwner && status == RaceDraft,
+		CanCancel:               isOwner && (status == RaceDraft || status == RacePublished),
+		CanFinish:               isOwner && status == RacePublished,
+		CanOpenForRegistration:  isEditor && status == RacePublished,
+		CanCloseRegistration:    isEditor && isOpenForRegistration,
+		CanApproveRegistrations: isDocumentReviewer,
+		CanUpdateDescription:    isEditor,
+		CanManageCategories:     isEditor,
+		CanManageTiming:         isEditor,
+		CanManageOrganizers:     isOwner,
+		CanViewContactDetails:   role != "",
+	}
+}
+
+type RaceOrganizerModel struct {
+	UserId   kcore.ID
+	Username string
+	Role     OrganizerRole
+}
+
+// raceOrganizerModels lists the organizers, then the pending invitations
+func raceOrganizerModels(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) ([]RaceOrganizerModel, []RaceOrganizerModel) {
+	query := `
+		SELECT users.id, users.username, %[1]s.role
+		FROM %[1]s
+		INNER JOIN users ON users.id = %[1]s.user_id
+		WHERE %[1]s.race_id = $1
+		ORDER BY users.username
+		`
+	rows, err := conn.Query(ctx, fmt.Sprintf(query, "race_organizers"), raceId)
+	kcore.Expect(err, "error querying race_organizers")
+	organizers, err := pgx.CollectRows(rows, pgx.RowToStructByPos[RaceOrganizerModel])
+	kcore.Expect(err, "error scanning race_organizers")
+	rows, err = conn.Query(ctx, fmt.Sprintf(query, "race_organizer_invitations"), raceId)
+	kcore.Expect(err, "error querying race_organizer_invitations")
+	invitations, err := pgx.CollectRows(rows, pgx.RowToStructByPos[RaceOrganizerModel])
+	kcore.Expect(err, "error scanning race_organizer_invitations")
+	return organizers, invitations
+}
+
+type RaceCourseModel struct {
+	File          string
+	Distance      float64
+	ElevationGain float64
+	ElevationLoss float64
+	MaxGradient   float64
+	Drawing       CourseDrawing
+}
+
+type MedicalCertificatesPurgeModel struct {
+	Count    int
+	PurgedAt time.Time
 }
 
 type RaceDetailModel struct {
 	Id                    kcore.ID
 	Name                  string
+	Status                RaceStatus
 	IsOpenForRegistration bool
+	RegistrationOpensAt   time.Time
+	RegistrationClosesAt  time.Time
 	MaximumParticipants   int
 	StartAt               time.Time
+	Timezone              string
+	Location              *time.Location
 	CoverImage            string
-	Permissions           RacePermissionsModel
+	Course                *RaceCourseModel
+	// Only loaded for organizers
+	MedicalCertificatesPurge *MedicalCertificatesPurgeModel
+	Organizers               []RaceOrganizerModel
+	OrganizerInvitations     []RaceOrganizerModel
+	Categories               []RaceCategoryModel
+	Permissions              RacePermissionsModel
+}
+
+func (race RaceDetailModel) IsRegistrationOpen(now time.Time) bool {
+	return isRegistrationOpen(race.IsOpenForRegistration, race.RegistrationOpensAt, race.RegistrationClosesAt, now)
+}
+
+func (race RaceDetailModel) HasAgeLimits() bool {
+	return lo.ContainsBy(race.Categories, RaceCategoryModel.HasAgeLimits)
 }
 
 func RaceDetailQuery(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (RaceDetailModel, int, error) {
-	currentUser, _ := auth.UserFromContext(ctx)
+	currentUser, isLoggedIn := auth.UserFromContext(ctx)
 	var race RaceDetailModel
-	var isCurrentUserOrganizer bool
+	var course RaceCourseModel
+	var currentUserRole OrganizerRole
+	var hasUserRegistered bool
 	err := conn.QueryRow(ctx, `
 		SELECT
-			races.id, races.name, races.maximum_participants, races.is_open_for_registration, races.start_at, coalesce(races.cover_image_id::text, ''),
-			$2::UUID IS NOT NULL AND bool_or(race_organizers.user_id = $2)
+			races.id, races.name, races.status, races.maximum_participants, races.is_open_for_registration, races.start_at, races.timezone, coalesce(races.cover_image_id::text, ''),
+			coalesce(races.registration_opens_at, '0001-01-01T00:00:00Z'), coalesce(races.registration_closes_at, '0001-01-01T00:00:00Z'),
+			coalesce(races.course_file, ''), races.course_distance, races.course_elevation_gain, races.course_elevation_loss, races.course_max_gradient,
+			races.course_profile, races.course_outline,
+			coalesce((SELECT role::text FROM race_organizers WHERE race_organizers.race_id = races.id AND race_organizers.user_id = $2), ''),
+			EXISTS (SELECT 1 FROM race_registrations WHERE race_registrations.race_id = races.id AND race_registrations.user_id = $2 AND race_registrations.status IN ('registered', 'submitted', 'approved', 'waitlisted'))
 		FROM races
-		LEFT JOIN race_organizers ON races.id = race_organizers.race_id 
 		WHERE races.id = $1
-		GROUP BY races.id, races.name
-		`, raceId, currentUser.Id).Scan(&race.Id, &race.Name, &race.MaximumParticipants, &race.IsOpenForRegistration, &race.StartAt, &race.CoverImage, &isCurrentUserOrganizer)
-	race.Permissions = RacePermissionsModel{
-		CanOpenForRegistration:  isCurrentUserOrganizer && race.IsOpenForRegistration,
-		CanApproveRegistrations: isCurrentUserOrganizer,
-		CanUpdateDescription:    isCurrentUserOrganizer,
-	}
+		`, raceId, currentUser.Id).Scan(&race.Id, &race.Name, &race.Status, &race.MaximumParticipants, &race.IsOpenForRegistration, &race.StartAt, &race.Timezone, &race.CoverImage,
+		&race.RegistrationOpensAt, &race.RegistrationClosesAt,
+		&course.File, &course.Distance, &course.ElevationGain, &course.ElevationLoss, &course.MaxGradient,
+		&course.Drawing.Profile, &course.Drawing.Outline, &currentUserRole, &hasUserRegistered)
+	isCurrentUserOrganizer := currentUserRole != ""
+	race.Permissions = newRacePermissionsModel(currentUserRole, race.Status, race.IsOpenForRegistration)
+	race.Permissions.CanRegister = isLoggedIn && race.IsRegistrationOpen(time.Now()) && !hasUserRegistered
 	if errors.Is(err, pgx.ErrNoRows) {
 		return race, http.StatusNotFound, ErrRaceNotFound
 	}
 	kcore.Expect(err, "error querying race")
+	if race.Status == RaceDraft && !isCurrentUserOrganizer {
+		return race, http.StatusNotFound, ErrRaceNotFound
+	}
+	race.Location = raceLocation(race.Timezone)
+	if course.File != "" {
+		race.Course = &course
+	}
+	race.Categories = raceCategoryModels(ctx, conn, []kcore.ID{raceId})[raceId]
+	if isCurrentUserOrganizer {
+		race.MedicalCertificatesPurge = medicalCertificatesPurgeModel(ctx, conn, raceId)
+		race.Organizers, race.OrganizerInvitations = raceOrganizerModels(ctx, conn, raceId)
+	}
 
 	return race, http.StatusOK, nil
 }
 
 type RaceRegistrationPermissionsModel struct {
+	// Organizer
 	CanApprove                   bool
 	CanApproveMedicalCertificate bool
+	CanReject                    bool
+	CanCancel                    bool
+	CanAssignBib                 bool
+	// Organizer or rider
+	CanViewMedicalCertificate bool
+	CanViewContactDetails     bool
+	// Rider
+	CanSubmit   bool
+	CanWithdraw bool
 }
 
 type RaceRegistrationModel struct {
 	User struct {
 		Id       kcore.ID
 		Username string
+		// Contact details are only filled for organizers and the rider themself
+		Email           string
+		IsEmailVerified bool
+		ContactDetails  auth.ContactDetails
 	}
+	Category                     string
+	Bib                          int
 	Status                       RaceRegistrationStatus
+	WaitlistPosition             int
+	RejectionReason              string
 	RegisteredAt                 time.Time
 	MedicalCertificate           *string
+	MedicalCertificateUrl        string
 	IsMedicalCertificateApproved bool
 	Permissions                  RaceRegistrationPermissionsModel
 }
 
 func RaceRegistrationsQuery(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, racePermissions RacePermissionsModel) ([]RaceRegistrationModel, int, error) {
+	currentUser, isLoggedIn := auth.UserFromContext(ctx)
 	var registrations []RaceRegistrationModel
 	rows, err := conn.Query(ctx, `
 		SELECT
 			race_registrations.user_id,
+			coalesce(race_categories.name, ''),
+			coalesce(race_registrations.bib, 0),
 			race_registrations.status,
+			CASE WHEN race_registrations.status = 'waitlisted'
+				THEN row_number() OVER (PARTITION BY race_registrations.status, race_registrations.category_id ORDER BY race_registrations.registered_at)
+				ELSE 0
+			END,
+			coalesce(race_registrations.rejection_reason, ''),
 			race_registrations.registered_at,
 			race_registrations.medical_certificate,
 			race_registrations.is_medical_certificate_approved,
-			users.username
+			users.username,
+			coalesce(users.email, ''),
+			users.email_verified_at IS NOT NULL,
+			users.full_name,
+			users.birth_date,
+			users.gender,
+			users.phone
 		FROM race_registrations
 		LEFT JOIN users ON users.id = race_registrations.user_id
+		LEFT JOIN race_categories ON race_categories.id = race_registrations.category_id
 		WHERE race_registrations.race_id = $1
 		ORDER BY race_registrations.registered_at ASC
 		`, raceId)
@@ -146,23 +447,88 @@ func RaceRegistrationsQuery(ctx context.Context, conn *pgxpool.Pool, raceId kcor
 
 	for rows.Next() {
 		var registration RaceRegistrationModel
-		kcore.Expect(rows.Scan(&registration.User.Id, &registration.Status, &registration.RegisteredAt, &registration.MedicalCertificate, &registration.IsMedicalCertificateApproved, &registration.User.Username), "error scanning race_registrations")
+		var email string
+		var isEmailVerified bool
+		var contactDetails auth.ContactDetails
+		var birthDate *time.Time
+		var rejectionReason string
+		kcore.Expect(rows.Scan(&registration.User.Id, &registration.Category, &registration.Bib, &registration.Status, &registration.WaitlistPosition, &rejectionReason, &registration.RegisteredAt, &registration.MedicalCertificate, &registration.IsMedicalCertificateApproved, &registration.User.Username,
+			&email, &isEmailVerified, &contactDetails.FullName, &birthDate, &contactDetails.Gender, &contactDetails.Phone), "error scanning race_registrations")
+		isCurrentUser := isLoggedIn && registration.User.Id == currentUser.Id
+		// The reason is often medical or about eligibility, it is not public
+		if racePermissions.CanApproveRegistrations || isCurrentUser {
+			registration.RejectionReason = rejectionReason
+		}
+		if racePermissions.CanViewContactDetails || isCurrentUser {
+			if birthDate != nil {
+				contactDetails.BirthDate = *birthDate
+			}
+			registration.User.Email = email
+			registration.User.IsEmailVerified = isEmailVerified
+			registration.User.ContactDetails = contactDetails
+		}
 		registration.Permissions = RaceRegistrationPermissionsModel{
-			CanApprove:                   racePermissions.CanApproveRegistrations && registration.Status == Registered && registration.IsMedicalCertificateApproved,
-			CanApproveMedicalCertificate: racePermissions.CanApproveRegistrations && registration.Status == Registered && registration.MedicalCertificate != nil && !registration.IsMedicalCertificateApproved,
+			CanApprove:                   racePermissions.CanApproveRegistrations && registration.Status == Submitted && registration.IsMedicalCertificateApproved,
+			CanApproveMedicalCertificate: racePermissions.CanApproveRegistrations && registration.Status == Submitted && registration.MedicalCertificate != nil && !registration.IsMedicalCertificateApproved,
+			CanReject:                    racePermissions.CanApproveRegistrations && registration.Status == Submitted,
+			CanCancel:                    racePermissions.CanApproveRegistrations && registration.Status.IsActive(),
+			CanAssignBib:                 racePermissions.CanApproveRegistrations && registration.Status == Approved,
+			CanViewMedicalCertificate:    (racePermissions.CanApproveRegistrations || isCurrentUser) && registration.MedicalCertificate != nil,
+			CanViewContactDetails:        racePermissions.CanViewContactDetails || isCurrentUser,
+			CanSubmit:                    isCurrentUser && registration.Status == Registered && registration.MedicalCertificate != nil,
+			CanWithdraw:                  isCurrentUser && registration.Status.IsActive(),
 		}
 		registrations = append(registrations, registration)
 	}
 	return registrations, http.StatusOK, nil
 }
 
+// MedicalCertificateQuery returns the certificate file, if the current user is the rider or an organizer of the race
+func MedicalCertificateQuery(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, userId kcore.ID) (kcore.File, int, error) {
+	currentUser, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return "", http.StatusUnauthorized, kauth.ErrUserNotLoggedIn
+	}
+	var medicalCertificate *kcore.File
+	var status RaceStatus
+	var currentUserRole OrganizerRole
+	err := conn.QueryRow(ctx, `
+		SELECT
+			race_registrations.medical_certificate, races.status,
+			coalesce((SELECT role::text FROM race_organizers WHERE race_organizers.race_id = $1 AND race_organizers.user_id = $3), '')
+		FROM race_registrations
+		INNER JOIN races ON races.id = race_registrations.race_id
+		WHERE race_registrations.race_id = $1 AND race_registrations.user_id = $2
+		`, raceId, userId, currentUser.Id).Scan(&medicalCertificate, &status, &currentUserRole)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", http.StatusNotFound, ErrUserNotRegistered
+	}
+	kcore.Expect(err, "error querying race_registrations")
+	if status == RaceDraft && currentUserRole == "" {
+		return "", http.StatusNotFound, ErrRaceNotFound
+	}
+	isCurrentUserDocumentReviewer := currentUserRole == OwnerRole || currentUserRole == DocumentReviewerRole
+	if currentUser.Id != userId && !isCurrentUserDocumentReviewer {
+		return "", http.StatusForbidden, ErrMedicalCertificateNotAllowed
+	}
+	if medicalCertificate == nil {
+		return "", http.StatusNotFound, ErrMedicalCertificateMissing
+	}
+	return *medicalCertificate, http.StatusOK, nil
+}
+
 type UserRegistrationModelPermissions struct {
 	CanUploadMedicalCertificate bool
+	CanSubmit                   bool
+	CanWithdraw                 bool
 }
 
 type UserRegistrationModel struct {
-	Status RaceRegistrationStatus
-	Race   struct {
+	Status           RaceRegistrationStatus
+	WaitlistPosition int
+	RejectionReason  string
+	Category         string
+	Race             struct {
 		Id   kcore.ID
 		Name string
 	}
@@ -177,11 +543,17 @@ func CurrentUserRegistrationsQuery(ctx context.Context, conn *pgxpool.Pool) ([]U
 	registrations := []UserRegistrationModel{}
 	rows, err := conn.Query(ctx, `
 		SELECT
-			race_registrations.race_id, race_registrations.status, race_registrations.medical_certificate,
-			races.name
+			race_registrations.race_id, race_registrations.status, coalesce(waitlist.position, 0), coalesce(race_registrations.rejection_reason, ''), race_registrations.medical_certificate,
+			races.name, coalesce(race_categories.name, '')
 		FROM
 			race_registrations
 			INNER JOIN races ON race_registrations.race_id = races.id
+			LEFT JOIN race_categories ON race_categories.id = race_registrations.category_id
+			LEFT JOIN (
+				SELECT race_id, user_id, row_number() OVER (PARTITION BY race_id, category_id ORDER BY registered_at) AS position
+				FROM race_registrations
+				WHERE status = 'waitlisted'
+			) AS waitlist ON waitlist.race_id = race_registrations.race_id AND waitlist.user_id = race_registrations.user_id
 		WHERE
 			race_registrations.user_id = $1
 			`, currentUser.Id)
@@ -190,11 +562,103 @@ func CurrentUserRegistrationsQuery(ctx context.Context, conn *pgxpool.Pool) ([]U
 	for rows.Next() {
 		var registration UserRegistrationModel
 		var medicalCertificate *kcore.File
-		kcore.Expect(rows.Scan(&registration.Race.Id, &registration.Status, &medicalCertificate, &registration.Race.Name), "error scanning race_registrations")
+		kcore.Expect(rows.Scan(&registration.Race.Id, &registration.Status, &registration.WaitlistPosition, &registration.RejectionReason, &medicalCertificate, &registration.Race.Name, &registration.Category), "error scanning race_registrations")
 		registration.Permissions = UserRegistrationModelPermissions{
 			CanUploadMedicalCertificate: registration.Status == Registered && medicalCertificate == nil,
+			CanSubmit:                   registration.Status == Registered && medicalCertificate != nil,
+			CanWithdraw:                 registration.Status.IsActive(),
 		}
 		registrations = append(registrations, registration)
 	}
 	return registrations, http.StatusOK, nil
 }
+
+type RaceResultModel struct {
+	Username     string
+	Category     string
+	Status       RaceResultStatus
+	FinishTime   time.Duration
+	Rank         int
+	CategoryRank int
+}
+
+type RaceResultsCategoryModel struct {
+	Name    string
+	Results []RaceResultModel
+}
+
+type RaceResultsPermissionsModel struct {
+	CanImport bool
+}
+
+type RaceResultsModel struct {
+	Race struct {
+		Id   kcore.ID
+		Name string
+	}
+	Overall     []RaceResultModel
+	Categories  []RaceResultsCategoryModel
+	Permissions RaceResultsPermissionsModel
+}
+
+func RaceResultsQuery(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (RaceResultsModel, int, error) {
+	currentUser, _ := auth.UserFromContext(ctx)
+	var results RaceResultsModel
+	var status RaceStatus
+	var currentUserRole OrganizerRole
+	err := conn.QueryRow(ctx, `
+		SELECT
+			races.id, races.name, races.status,
+			coalesce((SELECT role::text FROM race_organizers WHERE race_organizers.race_id = races.id AND race_organizers.user_id = $2), '')
+		FROM races
+		WHERE races.id = $1
+		`, raceId, currentUser.Id).Scan(&results.Race.Id, &results.Race.Name, &status, &currentUserRole)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return results, http.StatusNotFound, ErrRaceNotFound
+	}
+	kcore.Expect(err, "error querying race")
+	if status == RaceDraft && currentUserRole == "" {
+		return RaceResultsModel{}, http.StatusNotFound, ErrRaceNotFound
+	}
+	results.Permissions = RaceResultsPermissionsModel{
+		CanImport: currentUserRole == OwnerRole || currentUserRole == EditorRole,
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			users.username, coalesce(race_categories.name, ''), race_results.status,
+			coalesce(race_results.finish_time_ms, 0), coalesce(race_results.rank, 0),
+			CASE WHEN race_results.status = 'finished'
+				THEN rank() OVER (PARTITION BY race_registrations.category_id, race_results.status ORDER BY race_results.finish_time_ms)
+				ELSE 0
+			END
+		FROM race_results
+		INNER JOIN race_registrations ON race_registrations.race_id = race_results.race_id AND race_registrations.user_id = race_results.user_id
+		INNER JOIN users ON users.id = race_results.user_id
+		LEFT JOIN race_categories ON race_categories.id = race_registrations.category_id
+		WHERE race_results.race_id = $1
+		ORDER BY race_results.rank ASC NULLS LAST, race_results.status, users.username
+		`, raceId)
+	kcore.Expect(err, "error querying race_results")
+	defer rows.Close()
+
+	categoryIndexes := map[string]int{}
+	for rows.Next() {
+		var result RaceResultModel
+		var finishTimeMs int64
+		kcore.Expect(rows.Scan(&result.Username, &result.Category, &result.Status, &finishTimeMs, &result.Rank, &result.CategoryRank), "error scanning race_results")
+		result.FinishTime = time.Duration(finishTimeMs) * time.Millisecond
+		results.Overall = append(results.Overall, result)
+		if result.Category == "" {
+			continue
+		}
+		index, ok := categoryIndexes[result.Category]
+		if !ok {
+			index = len(results.Categories)
+			categoryIndexes[result.Category] = index
+			results.Categories = append(results.Categories, RaceResultsCategoryModel{Name: result.Category})
+		}
+		results.Categories[index].Results = append(results.Categories[index].Results, result)
+	}
+	return results, http.StatusOK, nil
+}
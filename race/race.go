@@ -3,6 +3,7 @@ package race
 import (
 	"bike_race/auth"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/martinlehoux/kagamigo/kcore"
@@ -16,22 +17,54 @@ var (
 	ErrRegistrationsClosed                        = errors.New("registrations are closed")
 	ErrMedicalCertificateNotApproved              = errors.New("medical certificate is not approved")
 	ErrMedicalCertificateMissing                  = errors.New("medical certificate is missing")
+	ErrBirthDateRequired                          = errors.New("a date of birth is required in the profile for categories with age limits")
+	ErrRejectionReasonMissing                     = errors.New("rejection reason is missing")
+	ErrResultRegistrationNotApproved              = errors.New("results can only be recorded for approved registrations")
 	ErrMaximumParticipantsMinimumOne              = errors.New("maximum participants must be at least 1")
 	ErrMaximumParticipantsLessThanRegisteredUsers = errors.New("maximum participants cannot be less than current number of registered users")
 	ErrRaceNameTooShort                           = errors.New("name must be at least 3 characters")
+	ErrBibInvalid                                 = errors.New("bib must be at least 1")
+	ErrBibTaken                                   = errors.New("bib is already assigned to another rider")
+	ErrBibRangeFull                               = errors.New("no bib left in the category range")
+	ErrBibOutsideCategoryRange                    = errors.New("bib is outside the range of the rider category")
+	ErrBibReserved                                = errors.New("bib is in the range of another category")
+	ErrBibRegistrationNotApproved                 = errors.New("bibs can only be assigned to approved registrations")
+	ErrRaceStartMissing                           = errors.New("race start is missing")
+	ErrRaceStartInPast                            = errors.New("race start cannot be moved to the past")
+	ErrRaceTimezoneInvalid                        = errors.New("timezone must be an IANA name like Europe/Paris")
+	ErrRegistrationWindowInvalid                  = errors.New("registrations must close after they open")
+	ErrRegistrationClosesAfterStart               = errors.New("registrations must close before the race start")
+	ErrRegistrationClosesInPast                   = errors.New("registrations cannot close in the past")
+	ErrRegistrationsAlreadyClosed                 = errors.New("registrations are already closed")
 )
 
 type Race struct {
-	Id         kcore.ID
-	Name       string
-	Organizers []kcore.ID
-	StartAt    time.Time
+	Id       kcore.ID
+	Name     string
+	Status   RaceStatus
+	StartAt  time.Time
+	Timezone string
+	// Organization
+	Organizers           []RaceOrganizer
+	OrganizerInvitations map[kcore.ID]OrganizerRole
 	// Description
-	CoverImage *kcore.Image
+	CoverImage  *kcore.Image
+	Course      *kcore.File
+	CourseStats CourseStats
 	// Registration
 	IsOpenForRegistration bool
+	RegistrationOpensAt   time.Time
+	RegistrationClosesAt  time.Time
 	MaximumParticipants   int
+	Categories            []RaceCategory
 	Registrations         map[kcore.ID]RaceRegistration
+	// Timing
+	TimingTokenVersion int
+	// Persistence
+	version       int
+	notifications []Notification
+	// resultsChanged avoids rewriting all the results of the race on every save
+	resultsChanged bool
 }
 
 func NewRace(name string) (Race, error) {
@@ -41,38 +74,252 @@ func NewRace(name string) (Race, error) {
 	return Race{
 		Id:                    kcore.NewID(),
 		Name:                  name,
-		Organizers:            []kcore.ID{},
+		Organizers:            []RaceOrganizer{},
+		OrganizerInvitations:  map[kcore.ID]OrganizerRole{},
+		Status:                RaceDraft,
+		Timezone:              DefaultTimezone,
 		IsOpenForRegistration: false,
+		Categories:            []RaceCategory{},
 		Registrations:         map[kcore.ID]RaceRegistration{},
 	}, nil
 }
 
+// DefaultTimezone is the timezone of races organized before each race had its own
+const DefaultTimezone = "Europe/Paris"
+
+// LoadRaceLocation refuses the server local time, that would depend on where the server runs
+func LoadRaceLocation(timezone string) (*time.Location, error) {
+	if timezone == "" || timezone == "Local" {
+		return nil, ErrRaceTimezoneInvalid
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, kcore.Wrap(ErrRaceTimezoneInvalid, err.Error())
+	}
+	return location, nil
+}
+
+// Schedule only checks that the start is in the future when it changes, so that a started race can still be updated
+func (race *Race) Schedule(startAt time.Time, timezone string, now time.Time) error {
+	if startAt.IsZero() {
+		return ErrRaceStartMissing
+	}
+	_, err := LoadRaceLocation(timezone)
+	if err != nil {
+		return err
+	}
+	if !startAt.Equal(race.StartAt) && startAt.Before(now) {
+		return ErrRaceStartInPast
+	}
+	race.StartAt = startAt
+	race.Timezone = timezone
+	return nil
+}
+
+// AddOrganizer is used for the creator of the race, other organizers are invited
 func (race *Race) AddOrganizer(user auth.User) error {
-	race.Organizers = append(race.Organizers, user.Id)
+	race.Organizers = append(race.Organizers, RaceOrganizer{UserId: user.Id, Role: OwnerRole})
 	return nil
 }
 
-func (race *Race) Register(user auth.User) error {
-	_, ok := race.Registrations[user.Id]
-	if ok {
+// Register needs a category when the race has some, the birth date of the profile is only used for categories with age limits.
+// Riders can register again after they withdrew, or their registration was rejected or cancelled.
+func (race *Race) Register(user auth.User, categoryId *kcore.ID, now time.Time) error {
+	previous, ok := race.Registrations[user.Id]
+	if ok && previous.Status.IsActive() {
 		return ErrUserAlreadyRegistered
 	}
-	if !race.IsOpenForRegistration {
+	if !race.IsRegistrationOpen(now) {
 		return ErrRegistrationsClosed
 	}
-	race.Registrations[user.Id] = NewRaceRegistration(user.Id)
+	registration := NewRaceRegistration(user.Id)
+	// The medical certificate is kept so that its file is not lost, it has to be approved again
+	registration.MedicalCertificate = previous.MedicalCertificate
+	if len(race.Categories) > 0 {
+		category, err := race.eligibleCategory(categoryId, user.ContactDetails.BirthDate)
+		if err != nil {
+			return err
+		}
+		registration.CategoryId = &category.Id
+	}
+	if !race.hasSpotFor(registration) {
+		registration.Status = Waitlisted
+	}
+	race.Registrations[user.Id] = registration
+	return nil
+}
+
+func (race Race) eligibleCategory(categoryId *kcore.ID, birthDate time.Time) (RaceCategory, error) {
+	if categoryId == nil {
+		return RaceCategory{}, ErrRaceCategoryRequired
+	}
+	category, ok := race.Category(*categoryId)
+	if !ok {
+		return RaceCategory{}, ErrRaceCategoryNotFound
+	}
+	if category.HasAgeLimits() && birthDate.IsZero() {
+		return RaceCategory{}, ErrBirthDateRequired
+	}
+	if !category.IsEligible(birthDate) {
+		return RaceCategory{}, ErrRaceCategoryNotEligible
+	}
+	return category, nil
+}
+
+func (race Race) hasSpotFor(registration RaceRegistration) bool {
+	if race.IsFull() {
+		return false
+	}
+	if registration.CategoryId == nil {
+		return true
+	}
+	category, ok := race.Category(*registration.CategoryId)
+	return ok && race.CategoryParticipantsCount(category.Id) < category.MaximumParticipants
+}
+
+func (race Race) Category(categoryId kcore.ID) (RaceCategory, bool) {
+	return lo.Find(race.Categories, func(category RaceCategory) bool { return category.Id == categoryId })
+}
+
+func (race Race) CategoryParticipantsCount(categoryId kcore.ID) int {
+	return lo.CountBy(lo.Values(race.Registrations), func(registration RaceRegistration) bool {
+		return registration.Status.IsParticipant() && registration.CategoryId != nil && *registration.CategoryId == categoryId
+	})
+}
+
+func (race *Race) AddCategory(category RaceCategory) error {
+	if lo.ContainsBy(race.Categories, func(other RaceCategory) bool { return other.Name == category.Name }) {
+		return ErrRaceCategoryNameTaken
+	}
+	if lo.ContainsBy(race.Categories, func(other RaceCategory) bool { return other.Bibs.Overlaps(category.Bibs) }) {
+		return ErrRaceCategoryBibRangeOverlaps
+	}
+	race.Categories = append(race.Categories, category)
+	return nil
+}
+
+func (race *Race) UpdateCategory(categoryId kcore.ID, name string, startAt time.Time, maximumParticipants int, minimumAge int, maximumAge int, bibs BibRange) error {
+	_, index, ok := lo.FindIndexOf(race.Categories, func(category RaceCategory) bool { return category.Id == categoryId })
+	if !ok {
+		return ErrRaceCategoryNotFound
+	}
+	if lo.ContainsBy(race.Categories, func(other RaceCategory) bool { return other.Name == name && other.Id != categoryId }) {
+		return ErrRaceCategoryNameTaken
+	}
+	if lo.ContainsBy(race.Categories, func(other RaceCategory) bool { return other.Bibs.Overlaps(bibs) && other.Id != categoryId }) {
+		return ErrRaceCategoryBibRangeOverlaps
+	}
+	if race.CategoryParticipantsCount(categoryId) > maximumParticipants {
+		return ErrMaximumParticipantsLessThanRegisteredUsers
+	}
+	err := race.Categories[index].Update(name, startAt, maximumParticipants, minimumAge, maximumAge, bibs)
+	if err != nil {
+		return err
+	}
+	race.promoteWaitlisted()
+	return nil
+}
+
+func (race *Race) RemoveCategory(categoryId kcore.ID) error {
+	if _, ok := race.Category(categoryId); !ok {
+		return ErrRaceCategoryNotFound
+	}
+	if lo.ContainsBy(lo.Values(race.Registrations), func(registration RaceRegistration) bool {
+		return registration.CategoryId != nil && *registration.CategoryId == categoryId
+	}) {
+		return ErrRaceCategoryHasRegistrations
+	}
+	race.Categories = lo.Filter(race.Categories, func(category RaceCategory, _ int) bool { return category.Id != categoryId })
+	return nil
+}
+
+func (race Race) ParticipantsCount() int {
+	return lo.CountBy(lo.Values(race.Registrations), func(registration RaceRegistration) bool {
+		return registration.Status.IsParticipant()
+	})
+}
+
+func (race Race) IsFull() bool {
+	return race.ParticipantsCount() >= race.MaximumParticipants
+}
+
+// promoteWaitlisted moves waitlisted registrations to registered, by order of registration, while there are free spots
+func (race *Race) promoteWaitlisted() {
+	waitlisted := lo.Filter(lo.Values(race.Registrations), func(registration RaceRegistration, _ int) bool {
+		return registration.Status == Waitlisted
+	})
+	sort.Slice(waitlisted, func(i, j int) bool { return waitlisted[i].RegisteredAt.Before(waitlisted[j].RegisteredAt) })
+	for _, registration := range waitlisted {
+		if race.IsFull() {
+			return
+		}
+		if race.hasSpotFor(registration) {
+			registration.Status = Registered
+			race.Registrations[registration.UserId] = registration
+		}
+	}
+}
+
+func (race Race) IsRegistrationOpen(now time.Time) bool {
+	return isRegistrationOpen(race.IsOpenForRegistration, race.RegistrationOpensAt, race.RegistrationClosesAt, now)
+}
+
+// isRegistrationOpen treats a zero opening or closing time as no limit on that side
+func isRegistrationOpen(isOpenForRegistration bool, opensAt time.Time, closesAt time.Time, now time.Time) bool {
+	if !isOpenForRegistration {
+		return false
+	}
+	if !opensAt.IsZero() && now.Before(opensAt) {
+		return false
+	}
+	return closesAt.IsZero() || now.Before(closesAt)
+}
+
+func checkRegistrationWindow(opensAt time.Time, closesAt time.Time, startAt time.Time, now time.Time) error {
+	if closesAt.IsZero() {
+		return nil
+	}
+	if !opensAt.IsZero() && !closesAt.After(opensAt) {
+		return ErrRegistrationWindowInvalid
+	}
+	if !startAt.IsZero() && closesAt.After(startAt) {
+		return ErrRegistrationClosesAfterStart
+	}
+	if !closesAt.After(now) {
+		return ErrRegistrationClosesInPast
+	}
 	return nil
 }
 
-func (race *Race) OpenForRegistration(maximumParticipants int) error {
+// OpenForRegistration also reopens a closed race, or updates the window of an open one
+func (race *Race) OpenForRegistration(maximumParticipants int, opensAt time.Time, closesAt time.Time, now time.Time) error {
+	if race.Status != RacePublished {
+		return ErrRaceNotPublished
+	}
 	if maximumParticipants <= 0 {
 		return ErrMaximumParticipantsMinimumOne
 	}
-	if len(race.Registrations) > maximumParticipants {
+	if race.ParticipantsCount() > maximumParticipants {
 		return ErrMaximumParticipantsLessThanRegisteredUsers
 	}
+	err := checkRegistrationWindow(opensAt, closesAt, race.StartAt, now)
+	if err != nil {
+		return err
+	}
 	race.MaximumParticipants = maximumParticipants
+	race.RegistrationOpensAt = opensAt
+	race.RegistrationClosesAt = closesAt
 	race.IsOpenForRegistration = true
+	race.promoteWaitlisted()
+	return nil
+}
+
+// CloseRegistration keeps the registrations, organizers can still approve them
+func (race *Race) CloseRegistration() error {
+	if !race.IsOpenForRegistration {
+		return ErrRegistrationsAlreadyClosed
+	}
+	race.IsOpenForRegistration = false
 	return nil
 }
 
@@ -94,18 +341,88 @@ func (race *Race) ApproveRegistration(userId kcore.ID) error {
 	if !ok {
 		return ErrUserNotRegistered
 	}
-	if registration.Status != Registered {
+	if registration.Status != Submitted {
 		return ErrRegistrationWrongStatus
 	}
 	if !registration.IsMedicalCertificateApproved {
 		return ErrMedicalCertificateNotApproved
 	}
+	if registration.Bib == 0 {
+		bib, err := race.nextBib(registration)
+		if err != nil {
+			return err
+		}
+		registration.Bib = bib
+	}
 	registration.Status = Approved
 	race.Registrations[userId] = registration
 	return nil
 }
 
-func (race *Race) UploadMedicalCertificate(userId kcore.ID, medicalCertificate kcore.File) error {
+func (race Race) isBibTaken(bib int, userId kcore.ID) bool {
+	return lo.ContainsBy(lo.Values(race.Registrations), func(registration RaceRegistration) bool {
+		return registration.Bib == bib && registration.UserId != userId
+	})
+}
+
+func (race Race) isBibReserved(bib int) bool {
+	return lo.ContainsBy(race.Categories, func(category RaceCategory) bool { return category.Bibs.Contains(bib) })
+}
+
+// categoryBibs is the zero range when the registration has no category, or a category without range
+func (race Race) categoryBibs(registration RaceRegistration) BibRange {
+	if registration.CategoryId == nil {
+		return BibRange{}
+	}
+	category, _ := race.Category(*registration.CategoryId)
+	return category.Bibs
+}
+
+// nextBib picks the lowest free bib, in the range of the category if it has one, otherwise outside of all category ranges
+func (race Race) nextBib(registration RaceRegistration) (int, error) {
+	if bibs := race.categoryBibs(registration); bibs.IsSet() {
+		for bib := bibs.First; bib <= bibs.Last; bib++ {
+			if !race.isBibTaken(bib, registration.UserId) {
+				return bib, nil
+			}
+		}
+		return 0, ErrBibRangeFull
+	}
+	bib := 1
+	for race.isBibTaken(bib, registration.UserId) || race.isBibReserved(bib) {
+		bib++
+	}
+	return bib, nil
+}
+
+// AssignBib lets organizers override the bib assigned on approval
+func (race *Race) AssignBib(userId kcore.ID, bib int) error {
+	registration, ok := race.Registrations[userId]
+	if !ok {
+		return ErrUserNotRegistered
+	}
+	if registration.Status != Approved {
+		return ErrBibRegistrationNotApproved
+	}
+	if bib < 1 {
+		return ErrBibInvalid
+	}
+	// Bibs follow the same ranges as on approval, so that a rider can be told apart by their bib
+	bibs := race.categoryBibs(registration)
+	if bibs.IsSet() && !bibs.Contains(bib) {
+		return ErrBibOutsideCategoryRange
+	} else if !bibs.IsSet() && race.isBibReserved(bib) {
+		return ErrBibReserved
+	}
+	if race.isBibTaken(bib, userId) {
+		return ErrBibTaken
+	}
+	registration.Bib = bib
+	race.Registrations[userId] = registration
+	return nil
+}
+
+func (race *Race) SubmitRegistration(userId kcore.ID) error {
 	registration, ok := race.Registrations[userId]
 	if !ok {
 		return ErrUserNotRegistered
@@ -113,20 +430,103 @@ func (race *Race) UploadMedicalCertificate(userId kcore.ID, medicalCertificate k
 	if registration.Status != Registered {
 		return ErrRegistrationWrongStatus
 	}
+	if registration.MedicalCertificate == nil {
+		return ErrMedicalCertificateMissing
+	}
+	registration.Status = Submitted
+	race.Registrations[userId] = registration
+	return nil
+}
+
+func (race *Race) RejectRegistration(userId kcore.ID, reason string) error {
+	registration, ok := race.Registrations[userId]
+	if !ok {
+		return ErrUserNotRegistered
+	}
+	if registration.Status != Submitted {
+		return ErrRegistrationWrongStatus
+	}
+	if reason == "" {
+		return ErrRejectionReasonMissing
+	}
+	registration.Status = Rejected
+	registration.RejectionReason = reason
+	race.Registrations[userId] = registration
+	race.promoteWaitlisted()
+	return nil
+}
 
-	if registration.MedicalCertificate != nil {
-		err := registration.MedicalCertificate.Delete()
-		if err != nil {
-			return kcore.Wrap(err, "error deleting old medical certificate")
-		}
+// WithdrawRegistration is done by the rider, while CancelRegistration is done by an organizer
+func (race *Race) WithdrawRegistration(userId kcore.ID) error {
+	return race.endRegistration(userId, Withdrawn)
+}
+
+func (race *Race) CancelRegistration(userId kcore.ID) error {
+	return race.endRegistration(userId, Cancelled)
+}
+
+func (race *Race) endRegistration(userId kcore.ID, status RaceRegistrationStatus) error {
+	registration, ok := race.Registrations[userId]
+	if !ok {
+		return ErrUserNotRegistered
+	}
+	if !registration.Status.IsActive() {
+		return ErrRegistrationWrongStatus
 	}
+	registration.Status = status
+	// The bib can be handed to another rider
+	registration.Bib = 0
+	race.Registrations[userId] = registration
+	race.promoteWaitlisted()
+	return nil
+}
 
+// UploadMedicalCertificate returns the replaced certificate, it must only be deleted once the race is saved
+func (race *Race) UploadMedicalCertificate(userId kcore.ID, medicalCertificate kcore.File) (*kcore.File, error) {
+	registration, ok := race.Registrations[userId]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+	if registration.Status != Registered {
+		return nil, ErrRegistrationWrongStatus
+	}
+
+	previous := registration.MedicalCertificate
 	registration.MedicalCertificate = &medicalCertificate
 	registration.IsMedicalCertificateApproved = false
 	race.Registrations[userId] = registration
+	return previous, nil
+}
+
+// ImportResults replaces all the results of the race, and ranks them
+func (race *Race) ImportResults(results map[kcore.ID]RaceResult) error {
+	for userId := range results {
+		registration, ok := race.Registrations[userId]
+		if !ok {
+			return ErrUserNotRegistered
+		}
+		if registration.Status != Approved {
+			return ErrResultRegistrationNotApproved
+		}
+	}
+	ranked := []*RaceResult{}
+	for userId, registration := range race.Registrations {
+		registration.Result = nil
+		if result, ok := results[userId]; ok {
+			registration.Result = &result
+			ranked = append(ranked, registration.Result)
+		}
+		race.Registrations[userId] = registration
+	}
+	rankResults(ranked)
+	race.resultsChanged = true
 	return nil
 }
 
-func (race Race) IsOrganizer(user auth.User) bool {
-	return lo.ContainsBy(race.Organizers, func(id kcore.ID) bool { return id == user.Id })
+// ClearCourse returns the removed file, it must only be deleted once the race is saved
+func (race *Race) ClearCourse() *kcore.File {
+	previous := race.Course
+	race.Course = nil
+	race.CourseStats = CourseStats{}
+	return previous
 }
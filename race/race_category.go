@@ -0,0 +1,98 @@
+package race
+
+import (
+	"errors"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrRaceCategoryNameTooShort     = errors.New("category name must be at least 2 characters")
+	ErrRaceCategoryAgeRangeInvalid  = errors.New("category minimum age must be less than maximum age")
+	ErrRaceCategoryNotFound         = errors.New("category not found")
+	ErrRaceCategoryRequired         = errors.New("a category must be chosen")
+	ErrRaceCategoryNameTaken        = errors.New("category name is already used in this race")
+	ErrRaceCategoryHasRegistrations = errors.New("category still has registrations")
+	ErrRaceCategoryNotEligible      = errors.New("user is not eligible for this category")
+	ErrRaceCategoryBibRangeInvalid  = errors.New("category first bib must be less than last bib")
+	ErrRaceCategoryBibRangeOverlaps = errors.New("category bib range overlaps another category")
+)
+
+// BibRange is inclusive, the zero value means bibs are assigned sequentially across the race
+type BibRange struct {
+	First int
+	Last  int
+}
+
+func (bibs BibRange) IsSet() bool {
+	return bibs.First > 0
+}
+
+func (bibs BibRange) Contains(bib int) bool {
+	return bibs.IsSet() && bib >= bibs.First && bib <= bibs.Last
+}
+
+func (bibs BibRange) Overlaps(other BibRange) bool {
+	return bibs.IsSet() && other.IsSet() && bibs.First <= other.Last && other.First <= bibs.Last
+}
+
+type RaceCategory struct {
+	Id                  kcore.ID
+	Name                string
+	StartAt             time.Time
+	MaximumParticipants int
+	// Eligibility, 0 means no limit
+	MinimumAge int
+	MaximumAge int
+	// Numbering
+	Bibs BibRange
+}
+
+func NewRaceCategory(name string, startAt time.Time, maximumParticipants int, minimumAge int, maximumAge int, bibs BibRange) (RaceCategory, error) {
+	category := RaceCategory{Id: kcore.NewID()}
+	err := category.Update(name, startAt, maximumParticipants, minimumAge, maximumAge, bibs)
+	return category, err
+}
+
+func (category *RaceCategory) Update(name string, startAt time.Time, maximumParticipants int, minimumAge int, maximumAge int, bibs BibRange) error {
+	if len(name) < 2 {
+		return ErrRaceCategoryNameTooShort
+	}
+	if maximumParticipants <= 0 {
+		return ErrMaximumParticipantsMinimumOne
+	}
+	if minimumAge < 0 || maximumAge < 0 || (maximumAge > 0 && minimumAge > maximumAge) {
+		return ErrRaceCategoryAgeRangeInvalid
+	}
+	if bibs.First < 0 || (bibs.IsSet() && bibs.First > bibs.Last) {
+		return ErrRaceCategoryBibRangeInvalid
+	}
+	category.Name = name
+	category.StartAt = startAt
+	category.MaximumParticipants = maximumParticipants
+	category.MinimumAge = minimumAge
+	category.MaximumAge = maximumAge
+	category.Bibs = bibs
+	return nil
+}
+
+func (category RaceCategory) HasAgeLimits() bool {
+	return category.MinimumAge > 0 || category.MaximumAge > 0
+}
+
+// IsEligible uses the age reached during the season of the category, as cycling federations do
+func (category RaceCategory) IsEligible(birthDate time.Time) bool {
+	if !category.HasAgeLimits() {
+		return true
+	}
+	if birthDate.IsZero() {
+		return false
+	}
+	season := category.StartAt
+	if season.IsZero() {
+		season = time.Now()
+	}
+	age := season.Year() - birthDate.Year()
+	return age >= category.MinimumAge && (category.MaximumAge == 0 || age <= category.MaximumAge)
+}
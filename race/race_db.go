@@ -1,10 +1,21 @@
 package race
 
 import (
+	"bike_race/auth"
+	"bike_race/media"
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/samber/lo"
+)
+
+var (
+	ErrRaceConcurrentUpdate = errors.New("race was updated concurrently")
 )
 
 func LoadRace(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (Race, error) {
@@ -12,24 +23,40 @@ func LoadRace(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (Race, e
 	if err != nil {
 		return Race{}, kcore.Wrap(err, "error beginning transaction")
 	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 	var race Race
 	err = tx.QueryRow(ctx, `
 	SELECT
-		races.id, races.name, races.start_at, races.is_open_for_registration, races.maximum_participants, races.cover_image_id,
-		array_agg(race_organizers.user_id) as organizers_ids
+		races.id, races.name, races.status, races.start_at, races.timezone, races.is_open_for_registration, races.maximum_participants, races.cover_image_id, races.version,
+		coalesce(races.registration_opens_at, '0001-01-01T00:00:00Z'), coalesce(races.registration_closes_at, '0001-01-01T00:00:00Z'),
+		races.course_file, races.course_distance, races.course_elevation_gain, races.course_elevation_loss, races.course_max_gradient, races.course_profile, races.course_outline,
+		races.timing_token_version
 	FROM races
-	LEFT JOIN race_organizers ON races.id = race_organizers.race_id
 	WHERE races.id = $1
-	GROUP BY races.id, races.name, races.start_at, races.is_open_for_registration
-	`, raceId).Scan(&race.Id, &race.Name, &race.StartAt, &race.IsOpenForRegistration, &race.MaximumParticipants, &race.CoverImage, &race.Organizers)
+	`, raceId).Scan(&race.Id, &race.Name, &race.Status, &race.StartAt, &race.Timezone, &race.IsOpenForRegistration, &race.MaximumParticipants, &race.CoverImage, &race.version,
+		&race.RegistrationOpensAt, &race.RegistrationClosesAt,
+		&race.Course, &race.CourseStats.Distance, &race.CourseStats.ElevationGain, &race.CourseStats.ElevationLoss, &race.CourseStats.MaxGradient, &race.CourseStats.Drawing.Profile, &race.CourseStats.Drawing.Outline,
+		&race.TimingTokenVersion)
 	if err != nil {
 		return Race{}, kcore.Wrap(err, "error selecting races table")
 	}
+	race.Organizers, race.OrganizerInvitations, err = loadRaceOrganizers(ctx, tx, raceId)
+	if err != nil {
+		return Race{}, err
+	}
+	race.Categories, err = loadRaceCategories(ctx, tx, raceId)
+	if err != nil {
+		return Race{}, err
+	}
 	race.Registrations = map[kcore.ID]RaceRegistration{}
 	rows, err := tx.Query(ctx, `
-	SELECT user_id, registered_at, status, medical_certificate, is_medical_certificate_approved
+	SELECT
+		race_registrations.user_id, race_registrations.category_id, race_registrations.registered_at, race_registrations.status,
+		coalesce(race_registrations.rejection_reason, ''), race_registrations.medical_certificate, race_registrations.is_medical_certificate_approved, coalesce(race_registrations.bib, 0),
+		race_results.status, coalesce(race_results.finish_time_ms, 0), coalesce(race_results.rank, 0)
 	FROM race_registrations
-	WHERE race_id = $1
+	LEFT JOIN race_results ON race_results.race_id = race_registrations.race_id AND race_results.user_id = race_registrations.user_id
+	WHERE race_registrations.race_id = $1
 	`, raceId)
 	if err != nil {
 		return Race{}, kcore.Wrap(err, "error selecting race_registrations table")
@@ -37,51 +64,270 @@ func LoadRace(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (Race, e
 	defer rows.Close()
 	for rows.Next() {
 		var registration RaceRegistration
-		err := rows.Scan(&registration.UserId, &registration.RegisteredAt, &registration.Status, &registration.MedicalCertificate, &registration.IsMedicalCertificateApproved)
+		var resultStatus *RaceResultStatus
+		var result RaceResult
+		var finishTimeMs int64
+		err := rows.Scan(&registration.UserId, &registration.CategoryId, &registration.RegisteredAt, &registration.Status, &registration.RejectionReason, &registration.MedicalCertificate, &registration.IsMedicalCertificateApproved, &registration.Bib, &resultStatus, &finishTimeMs, &result.Rank)
 		if err != nil {
 			return Race{}, kcore.Wrap(err, "error scanning race_registrations table")
 		}
+		if resultStatus != nil {
+			result.Status = *resultStatus
+			result.FinishTime = time.Duration(finishTimeMs) * time.Millisecond
+			registration.Result = &result
+		}
 		race.Registrations[registration.UserId] = registration
 	}
 	return race, nil
 }
 
+func loadRaceOrganizers(ctx context.Context, tx pgx.Tx, raceId kcore.ID) ([]RaceOrganizer, map[kcore.ID]OrganizerRole, error) {
+	rows, err := tx.Query(ctx, `SELECT user_id, role FROM race_organizers WHERE race_id = $1`, raceId)
+	if err != nil {
+		return nil, nil, kcore.Wrap(err, "error selecting race_organizers table")
+	}
+	organizers, err := pgx.CollectRows(rows, pgx.RowToStructByPos[RaceOrganizer])
+	if err != nil {
+		return nil, nil, kcore.Wrap(err, "error scanning race_organizers table")
+	}
+	rows, err = tx.Query(ctx, `SELECT user_id, role FROM race_organizer_invitations WHERE race_id = $1`, raceId)
+	if err != nil {
+		return nil, nil, kcore.Wrap(err, "error selecting race_organizer_invitations table")
+	}
+	invitations, err := pgx.CollectRows(rows, pgx.RowToStructByPos[RaceOrganizer])
+	if err != nil {
+		return nil, nil, kcore.Wrap(err, "error scanning race_organizer_invitations table")
+	}
+	return organizers, lo.SliceToMap(invitations, func(invitation RaceOrganizer) (kcore.ID, OrganizerRole) {
+		return invitation.UserId, invitation.Role
+	}), nil
+}
+
+func loadRaceCategories(ctx context.Context, tx pgx.Tx, raceId kcore.ID) ([]RaceCategory, error) {
+	categories := []RaceCategory{}
+	rows, err := tx.Query(ctx, `
+	SELECT id, name, start_at, maximum_participants, minimum_age, maximum_age, first_bib, last_bib
+	FROM race_categories
+	WHERE race_id = $1
+	ORDER BY start_at, name
+	`, raceId)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_categories table")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category RaceCategory
+		err := rows.Scan(&category.Id, &category.Name, &category.StartAt, &category.MaximumParticipants, &category.MinimumAge, &category.MaximumAge, &category.Bibs.First, &category.Bibs.Last)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error scanning race_categories table")
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+// loadUserIdByUsername lets organizers invite co-organizers by their username
+func loadUserIdByUsername(ctx context.Context, conn *pgxpool.Pool, username string) (kcore.ID, error) {
+	var userId kcore.ID
+	err := conn.QueryRow(ctx, `SELECT id FROM users WHERE username = $1`, username).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return userId, auth.ErrUserNotFound
+	} else if err != nil {
+		return userId, kcore.Wrap(err, "error selecting users table")
+	}
+	return userId, nil
+}
+
+// loadRegistrationUsernames lets imports reference riders by their username
+func loadRegistrationUsernames(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID) (map[string]kcore.ID, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT users.username, users.id
+	FROM race_registrations
+	INNER JOIN users ON users.id = race_registrations.user_id
+	WHERE race_registrations.race_id = $1
+	`, raceId)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_registrations table")
+	}
+	defer rows.Close()
+	usernames := map[string]kcore.ID{}
+	for rows.Next() {
+		var username string
+		var userId kcore.ID
+		err := rows.Scan(&username, &userId)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error scanning race_registrations table")
+		}
+		usernames[username] = userId
+	}
+	return usernames, nil
+}
+
 func (race *Race) Save(ctx context.Context, conn *pgxpool.Pool) error {
 	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return kcore.Wrap(err, "error beginning transaction")
 	}
-	_, err = tx.Exec(ctx, `
-	INSERT INTO races (id, name, start_at, is_open_for_registration, maximum_participants, cover_image_id)
-	VALUES ($1, $2, $3, $4, $5, $6)
-	ON CONFLICT (id) DO UPDATE SET name = $2, start_at = $3, is_open_for_registration = $4, maximum_participants = $5, cover_image_id = $6
-	`, race.Id, race.Name, race.StartAt, race.IsOpenForRegistration, race.MaximumParticipants, race.CoverImage)
+	defer tx.Rollback(ctx) //nolint:errcheck
+	// The version check makes concurrent updates of the same race (e.g. two registrations for the last spot) fail instead of overwriting each other
+	tag, err := tx.Exec(ctx, `
+	INSERT INTO races (id, name, start_at, is_open_for_registration, maximum_participants, cover_image_id, version, course_file, course_distance, course_elevation_gain, course_elevation_loss, course_max_gradient, timezone,
+		registration_opens_at, registration_closes_at, status, course_profile, course_outline, timing_token_version)
+	VALUES ($1, $2, $3, $4, $5, $6, $7 + 1, $8, $9, $10, $11, $12, $13, nullif($14, '0001-01-01T00:00:00Z'::timestamptz), nullif($15, '0001-01-01T00:00:00Z'::timestamptz), $16, $17, $18, $19)
+	ON CONFLICT (id) DO UPDATE SET name = $2, start_at = $3, is_open_for_registration = $4, maximum_participants = $5, cover_image_id = $6, version = $7 + 1,
+		course_file = $8, course_distance = $9, course_elevation_gain = $10, course_elevation_loss = $11, course_max_gradient = $12, timezone = $13,
+		registration_opens_at = nullif($14, '0001-01-01T00:00:00Z'::timestamptz), registration_closes_at = nullif($15, '0001-01-01T00:00:00Z'::timestamptz), status = $16,
+		course_profile = $17, course_outline = $18, timing_token_version = $19
+	WHERE races.version = $7
+	`, race.Id, race.Name, race.StartAt, race.IsOpenForRegistration, race.MaximumParticipants, race.CoverImage, race.version,
+		race.Course, race.CourseStats.Distance, race.CourseStats.ElevationGain, race.CourseStats.ElevationLoss, race.CourseStats.MaxGradient, race.Timezone,
+		race.RegistrationOpensAt, race.RegistrationClosesAt, race.Status, race.CourseStats.Drawing.Profile, race.CourseStats.Drawing.Outline, race.TimingTokenVersion)
 	if err != nil {
 		return kcore.Wrap(err, "error userting race table")
 	}
-	for _, organizer := range race.Organizers {
+	if tag.RowsAffected() == 0 {
+		return ErrRaceConcurrentUpdate
+	}
+	err = saveRaceOrganizers(ctx, tx, *race)
+	if err != nil {
+		return err
+	}
+	categoryIds := lo.Map(race.Categories, func(category RaceCategory, _ int) kcore.ID { return category.Id })
+	_, err = tx.Exec(ctx, `
+	DELETE FROM race_categories
+	WHERE race_id = $1 AND NOT (id = ANY($2))
+	`, race.Id, categoryIds)
+	if err != nil {
+		return kcore.Wrap(err, "error deleting race_categories table")
+	}
+	for _, category := range race.Categories {
 		_, err = tx.Exec(ctx, `
-		INSERT INTO race_organizers (race_id, user_id)
-		VALUES ($1, $2)
-		ON CONFLICT (race_id, user_id) DO NOTHING
-		`, race.Id, organizer)
+		INSERT INTO race_categories (id, race_id, name, start_at, maximum_participants, minimum_age, maximum_age, first_bib, last_bib)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET name = $3, start_at = $4, maximum_participants = $5, minimum_age = $6, maximum_age = $7, first_bib = $8, last_bib = $9
+		`, category.Id, race.Id, category.Name, category.StartAt, category.MaximumParticipants, category.MinimumAge, category.MaximumAge, category.Bibs.First, category.Bibs.Last)
 		if err != nil {
-			return kcore.Wrap(err, "error upserting race_organizers table")
+			return kcore.Wrap(err, "error upserting race_categories table")
 		}
 	}
 	for _, registration := range race.Registrations {
 		_, err = tx.Exec(ctx, `
-		INSERT INTO race_registrations (race_id, user_id, registered_at, status, medical_certificate, is_medical_certificate_approved)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (race_id, user_id) DO UPDATE SET registered_at = $3, status = $4, medical_certificate = $5, is_medical_certificate_approved = $6
-		`, race.Id, registration.UserId, registration.RegisteredAt, registration.Status, registration.MedicalCertificate, registration.IsMedicalCertificateApproved)
+		INSERT INTO race_registrations (race_id, user_id, category_id, registered_at, status, medical_certificate, is_medical_certificate_approved, rejection_reason, bib)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, nullif($8, ''), nullif($9, 0))
+		ON CONFLICT (race_id, user_id) DO UPDATE SET category_id = $3, registered_at = $4, status = $5, medical_certificate = $6, is_medical_certificate_approved = $7, rejection_reason = nullif($8, ''), bib = nullif($9, 0)
+		`, race.Id, registration.UserId, registration.CategoryId, registration.RegisteredAt, registration.Status, registration.MedicalCertificate, registration.IsMedicalCertificateApproved, registration.RejectionReason, registration.Bib)
 		if err != nil {
 			return kcore.Wrap(err, "error upserting race_registrations table")
 		}
 	}
+	if race.resultsChanged {
+		err = saveRaceResults(ctx, tx, race)
+		if err != nil {
+			return err
+		}
+	}
+	for _, notification := range race.notifications {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO notifications (user_id, race_id, kind, created_at)
+		VALUES ($1, $2, $3, now())
+		`, notification.UserId, race.Id, notification.Kind)
+		if err != nil {
+			return kcore.Wrap(err, "error inserting notifications table")
+		}
+	}
 	err = tx.Commit(ctx)
 	if err != nil {
 		return kcore.Wrap(err, "error committing transaction")
 	}
+	race.notifications = nil
+	race.resultsChanged = false
+	race.version++
+	return nil
+}
+
+// saveRaceResults replaces all the results, as an import ranks the whole race again
+func saveRaceResults(ctx context.Context, tx pgx.Tx, race *Race) error {
+	_, err := tx.Exec(ctx, `DELETE FROM race_results WHERE race_id = $1`, race.Id)
+	if err != nil {
+		return kcore.Wrap(err, "error deleting race_results table")
+	}
+	for _, registration := range race.Registrations {
+		if registration.Result == nil {
+			continue
+		}
+		_, err = tx.Exec(ctx, `
+		INSERT INTO race_results (race_id, user_id, status, finish_time_ms, rank)
+		VALUES ($1, $2, $3, nullif($4, 0), nullif($5, 0))
+		`, race.Id, registration.UserId, registration.Result.Status, registration.Result.FinishTime.Milliseconds(), registration.Result.Rank)
+		if err != nil {
+			return kcore.Wrap(err, "error inserting race_results table")
+		}
+	}
 	return nil
 }
+
+func saveRaceOrganizers(ctx context.Context, tx pgx.Tx, race Race) error {
+	organizerIds := lo.Map(race.Organizers, func(organizer RaceOrganizer, _ int) kcore.ID { return organizer.UserId })
+	_, err := tx.Exec(ctx, `
+	DELETE FROM race_organizers
+	WHERE race_id = $1 AND NOT (user_id = ANY($2))
+	`, race.Id, organizerIds)
+	if err != nil {
+		return kcore.Wrap(err, "error deleting race_organizers table")
+	}
+	for _, organizer := range race.Organizers {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO race_organizers (race_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (race_id, user_id) DO UPDATE SET role = $3
+		`, race.Id, organizer.UserId, organizer.Role)
+		if err != nil {
+			return kcore.Wrap(err, "error upserting race_organizers table")
+		}
+	}
+	_, err = tx.Exec(ctx, `
+	DELETE FROM race_organizer_invitations
+	WHERE race_id = $1 AND NOT (user_id = ANY($2))
+	`, race.Id, lo.Keys(race.OrganizerInvitations))
+	if err != nil {
+		return kcore.Wrap(err, "error deleting race_organizer_invitations table")
+	}
+	for userId, role := range race.OrganizerInvitations {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO race_organizer_invitations (race_id, user_id, role, invited_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (race_id, user_id) DO NOTHING
+		`, race.Id, userId, role)
+		if err != nil {
+			return kcore.Wrap(err, "error inserting race_organizer_invitations table")
+		}
+	}
+	return nil
+}
+
+// RotateMedicalCertificatesKey wraps the data keys of all medical certificates with the primary key of the keyring, it can be run again after a failure
+func RotateMedicalCertificatesKey(ctx context.Context, conn *pgxpool.Pool, keyring media.Keyring) (int, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT medical_certificate
+	FROM race_registrations
+	WHERE medical_certificate IS NOT NULL
+	`)
+	if err != nil {
+		return 0, kcore.Wrap(err, "error selecting race_registrations table")
+	}
+	medicalCertificates, err := pgx.CollectRows(rows, pgx.RowTo[kcore.File])
+	if err != nil {
+		return 0, kcore.Wrap(err, "error scanning race_registrations table")
+	}
+	rotated := 0
+	for _, medicalCertificate := range medicalCertificates {
+		isRotated, err := keyring.Rewrap(medicalCertificate.Path())
+		if err != nil {
+			return rotated, kcore.Wrap(err, fmt.Sprintf("error rotating %s", medicalCertificate))
+		}
+		if isRotated {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
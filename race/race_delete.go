@@ -0,0 +1,69 @@
+package race
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+// deleteRace removes the race with everything that references it, files are only deleted once that is committed
+func deleteRace(ctx context.Context, conn *pgxpool.Pool, race Race) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	tag, err := tx.Exec(ctx, `UPDATE races SET version = version + 1 WHERE id = $1 AND version = $2`, race.Id, race.version)
+	if err != nil {
+		return kcore.Wrap(err, "error updating races table")
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRaceConcurrentUpdate
+	}
+	for _, table := range []string{"notifications", "race_organizer_invitations", "race_organizers", "race_results", "race_passages", "race_registrations", "race_categories", "medical_certificate_purges", "races"} {
+		err = deleteRaceRows(ctx, tx, table, race.Id)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kcore.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
+
+func deleteRaceRows(ctx context.Context, tx pgx.Tx, table string, raceId kcore.ID) error {
+	column := "race_id"
+	if table == "races" {
+		column = "id"
+	}
+	_, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE "+column+" = $1", raceId)
+	if err != nil {
+		return kcore.Wrap(err, "error deleting "+table+" table")
+	}
+	return nil
+}
+
+type deletableFile interface {
+	Delete() error
+}
+
+// raceFiles are the files to delete with the race
+func raceFiles(race Race) []deletableFile {
+	files := []deletableFile{}
+	if race.CoverImage != nil {
+		files = append(files, race.CoverImage)
+	}
+	if race.Course != nil {
+		files = append(files, race.Course)
+	}
+	for _, registration := range race.Registrations {
+		if registration.MedicalCertificate != nil {
+			files = append(files, registration.MedicalCertificate)
+		}
+	}
+	return files
+}
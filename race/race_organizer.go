@@ -0,0 +1,129 @@
+package race
+
+import (
+	"bike_race/auth"
+	"errors"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/samber/lo"
+)
+
+var (
+	ErrOrganizerRoleInvalid        = errors.New("organizer role is invalid")
+	ErrOrganizerRoleNotAllowed     = errors.New("organizer role does not allow this action")
+	ErrUserAlreadyOrganizer        = errors.New("user is already an organizer")
+	ErrOrganizerAlreadyInvited     = errors.New("user is already invited")
+	ErrOrganizerInvitationNotFound = errors.New("organizer invitation not found")
+	ErrLastOwnerCannotBeRemoved    = errors.New("the last owner cannot be removed")
+	ErrLastOwnerCannotChangeRole   = errors.New("the last owner cannot change role")
+	ErrOrganizerNotFound           = errors.New("organizer not found")
+)
+
+// OrganizerRole limits what a co-organizer can do, owners can do everything
+type OrganizerRole string
+
+const (
+	OwnerRole            OrganizerRole = "owner"
+	EditorRole           OrganizerRole = "editor"
+	DocumentReviewerRole OrganizerRole = "document_reviewer"
+)
+
+var OrganizerRoles = []OrganizerRole{OwnerRole, EditorRole, DocumentReviewerRole}
+
+func ParseOrganizerRole(role string) (OrganizerRole, error) {
+	if !lo.Contains(OrganizerRoles, OrganizerRole(role)) {
+		return "", ErrOrganizerRoleInvalid
+	}
+	return OrganizerRole(role), nil
+}
+
+type RaceOrganizer struct {
+	UserId kcore.ID
+	Role   OrganizerRole
+}
+
+func (race Race) organizerRole(userId kcore.ID) (OrganizerRole, bool) {
+	organizer, ok := lo.Find(race.Organizers, func(organizer RaceOrganizer) bool { return organizer.UserId == userId })
+	return organizer.Role, ok
+}
+
+// CheckRole lets owners do everything, and other organizers only what their role allows
+func (race Race) CheckRole(user auth.User, role OrganizerRole) error {
+	userRole, ok := race.organizerRole(user.Id)
+	if !ok {
+		return ErrUserNotOrganizer
+	}
+	if userRole != OwnerRole && userRole != role {
+		return ErrOrganizerRoleNotAllowed
+	}
+	return nil
+}
+
+func (race Race) ownersCount() int {
+	return lo.CountBy(race.Organizers, func(organizer RaceOrganizer) bool { return organizer.Role == OwnerRole })
+}
+
+func (race *Race) InviteOrganizer(userId kcore.ID, role OrganizerRole) error {
+	if !lo.Contains(OrganizerRoles, role) {
+		return ErrOrganizerRoleInvalid
+	}
+	if _, ok := race.organizerRole(userId); ok {
+		return ErrUserAlreadyOrganizer
+	}
+	if _, ok := race.OrganizerInvitations[userId]; ok {
+		return ErrOrganizerAlreadyInvited
+	}
+	race.OrganizerInvitations[userId] = role
+	return nil
+}
+
+func (race *Race) AcceptOrganizerInvitation(userId kcore.ID) error {
+	role, ok := race.OrganizerInvitations[userId]
+	if !ok {
+		return ErrOrganizerInvitationNotFound
+	}
+	delete(race.OrganizerInvitations, userId)
+	race.Organizers = append(race.Organizers, RaceOrganizer{UserId: userId, Role: role})
+	return nil
+}
+
+// DeclineOrganizerInvitation is also used by owners to revoke an invitation
+func (race *Race) DeclineOrganizerInvitation(userId kcore.ID) error {
+	if _, ok := race.OrganizerInvitations[userId]; !ok {
+		return ErrOrganizerInvitationNotFound
+	}
+	delete(race.OrganizerInvitations, userId)
+	return nil
+}
+
+// RemoveOrganizer always keeps an owner, so that the race can still be managed
+func (race *Race) RemoveOrganizer(userId kcore.ID) error {
+	role, ok := race.organizerRole(userId)
+	if !ok {
+		return ErrOrganizerNotFound
+	}
+	if role == OwnerRole && race.ownersCount() == 1 {
+		return ErrLastOwnerCannotBeRemoved
+	}
+	race.Organizers = lo.Reject(race.Organizers, func(organizer RaceOrganizer, _ int) bool { return organizer.UserId == userId })
+	return nil
+}
+
+func (race *Race) ChangeOrganizerRole(userId kcore.ID, role OrganizerRole) error {
+	if !lo.Contains(OrganizerRoles, role) {
+		return ErrOrganizerRoleInvalid
+	}
+	currentRole, ok := race.organizerRole(userId)
+	if !ok {
+		return ErrOrganizerNotFound
+	}
+	if currentRole == OwnerRole && role != OwnerRole && race.ownersCount() == 1 {
+		return ErrLastOwnerCannotChangeRole
+	}
+	for i := range race.Organizers {
+		if race.Organizers[i].UserId == userId {
+			race.Organizers[i].Role = role
+		}
+	}
+	return nil
+}
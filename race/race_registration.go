@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/martinlehoux/kagamigo/kcore"
+	"github.com/samber/lo"
 )
 
 type RaceRegistrationStatus string
@@ -12,14 +13,33 @@ const (
 	Registered RaceRegistrationStatus = "registered"
 	Submitted  RaceRegistrationStatus = "submitted"
 	Approved   RaceRegistrationStatus = "approved"
+	Waitlisted RaceRegistrationStatus = "waitlisted"
+	Rejected   RaceRegistrationStatus = "rejected"
+	Withdrawn  RaceRegistrationStatus = "withdrawn"
+	Cancelled  RaceRegistrationStatus = "cancelled"
 )
 
+// IsParticipant is true when the registration holds one of the race spots
+func (status RaceRegistrationStatus) IsParticipant() bool {
+	return lo.Contains([]RaceRegistrationStatus{Registered, Submitted, Approved}, status)
+}
+
+// IsActive is true when the registration can still be withdrawn or cancelled
+func (status RaceRegistrationStatus) IsActive() bool {
+	return status.IsParticipant() || status == Waitlisted
+}
+
 type RaceRegistration struct {
 	UserId                       kcore.ID
+	CategoryId                   *kcore.ID
 	RegisteredAt                 time.Time
 	Status                       RaceRegistrationStatus
+	RejectionReason              string
 	MedicalCertificate           *kcore.File
 	IsMedicalCertificateApproved bool
+	// Bib is 0 until the registration is approved
+	Bib    int
+	Result *RaceResult
 }
 
 func NewRaceRegistration(userId kcore.ID) RaceRegistration {
@@ -0,0 +1,56 @@
+package race
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrResultFinishTimeMissing = errors.New("finish time is missing")
+)
+
+type RaceResultStatus string
+
+const (
+	Finished     RaceResultStatus = "finished"
+	DidNotFinish RaceResultStatus = "dnf"
+	DidNotStart  RaceResultStatus = "dns"
+	Disqualified RaceResultStatus = "dsq"
+)
+
+type RaceResult struct {
+	Status     RaceResultStatus
+	FinishTime time.Duration
+	// Rank is only set for finished results, and shared by riders with the same finish time
+	Rank int
+}
+
+func NewRaceResult(status RaceResultStatus, finishTime time.Duration) (RaceResult, error) {
+	if status == Finished && finishTime <= 0 {
+		return RaceResult{}, ErrResultFinishTimeMissing
+	}
+	if status != Finished {
+		finishTime = 0
+	}
+	return RaceResult{Status: status, FinishTime: finishTime}, nil
+}
+
+// rankResults sets the overall rank of finished results, by finish time
+func rankResults(results []*RaceResult) {
+	finished := make([]*RaceResult, 0, len(results))
+	for _, result := range results {
+		result.Rank = 0
+		if result.Status == Finished {
+			finished = append(finished, result)
+		}
+	}
+	sort.SliceStable(finished, func(i, j int) bool { return finished[i].FinishTime < finished[j].FinishTime })
+	for i, result := range finished {
+		if i > 0 && result.FinishTime == finished[i-1].FinishTime {
+			result.Rank = finished[i-1].Rank
+		} else {
+			result.Rank = i + 1
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package race
+
+import (
+	"errors"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrRaceWrongStatus  = errors.New("race is not in the correct status")
+	ErrRaceNotPublished = errors.New("race must be published first")
+	ErrRaceNotStarted   = errors.New("race has not started yet")
+)
+
+// RaceStatus is the lifecycle of the race itself, registrations can only be opened while it is published
+type RaceStatus string
+
+const (
+	RaceDraft     RaceStatus = "draft"
+	RacePublished RaceStatus = "published"
+	RaceCancelled RaceStatus = "cancelled"
+	RaceFinished  RaceStatus = "finished"
+)
+
+type NotificationKind string
+
+const (
+	RaceCancelledNotification NotificationKind = "race_cancelled"
+)
+
+// Notification is queued with the race changes, and sent to the rider later on
+type Notification struct {
+	UserId kcore.ID
+	Kind   NotificationKind
+}
+
+// Publish makes the race visible to everyone, not only its organizers
+func (race *Race) Publish() error {
+	if race.Status != RaceDraft {
+		return ErrRaceWrongStatus
+	}
+	race.Status = RacePublished
+	return nil
+}
+
+// Unpublish hides the race again, registrations stay as they are but can not be opened until it is published again
+func (race *Race) Unpublish() error {
+	if race.Status != RacePublished {
+		return ErrRaceWrongStatus
+	}
+	race.Status = RaceDraft
+	race.IsOpenForRegistration = false
+	return nil
+}
+
+// Cancel ends every active registration at once, without promoting the waitlist, and notifies the riders
+func (race *Race) Cancel() error {
+	if race.Status != RaceDraft && race.Status != RacePublished {
+		return ErrRaceWrongStatus
+	}
+	race.Status = RaceCancelled
+	race.IsOpenForRegistration = false
+	for userId, registration := range race.Registrations {
+		if !registration.Status.IsActive() {
+			continue
+		}
+		registration.Status = Cancelled
+		registration.Bib = 0
+		race.Registrations[userId] = registration
+		race.notifications = append(race.notifications, Notification{UserId: userId, Kind: RaceCancelledNotification})
+	}
+	return nil
+}
+
+func (race *Race) Finish(now time.Time) error {
+	if race.Status != RacePublished {
+		return ErrRaceWrongStatus
+	}
+	if race.StartAt.IsZero() || now.Before(race.StartAt) {
+		return ErrRaceNotStarted
+	}
+	race.Status = RaceFinished
+	race.IsOpenForRegistration = false
+	return nil
+}
@@ -0,0 +1,419 @@
+package race
+
+import (
+	"bike_race/auth"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var testNow = time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestRace(t *testing.T, maximumParticipants int, categories ...RaceCategory) Race {
+	race, err := NewRace("Test race")
+	if err != nil {
+		t.Fatal(err)
+	}
+	race.Status = RacePublished
+	race.IsOpenForRegistration = true
+	race.MaximumParticipants = maximumParticipants
+	race.Categories = categories
+	return race
+}
+
+func newTestCategory(t *testing.T, name string, maximumParticipants int, minimumAge int, maximumAge int, bibs BibRange) RaceCategory {
+	category, err := NewRaceCategory(name, time.Date(2026, time.June, 1, 9, 0, 0, 0, time.UTC), maximumParticipants, minimumAge, maximumAge, bibs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return category
+}
+
+func newTestUser(birthDate time.Time) auth.User {
+	return auth.User{Id: kcore.NewID(), ContactDetails: auth.ContactDetails{BirthDate: birthDate}}
+}
+
+// registerTestUsers registers one user after the other, a minute apart so that the waitlist order is known
+func registerTestUsers(t *testing.T, race *Race, count int, categoryId *kcore.ID) []kcore.ID {
+	userIds := make([]kcore.ID, 0, count)
+	for i := 0; i < count; i++ {
+		user := newTestUser(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+		err := race.Register(user, categoryId, testNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registration := race.Registrations[user.Id]
+		registration.RegisteredAt = testNow.Add(time.Duration(i) * time.Minute)
+		race.Registrations[user.Id] = registration
+		userIds = append(userIds, user.Id)
+	}
+	return userIds
+}
+
+// approveTestRegistration takes the registration through the medical certificate steps
+func approveTestRegistration(t *testing.T, race *Race, userId kcore.ID) error {
+	_, err := race.UploadMedicalCertificate(userId, kcore.NewFile(".pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []func(kcore.ID) error{race.SubmitRegistration, race.ApproveMedicalCertificate} {
+		err = step(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return race.ApproveRegistration(userId)
+}
+
+func TestRegisterWaitlistsOnceFull(t *testing.T) {
+	cases := []struct {
+		name                string
+		maximumParticipants int
+		categoryMaximum     int
+		registered          int
+		expected            RaceRegistrationStatus
+	}{
+		{"race has spots", 3, 0, 2, Registered},
+		{"race is full", 2, 0, 2, Waitlisted},
+		{"category has spots", 10, 3, 2, Registered},
+		{"category is full", 10, 2, 2, Waitlisted},
+		{"race is full before category", 2, 5, 2, Waitlisted},
+	}
+	for _, c := range cases {
+		var categories []RaceCategory
+		var categoryId *kcore.ID
+		if c.categoryMaximum > 0 {
+			category := newTestCategory(t, "Elite", c.categoryMaximum, 0, 0, BibRange{})
+			categories = append(categories, category)
+			categoryId = &category.Id
+		}
+		race := newTestRace(t, c.maximumParticipants, categories...)
+		registerTestUsers(t, &race, c.registered, categoryId)
+		user := newTestUser(time.Time{})
+		err := race.Register(user, categoryId, testNow)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if status := race.Registrations[user.Id].Status; status != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, status)
+		}
+	}
+}
+
+func TestWaitlistIsPromotedInRegistrationOrder(t *testing.T) {
+	cases := []struct {
+		name string
+		free func(race *Race, userId kcore.ID) error
+	}{
+		{"withdrawn", func(race *Race, userId kcore.ID) error { return race.WithdrawRegistration(userId) }},
+		{"cancelled", func(race *Race, userId kcore.ID) error { return race.CancelRegistration(userId) }},
+		{"rejected", func(race *Race, userId kcore.ID) error {
+			_, err := race.UploadMedicalCertificate(userId, kcore.NewFile(".pdf"))
+			if err != nil {
+				return err
+			}
+			err = race.SubmitRegistration(userId)
+			if err != nil {
+				return err
+			}
+			return race.RejectRegistration(userId, "certificate is not signed")
+		}},
+		{"more spots", func(race *Race, _ kcore.ID) error {
+			return race.OpenForRegistration(race.MaximumParticipants+1, time.Time{}, time.Time{}, testNow)
+		}},
+	}
+	for _, c := range cases {
+		race := newTestRace(t, 2)
+		userIds := registerTestUsers(t, &race, 4, nil)
+		err := c.free(&race, userIds[0])
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if status := race.Registrations[userIds[2]].Status; status != Registered {
+			t.Errorf("%s: expected the first waitlisted rider to be registered, got %s", c.name, status)
+		}
+		if status := race.Registrations[userIds[3]].Status; status != Waitlisted {
+			t.Errorf("%s: expected the second waitlisted rider to stay waitlisted, got %s", c.name, status)
+		}
+	}
+}
+
+func TestWaitlistPromotionSkipsFullCategories(t *testing.T) {
+	elite := newTestCategory(t, "Elite", 1, 0, 0, BibRange{})
+	open := newTestCategory(t, "Open", 5, 0, 0, BibRange{})
+	race := newTestRace(t, 2, elite, open)
+	riders := registerTestUsers(t, &race, 1, &elite.Id)
+	riders = append(riders, registerTestUsers(t, &race, 1, &open.Id)...)
+	waitlisted := registerTestUsers(t, &race, 1, &elite.Id)
+	waitlisted = append(waitlisted, registerTestUsers(t, &race, 1, &open.Id)...)
+	// The elite rider registered first, but their category is still full
+	registration := race.Registrations[waitlisted[0]]
+	registration.RegisteredAt = testNow.Add(-time.Hour)
+	race.Registrations[waitlisted[0]] = registration
+
+	err := race.WithdrawRegistration(riders[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := race.Registrations[waitlisted[0]].Status; status != Waitlisted {
+		t.Errorf("expected the elite rider to stay waitlisted, got %s", status)
+	}
+	if status := race.Registrations[waitlisted[1]].Status; status != Registered {
+		t.Errorf("expected the open rider to be registered, got %s", status)
+	}
+}
+
+func TestOpenForRegistrationKeepsRegisteredRiders(t *testing.T) {
+	race := newTestRace(t, 3)
+	registerTestUsers(t, &race, 3, nil)
+	err := race.OpenForRegistration(2, time.Time{}, time.Time{}, testNow)
+	if !errors.Is(err, ErrMaximumParticipantsLessThanRegisteredUsers) {
+		t.Errorf("expected %v, got %v", ErrMaximumParticipantsLessThanRegisteredUsers, err)
+	}
+}
+
+func TestRegistrationTransitions(t *testing.T) {
+	upload := func(race *Race, userId kcore.ID) error {
+		_, err := race.UploadMedicalCertificate(userId, kcore.NewFile(".pdf"))
+		return err
+	}
+	submit := func(race *Race, userId kcore.ID) error {
+		err := upload(race, userId)
+		if err != nil {
+			return err
+		}
+		return race.SubmitRegistration(userId)
+	}
+	submitApproved := func(race *Race, userId kcore.ID) error {
+		err := submit(race, userId)
+		if err != nil {
+			return err
+		}
+		return race.ApproveMedicalCertificate(userId)
+	}
+	approve := func(race *Race, userId kcore.ID) error {
+		err := submitApproved(race, userId)
+		if err != nil {
+			return err
+		}
+		return race.ApproveRegistration(userId)
+	}
+	closeRegistration := func(race *Race, userId kcore.ID) error {
+		err := submitApproved(race, userId)
+		if err != nil {
+			return err
+		}
+		return race.CloseRegistration()
+	}
+	withdraw := func(race *Race, userId kcore.ID) error { return race.WithdrawRegistration(userId) }
+	cancel := func(race *Race, userId kcore.ID) error { return race.CancelRegistration(userId) }
+	submitOnly := func(race *Race, userId kcore.ID) error { return race.SubmitRegistration(userId) }
+	approveOnly := func(race *Race, userId kcore.ID) error { return race.ApproveRegistration(userId) }
+	reject := func(reason string) func(race *Race, userId kcore.ID) error {
+		return func(race *Race, userId kcore.ID) error { return race.RejectRegistration(userId, reason) }
+	}
+	registerAgain := func(race *Race, userId kcore.ID) error { return race.Register(auth.User{Id: userId}, nil, testNow) }
+	none := func(*Race, kcore.ID) error { return nil }
+	cases := []struct {
+		name     string
+		setup    func(race *Race, userId kcore.ID) error
+		action   func(race *Race, userId kcore.ID) error
+		expected error
+		status   RaceRegistrationStatus
+	}{
+		{"submit without certificate", none, submitOnly, ErrMedicalCertificateMissing, Registered},
+		{"submit", upload, submitOnly, nil, Submitted},
+		{"submit twice", submit, submitOnly, ErrRegistrationWrongStatus, Submitted},
+		{"upload once submitted", submit, upload, ErrRegistrationWrongStatus, Submitted},
+		{"approve before submit", upload, approveOnly, ErrRegistrationWrongStatus, Registered},
+		{"approve unapproved certificate", submit, approveOnly, ErrMedicalCertificateNotApproved, Submitted},
+		{"approve", submitApproved, approveOnly, nil, Approved},
+		{"approve after registrations closed", closeRegistration, approveOnly, nil, Approved},
+		{"reject without reason", submit, reject(""), ErrRejectionReasonMissing, Submitted},
+		{"reject", submit, reject("certificate is not signed"), nil, Rejected},
+		{"reject approved", approve, reject("too late"), ErrRegistrationWrongStatus, Approved},
+		{"withdraw approved", approve, withdraw, nil, Withdrawn},
+		{"cancel withdrawn", withdraw, cancel, ErrRegistrationWrongStatus, Withdrawn},
+		{"register again", none, registerAgain, ErrUserAlreadyRegistered, Registered},
+		{"register again after withdrawing", withdraw, registerAgain, nil, Registered},
+	}
+	for _, c := range cases {
+		race := newTestRace(t, 10)
+		userId := registerTestUsers(t, &race, 1, nil)[0]
+		err := c.setup(&race, userId)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		err = c.action(&race, userId)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+		if status := race.Registrations[userId].Status; status != c.status {
+			t.Errorf("%s: expected %s, got %s", c.name, c.status, status)
+		}
+	}
+}
+
+func TestRegisterFollowsTheRegistrationWindow(t *testing.T) {
+	cases := []struct {
+		name     string
+		isOpen   bool
+		opensAt  time.Time
+		closesAt time.Time
+		expected error
+	}{
+		{"open without window", true, time.Time{}, time.Time{}, nil},
+		{"closed", false, time.Time{}, time.Time{}, ErrRegistrationsClosed},
+		{"within window", true, testNow.Add(-time.Hour), testNow.Add(time.Hour), nil},
+		{"before opening", true, testNow.Add(time.Hour), testNow.Add(2 * time.Hour), ErrRegistrationsClosed},
+		{"at closing", true, time.Time{}, testNow, ErrRegistrationsClosed},
+	}
+	for _, c := range cases {
+		race := newTestRace(t, 10)
+		race.IsOpenForRegistration = c.isOpen
+		race.RegistrationOpensAt = c.opensAt
+		race.RegistrationClosesAt = c.closesAt
+		err := race.Register(newTestUser(time.Time{}), nil, testNow)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+	}
+}
+
+// The category starts in 2026, ages are those reached during that season
+func TestRegisterChecksCategoryEligibility(t *testing.T) {
+	juniors := newTestCategory(t, "Juniors", 10, 17, 18, BibRange{})
+	open := newTestCategory(t, "Open", 10, 0, 0, BibRange{})
+	unknownId := kcore.NewID()
+	cases := []struct {
+		name       string
+		categoryId *kcore.ID
+		birthDate  time.Time
+		expected   error
+	}{
+		{"no category", nil, time.Date(2008, time.May, 1, 0, 0, 0, 0, time.UTC), ErrRaceCategoryRequired},
+		{"unknown category", &unknownId, time.Date(2008, time.May, 1, 0, 0, 0, 0, time.UTC), ErrRaceCategoryNotFound},
+		{"minimum age", &juniors.Id, time.Date(2009, time.December, 31, 0, 0, 0, 0, time.UTC), nil},
+		{"maximum age", &juniors.Id, time.Date(2008, time.January, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"too young", &juniors.Id, time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC), ErrRaceCategoryNotEligible},
+		{"too old", &juniors.Id, time.Date(2007, time.December, 31, 0, 0, 0, 0, time.UTC), ErrRaceCategoryNotEligible},
+		{"no birth date in profile", &juniors.Id, time.Time{}, ErrBirthDateRequired},
+		{"no age limits", &open.Id, time.Time{}, nil},
+	}
+	for _, c := range cases {
+		race := newTestRace(t, 10, juniors, open)
+		user := newTestUser(c.birthDate)
+		err := race.Register(user, c.categoryId, testNow)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+		_, isRegistered := race.Registrations[user.Id]
+		if isRegistered != (c.expected == nil) {
+			t.Errorf("%s: expected registered to be %t", c.name, c.expected == nil)
+		}
+	}
+}
+
+func TestApprovalAssignsBibsInCategoryRanges(t *testing.T) {
+	elite := newTestCategory(t, "Elite", 10, 0, 0, BibRange{First: 1, Last: 2})
+	open := newTestCategory(t, "Open", 10, 0, 0, BibRange{})
+	cases := []struct {
+		name     string
+		category kcore.ID
+		count    int
+		expected []int
+		err      error
+	}{
+		{"within the range", elite.Id, 2, []int{1, 2}, nil},
+		{"range is full", elite.Id, 3, []int{1, 2, 0}, ErrBibRangeFull},
+		{"outside all ranges", open.Id, 2, []int{3, 4}, nil},
+	}
+	for _, c := range cases {
+		race := newTestRace(t, 10, elite, open)
+		userIds := registerTestUsers(t, &race, c.count, &c.category)
+		var err error
+		for _, userId := range userIds {
+			err = approveTestRegistration(t, &race, userId)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		for i, userId := range userIds {
+			if bib := race.Registrations[userId].Bib; bib != c.expected[i] {
+				t.Errorf("%s: rider %d expected bib %d, got %d", c.name, i, c.expected[i], bib)
+			}
+		}
+	}
+}
+
+func TestApprovalReusesBibsOfEndedRegistrations(t *testing.T) {
+	race := newTestRace(t, 10)
+	userIds := registerTestUsers(t, &race, 3, nil)
+	for _, userId := range userIds[:2] {
+		err := approveTestRegistration(t, &race, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := race.WithdrawRegistration(userIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = approveTestRegistration(t, &race, userIds[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bib := race.Registrations[userIds[2]].Bib; bib != 1 {
+		t.Errorf("expected the freed bib 1, got %d", bib)
+	}
+}
+
+func TestAssignBibStaysInCategoryRanges(t *testing.T) {
+	elite := newTestCategory(t, "Elite", 10, 0, 0, BibRange{First: 1, Last: 10})
+	open := newTestCategory(t, "Open", 10, 0, 0, BibRange{})
+	cases := []struct {
+		name     string
+		category kcore.ID
+		bib      int
+		expected error
+	}{
+		{"within the category range", elite.Id, 5, nil},
+		{"outside the category range", elite.Id, 11, ErrBibOutsideCategoryRange},
+		{"taken", elite.Id, 1, ErrBibTaken},
+		{"invalid", elite.Id, 0, ErrBibInvalid},
+		{"outside all ranges", open.Id, 11, nil},
+		{"reserved by another category", open.Id, 5, ErrBibReserved},
+	}
+	for _, c := range cases {
+		race := newTestRace(t, 10, elite, open)
+		// The first elite rider holds bib 1
+		other := registerTestUsers(t, &race, 1, &elite.Id)[0]
+		err := approveTestRegistration(t, &race, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userId := registerTestUsers(t, &race, 1, &c.category)[0]
+		err = approveTestRegistration(t, &race, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = race.AssignBib(userId, c.bib)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+		if c.expected == nil && race.Registrations[userId].Bib != c.bib {
+			t.Errorf("%s: expected bib %d, got %d", c.name, c.bib, race.Registrations[userId].Bib)
+		}
+	}
+}
+
+func TestAssignBibNeedsApprovedRegistration(t *testing.T) {
+	race := newTestRace(t, 10)
+	userId := registerTestUsers(t, &race, 1, nil)[0]
+	err := race.AssignBib(userId, 1)
+	if !errors.Is(err, ErrBibRegistrationNotApproved) {
+		t.Errorf("expected %v, got %v", ErrBibRegistrationNotApproved, err)
+	}
+}
@@ -0,0 +1,76 @@
+package race
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+func expiredRegistrationRaceIds(ctx context.Context, conn *pgxpool.Pool, now time.Time) ([]kcore.ID, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT id FROM races WHERE is_open_for_registration AND registration_closes_at <= $1
+	`, now)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting races table")
+	}
+	raceIds, err := pgx.CollectRows(rows, pgx.RowTo[kcore.ID])
+	if err != nil {
+		return nil, kcore.Wrap(err, "error scanning races table")
+	}
+	return raceIds, nil
+}
+
+// closeExpiredRegistration goes through the race aggregate, a race updated concurrently is closed on the next run
+func closeExpiredRegistration(ctx context.Context, conn *pgxpool.Pool, raceId kcore.ID, now time.Time) error {
+	race, err := LoadRace(ctx, conn, raceId)
+	if err != nil {
+		return err
+	}
+	if !race.IsOpenForRegistration || race.RegistrationClosesAt.IsZero() || now.Before(race.RegistrationClosesAt) {
+		return nil
+	}
+	err = race.CloseRegistration()
+	if err != nil {
+		return err
+	}
+	return race.Save(ctx, conn)
+}
+
+func closeExpiredRegistrations(ctx context.Context, conn *pgxpool.Pool) {
+	now := time.Now()
+	raceIds, err := expiredRegistrationRaceIds(ctx, conn, now)
+	if err != nil {
+		slog.Error(kcore.Wrap(err, "error finding expired registrations").Error())
+		return
+	}
+	for _, raceId := range raceIds {
+		logger := slog.With(slog.String("raceId", raceId.String()))
+		err = closeExpiredRegistration(ctx, conn, raceId, now)
+		if errors.Is(err, ErrRaceConcurrentUpdate) {
+			logger.Warn(err.Error())
+		} else if err != nil {
+			logger.Error(kcore.Wrap(err, "error closing registrations").Error())
+		} else {
+			logger.Info("race registrations closed at deadline")
+		}
+	}
+}
+
+// RunRegistrationsClosing closes the registrations of races whose deadline has passed, until ctx is done
+func RunRegistrationsClosing(ctx context.Context, conn *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		closeExpiredRegistrations(ctx, conn)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
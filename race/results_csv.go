@@ -0,0 +1,235 @@
+package race
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+var (
+	ErrResultsColumnMissing  = errors.New("column not found in results header")
+	ErrResultsTimeInvalid    = errors.New("finish time must look like 1:02:03, 02:03 or 1:02:03.45")
+	ErrResultsRiderMissing   = errors.New("rider is missing")
+	ErrResultsBibInvalid     = errors.New("bib must be a positive number")
+	ErrResultsBibUnknown     = errors.New("no approved registration has this bib")
+	ErrResultsRiderDuplicate = errors.New("rider appears more than once")
+)
+
+// ResultsColumnMapping names the header columns of a timing system export
+type ResultsColumnMapping struct {
+	// Rider is the username, it is optional when Bib is set, as riders are then matched on their bib
+	Rider string
+	Bib   string
+	Time  string
+	// Status is optional, rows without a time and without a status are rejected
+	Status    string
+	Delimiter rune
+}
+
+type ResultsCSVRow struct {
+	Line   int
+	Rider  string
+	Bib    int
+	Result RaceResult
+}
+
+// label names the row in errors, with what the file used to identify the rider
+func (row ResultsCSVRow) label() string {
+	if row.Bib != 0 {
+		return fmt.Sprintf("#%d", row.Bib)
+	}
+	return row.Rider
+}
+
+func ParseResultsCSV(raw io.Reader, mapping ResultsColumnMapping) ([]ResultsCSVRow, error) {
+	reader := csv.NewReader(raw)
+	reader.Comma = mapping.Delimiter
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, kcore.Wrap(err, "error reading results header")
+	}
+	columns, err := resultsColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []ResultsCSVRow{}
+	var errs []error
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, kcore.Wrap(err, "error reading results")
+		}
+		row, err := parseResultsRecord(record, columns)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	return rows, errors.Join(errs...)
+}
+
+type resultsColumnIndexes struct {
+	rider  int
+	bib    int
+	time   int
+	status int
+}
+
+func resultsColumns(header []string, mapping ResultsColumnMapping) (resultsColumnIndexes, error) {
+	find := func(name string) int {
+		if strings.TrimSpace(name) == "" {
+			return -1
+		}
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), strings.TrimSpace(name)) {
+				return i
+			}
+		}
+		return -1
+	}
+	columns := resultsColumnIndexes{rider: find(mapping.Rider), bib: -1, time: find(mapping.Time), status: -1}
+	if columns.rider == -1 && mapping.Bib == "" {
+		return columns, fmt.Errorf("%w: %s", ErrResultsColumnMissing, mapping.Rider)
+	}
+	if mapping.Bib != "" {
+		columns.bib = find(mapping.Bib)
+		if columns.bib == -1 {
+			return columns, fmt.Errorf("%w: %s", ErrResultsColumnMissing, mapping.Bib)
+		}
+	}
+	if columns.time == -1 {
+		return columns, fmt.Errorf("%w: %s", ErrResultsColumnMissing, mapping.Time)
+	}
+	if mapping.Status != "" {
+		columns.status = find(mapping.Status)
+		if columns.status == -1 {
+			return columns, fmt.Errorf("%w: %s", ErrResultsColumnMissing, mapping.Status)
+		}
+	}
+	return columns, nil
+}
+
+func parseResultsRecord(record []string, columns resultsColumnIndexes) (ResultsCSVRow, error) {
+	cell := func(index int) string {
+		if index < 0 || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+	row := ResultsCSVRow{Rider: cell(columns.rider)}
+	if bib := cell(columns.bib); bib != "" {
+		var err error
+		row.Bib, err = strconv.Atoi(bib)
+		if err != nil || row.Bib < 1 {
+			return row, ErrResultsBibInvalid
+		}
+	}
+	if row.Rider == "" && row.Bib == 0 {
+		return row, ErrResultsRiderMissing
+	}
+	// Timing systems often write the status in the time column
+	status := parseResultStatus(cell(columns.status))
+	if status == Finished {
+		status = parseResultStatus(cell(columns.time))
+	}
+	var finishTime time.Duration
+	if status == Finished {
+		var err error
+		finishTime, err = parseFinishTime(cell(columns.time))
+		if err != nil {
+			return row, err
+		}
+	}
+	result, err := NewRaceResult(status, finishTime)
+	if err != nil {
+		return row, err
+	}
+	row.Result = result
+	return row, nil
+}
+
+func parseResultStatus(value string) RaceResultStatus {
+	switch strings.ToUpper(value) {
+	case "DNF", "ABD", "AB":
+		return DidNotFinish
+	case "DNS", "NP":
+		return DidNotStart
+	case "DSQ", "DQ", "DISQ":
+		return Disqualified
+	default:
+		return Finished
+	}
+}
+
+// parseFinishTime accepts [h:]mm:ss with optional fractional seconds, using a dot or a comma
+func parseFinishTime(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, ErrResultsTimeInvalid
+	}
+	seconds, err := strconv.ParseFloat(strings.Replace(parts[len(parts)-1], ",", ".", 1), 64)
+	if err != nil || seconds < 0 || seconds >= 60 {
+		return 0, ErrResultsTimeInvalid
+	}
+	finishTime := time.Duration(seconds * float64(time.Second))
+	multiplier := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		value, err := strconv.Atoi(parts[i])
+		if err != nil || value < 0 {
+			return 0, ErrResultsTimeInvalid
+		}
+		finishTime += time.Duration(value) * multiplier
+		multiplier = time.Hour
+	}
+	return finishTime, nil
+}
+
+// resolveResultsRiders matches each row with an approved registration of the race, on its bib when the row has one, otherwise on the username
+func resolveResultsRiders(race Race, rows []ResultsCSVRow, usernames map[string]kcore.ID) (map[kcore.ID]RaceResult, error) {
+	bibs := map[int]kcore.ID{}
+	for userId, registration := range race.Registrations {
+		if registration.Status == Approved && registration.Bib != 0 {
+			bibs[registration.Bib] = userId
+		}
+	}
+	results := map[kcore.ID]RaceResult{}
+	var errs []error
+	for _, row := range rows {
+		var userId kcore.ID
+		var ok bool
+		if row.Bib != 0 {
+			userId, ok = bibs[row.Bib]
+			if !ok {
+				errs = append(errs, fmt.Errorf("line %d: %w: %s", row.Line, ErrResultsBibUnknown, row.label()))
+				continue
+			}
+		} else {
+			userId, ok = usernames[row.Rider]
+		}
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("line %d: %w: %s", row.Line, ErrUserNotRegistered, row.label()))
+		case race.Registrations[userId].Status != Approved:
+			errs = append(errs, fmt.Errorf("line %d: %w: %s", row.Line, ErrResultRegistrationNotApproved, row.label()))
+		default:
+			if _, duplicate := results[userId]; duplicate {
+				errs = append(errs, fmt.Errorf("line %d: %w: %s", row.Line, ErrResultsRiderDuplicate, row.label()))
+			}
+			results[userId] = row.Result
+		}
+	}
+	return results, errors.Join(errs...)
+}
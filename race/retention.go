@@ -0,0 +1,105 @@
+package race
+
+import (
+	"bike_race/auth"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/martinlehoux/kagamigo/kcore"
+	"golang.org/x/exp/slog"
+)
+
+type medicalCertificatePurge struct {
+	RaceId             kcore.ID
+	UserId             kcore.ID
+	MedicalCertificate kcore.File
+}
+
+// purgeExpiredMedicalCertificates forgets the certificates in the database first, files are only deleted once that is committed.
+// Races without a start date are skipped.
+func purgeExpiredMedicalCertificates(ctx context.Context, conn *pgxpool.Pool, retention time.Duration, now time.Time) ([]medicalCertificatePurge, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error beginning transaction")
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	rows, err := tx.Query(ctx, `
+	SELECT race_registrations.race_id, race_registrations.user_id, race_registrations.medical_certificate
+	FROM race_registrations
+	INNER JOIN races ON races.id = race_registrations.race_id
+	WHERE race_registrations.medical_certificate IS NOT NULL AND races.start_at < $1 AND races.start_at > $2
+	FOR UPDATE OF race_registrations
+	`, now.Add(-retention), time.Time{})
+	if err != nil {
+		return nil, kcore.Wrap(err, "error selecting race_registrations table")
+	}
+	purges, err := pgx.CollectRows(rows, pgx.RowToStructByPos[medicalCertificatePurge])
+	if err != nil {
+		return nil, kcore.Wrap(err, "error scanning race_registrations table")
+	}
+	for _, purge := range purges {
+		_, err = tx.Exec(ctx, `
+		UPDATE race_registrations SET medical_certificate = NULL WHERE race_id = $1 AND user_id = $2
+		`, purge.RaceId, purge.UserId)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error updating race_registrations table")
+		}
+		_, err = tx.Exec(ctx, `
+		INSERT INTO medical_certificate_purges (race_id, user_id, medical_certificate, purged_at)
+		VALUES ($1, $2, $3, $4)
+		`, purge.RaceId, purge.UserId, purge.MedicalCertificate, now)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error inserting medical_certificate_purges table")
+		}
+		// Races loaded before the purge must not write the certificate back
+		_, err = tx.Exec(ctx, `UPDATE races SET version = version + 1 WHERE id = $1`, purge.RaceId)
+		if err != nil {
+			return nil, kcore.Wrap(err, "error updating races table")
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, kcore.Wrap(err, "error committing transaction")
+	}
+	return purges, nil
+}
+
+func runMedicalCertificatesRetention(ctx context.Context, conn *pgxpool.Pool, retention time.Duration) {
+	purges, err := purgeExpiredMedicalCertificates(ctx, conn, retention, time.Now())
+	if err != nil {
+		slog.Error(kcore.Wrap(err, "error purging medical certificates").Error())
+		return
+	}
+	for _, purge := range purges {
+		err = purge.MedicalCertificate.Delete()
+		if err != nil {
+			slog.Error(err.Error(), slog.String("raceId", purge.RaceId.String()), slog.String("userId", purge.UserId.String()))
+		}
+	}
+	if len(purges) > 0 {
+		slog.Info("medical certificates purged", slog.Int("count", len(purges)))
+	}
+	// The login records are personal data as well, they share the hourly retention run
+	pruned, err := auth.PruneLoginRecords(ctx, conn, time.Now())
+	if err != nil {
+		slog.Error(kcore.Wrap(err, "error pruning login records").Error())
+	} else if pruned > 0 {
+		slog.Info("login records pruned", slog.Int64("count", pruned))
+	}
+}
+
+// RunMedicalCertificatesRetention deletes the certificates of races that started more than retention ago, and the old login records, until ctx is done
+func RunMedicalCertificatesRetention(ctx context.Context, conn *pgxpool.Pool, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runMedicalCertificatesRetention(ctx, conn, retention)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
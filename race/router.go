@@ -2,12 +2,18 @@ package race
 
 import (
 	"bike_race/auth"
+	"bike_race/config"
+	"bike_race/media"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -20,36 +26,76 @@ func raceDetailsUrl(raceId kcore.ID) string {
 	return fmt.Sprintf("/races/%s", raceId.String())
 }
 
-func Router(conn *pgxpool.Pool) *chi.Mux {
+// Router shares the live timing with the API router, so that finishing a race from either ends its live leaderboard
+func Router(conn *pgxpool.Pool, config config.Config, live *LiveTiming) *chi.Mux {
 	router := chi.NewRouter()
-	_, err := time.LoadLocation("Europe/Paris")
-	if err != nil {
-		err = kcore.Wrap(err, "error loading location")
-		slog.Error(err.Error())
-		os.Exit(1)
-	}
+	keyring := media.NewKeyring(config.MediaMasterKey, config.MediaPreviousMasterKey).WithPlaintext(config.MediaAllowPlaintext)
+	requireTOTP := requireTOTPForMedicalCertificatesMiddleware(config.RequireTOTPForMedicalCertificates)
 
 	router.Post("/organize", organizeRaceRoute(conn))
-	router.Post("/{raceId}/upload_medical_certificate", uploadRegistrationMedicalCertificateRoute(conn))
+	router.Post("/{raceId}/upload_medical_certificate", uploadRegistrationMedicalCertificateRoute(conn, keyring))
+	router.Post("/{raceId}/publish", raceCommandRoute(conn, PublishRaceCommand))
+	router.Post("/{raceId}/cancel", raceCommandRoute(conn, live.Ending(CancelRaceCommand)))
+	router.Post("/{raceId}/finish", raceCommandRoute(conn, live.Ending(FinishRaceCommand)))
+	router.Post("/{raceId}/organizers/invite", inviteOrganizerRoute(conn))
+	router.Post("/{raceId}/organizers/accept", raceCommandRoute(conn, AcceptOrganizerInvitationCommand))
+	router.Post("/{raceId}/organizers/decline", declineOrganizerInvitationRoute(conn))
+	router.Post("/{raceId}/organizers/{userId}/role", changeOrganizerRoleRoute(conn))
+	router.Post("/{raceId}/organizers/{userId}/remove", raceUserCommandRoute(conn, RemoveOrganizerCommand))
+	router.Post("/{raceId}/organizers/{userId}/revoke", raceUserCommandRoute(conn, RevokeOrganizerInvitationCommand))
 	router.Post("/{raceId}/open_for_registration", openRaceForRegistrationRoute(conn))
+	router.Post("/{raceId}/close_registration", raceCommandRoute(conn, CloseRegistrationCommand))
 	router.Post("/{raceId}/update_description", updateRaceDescriptionRoute(conn))
+	router.Post("/{raceId}/categories", addRaceCategoryRoute(conn))
+	router.Post("/{raceId}/categories/{categoryId}/update", updateRaceCategoryRoute(conn))
+	router.Post("/{raceId}/categories/{categoryId}/remove", removeRaceCategoryRoute(conn))
+	router.Post("/{raceId}/results", importRaceResultsRoute(conn))
+	router.Post("/{raceId}/live/passages", recordRacePassagesRoute(conn, live, config))
+	router.Post("/{raceId}/live/revoke_token", revokeTimingTokenRoute(conn))
 	router.Post("/{raceId}/register", registerForRaceRoute(conn))
+	router.Post("/{raceId}/submit_registration", submitRaceRegistrationRoute(conn))
+	router.Post("/{raceId}/withdraw_registration", withdrawRaceRegistrationRoute(conn))
+	router.Post("/{raceId}/registrations/{userId}/bib", assignRaceBibRoute(conn))
 	router.Post("/{raceId}/registrations/{userId}/approve", approveRaceRegistrationRoute(conn))
-	router.Post("/{raceId}/registrations/{userId}/approve_medical_certificate", approveRegistrationMedicalCertificateRoute(conn))
+	router.Post("/{raceId}/registrations/{userId}/reject", rejectRaceRegistrationRoute(conn))
+	router.Post("/{raceId}/registrations/{userId}/cancel", cancelRaceRegistrationRoute(conn))
+	router.With(requireTOTP).Post("/{raceId}/registrations/{userId}/approve_medical_certificate", approveRegistrationMedicalCertificateRoute(conn))
 
 	router.Get("/registrations", viewCurrentUserRegistrationsRoute(conn))
-	router.Get("/{raceId}", viewRaceDetailsRoute(conn))
+	router.Get("/{raceId}/results", viewRaceResultsRoute(conn))
+	router.Get("/{raceId}/start_list", viewStartListRoute(conn))
+	router.Get("/{raceId}/course", downloadRaceCourseRoute(conn))
+	router.With(requireTOTP).Get("/{raceId}/registrations/{userId}/medical_certificate", downloadMedicalCertificateRoute(conn, config, keyring))
+	router.Get("/{raceId}/live", viewLiveLeaderboardRoute(conn, live, config))
+	router.Get("/{raceId}/live/events", liveLeaderboardEventsRoute(conn, live))
+	router.Get("/{raceId}", viewRaceDetailsRoute(conn, config))
 	router.Get("/", viewRaceListRoute(conn))
 
 	return router
 }
 
+// requireTOTPForMedicalCertificatesMiddleware lets riders handle their own certificate without two-factor authentication.
+// Organizers need a session that checked a TOTP code, having it enabled is not enough.
+func requireTOTPForMedicalCertificatesMiddleware(required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := auth.UserFromContext(r.Context())
+			if required && ok && !user.HasVerifiedSecondFactor() && chi.URLParam(r, "userId") != user.Id.String() {
+				slog.Warn(auth.ErrTOTPRequired.Error(), slog.String("userId", user.Id.String()))
+				http.Error(w, auth.ErrTOTPRequired.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 type RaceTemplateData struct {
 	Race              RaceDetailModel
 	RaceRegistrations []RaceRegistrationModel
 }
 
-func viewRaceDetailsRoute(conn *pgxpool.Pool) http.HandlerFunc {
+func viewRaceDetailsRoute(conn *pgxpool.Pool, config config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
@@ -68,12 +114,141 @@ func viewRaceDetailsRoute(conn *pgxpool.Pool) http.HandlerFunc {
 			http.Error(w, err.Error(), code)
 			return
 		}
+		signMedicalCertificateUrls(config.Auth.CookieSecret, raceId, raceRegistrations)
+		if raceDetail.Course != nil && raceDetail.Course.Drawing.Profile == "" {
+			raceDetail.Course.Drawing, err = LoadCourseDrawing(kcore.File(raceDetail.Course.File))
+			if err != nil {
+				slog.Warn(kcore.Wrap(err, "error drawing course").Error())
+			}
+		}
 		login := auth.LoginFromContext(ctx)
 		page := RacePage(login, raceDetail, raceRegistrations)
 		kcore.RenderPage(r.Context(), page, w)
 	}
 }
 
+func viewRaceResultsRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results, code, err := RaceResultsQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		login := auth.LoginFromContext(ctx)
+		page := ResultsPage(login, results)
+		kcore.RenderPage(r.Context(), page, w)
+	}
+}
+
+func downloadRaceCourseRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		raceDetail, code, err := RaceDetailQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if raceDetail.Course == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gpx+xml")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.gpx"`, raceId.String()))
+		http.ServeFile(w, r, kcore.File(raceDetail.Course.File).Path())
+	}
+}
+
+// downloadMedicalCertificateRoute needs both a valid signed url, and a user allowed to see the certificate
+func downloadMedicalCertificateRoute(conn *pgxpool.Pool, config config.Config, keyring media.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userId, err := kcore.ParseID(chi.URLParam(r, "userId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing userId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = checkMediaUrl(config.Auth.CookieSecret, r, time.Now())
+		if err != nil {
+			slog.Warn(err.Error(), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		medicalCertificate, code, err := MedicalCertificateQuery(ctx, conn, raceId, userId)
+		if err != nil {
+			slog.Warn(err.Error(), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+			http.Error(w, err.Error(), code)
+			return
+		}
+		ext := filepath.Ext(string(medicalCertificate))
+		w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="medical_certificate%s"`, ext))
+		err = keyring.OpenFile(medicalCertificate.Path(), w)
+		if err != nil {
+			// Headers are already sent, the download is left incomplete
+			slog.Error(kcore.Wrap(err, "error decrypting medical certificate").Error(), slog.String("raceId", raceId.String()), slog.String("userId", userId.String()))
+		}
+	}
+}
+
+// viewStartListRoute serves the page, or a printable export with ?format=csv or ?format=pdf
+func viewStartListRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		raceDetail, code, err := RaceDetailQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		raceRegistrations, code, err := RaceRegistrationsQuery(ctx, conn, raceId, raceDetail.Permissions)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		entries := startList(raceDetail, raceRegistrations)
+		login := auth.LoginFromContext(ctx)
+		switch r.URL.Query().Get("format") {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, raceId.String()))
+			kcore.Expect(writeStartListCSV(w, login, entries, raceDetail.Location), "error exporting start list")
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, raceId.String()))
+			kcore.Expect(writeStartListPDF(w, login, raceDetail, entries, raceDetail.Location), "error exporting start list")
+		default:
+			page := StartListPage(login, raceDetail, entries, raceDetail.Location)
+			kcore.RenderPage(r.Context(), page, w)
+		}
+	}
+}
+
 type RacesTemplateData struct {
 	Races []RaceListModel
 }
@@ -86,8 +261,13 @@ func viewRaceListRoute(conn *pgxpool.Pool) http.HandlerFunc {
 			http.Error(w, err.Error(), code)
 			return
 		}
+		invitations, code, err := OrganizerInvitationsQuery(ctx, conn)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
 		login := auth.LoginFromContext(ctx)
-		page := RacesPage(login, races)
+		page := RacesPage(login, races, invitations)
 		kcore.RenderPage(r.Context(), page, w)
 	}
 }
@@ -138,6 +318,62 @@ func approveRaceRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+func rejectRaceRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userId, err := kcore.ParseID(chi.URLParam(r, "userId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing userId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		code, err := RejectRaceRegistrationCommand(ctx, conn, raceId, userId, r.FormValue("reason"))
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+func cancelRaceRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userId, err := kcore.ParseID(chi.URLParam(r, "userId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing userId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		code, err := CancelRaceRegistrationCommand(ctx, conn, raceId, userId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
 func approveRegistrationMedicalCertificateRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -177,7 +413,18 @@ func registerForRaceRoute(conn *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		code, err := RegisterForRaceCommand(ctx, conn, raceId)
+		var categoryId *kcore.ID
+		if r.FormValue("category_id") != "" {
+			id, err := kcore.ParseID(r.FormValue("category_id"))
+			if err != nil {
+				err = kcore.Wrap(err, "error parsing category_id")
+				slog.Warn(err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			categoryId = &id
+		}
+		code, err := RegisterForRaceCommand(ctx, conn, raceId, categoryId)
 		if err != nil {
 			http.Error(w, err.Error(), code)
 		} else {
@@ -186,7 +433,47 @@ func registerForRaceRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-func uploadRegistrationMedicalCertificateRoute(conn *pgxpool.Pool) http.HandlerFunc {
+func submitRaceRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		code, err := SubmitRaceRegistrationCommand(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/races/registrations", http.StatusSeeOther)
+		}
+	}
+}
+
+func withdrawRaceRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		code, err := WithdrawRaceRegistrationCommand(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/races/registrations", http.StatusSeeOther)
+		}
+	}
+}
+
+func uploadRegistrationMedicalCertificateRoute(conn *pgxpool.Pool, keyring media.Keyring) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
@@ -205,7 +492,7 @@ func uploadRegistrationMedicalCertificateRoute(conn *pgxpool.Pool) http.HandlerF
 			return
 		}
 		defer medicalCertificateFile.Close()
-		code, err := UploadRegistrationMedicalCertificateCommand(ctx, conn, raceId, medicalCertificateFile, filepath.Ext(medicalCertificateFileHeader.Filename))
+		code, err := UploadRegistrationMedicalCertificateCommand(ctx, conn, keyring, raceId, medicalCertificateFile, filepath.Ext(medicalCertificateFileHeader.Filename))
 		if err != nil {
 			http.Error(w, err.Error(), code)
 		} else {
@@ -214,6 +501,47 @@ func uploadRegistrationMedicalCertificateRoute(conn *pgxpool.Pool) http.HandlerF
 	}
 }
 
+func importRaceResultsRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resultsFile, _, err := r.FormFile("results")
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing results")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer resultsFile.Close()
+		mapping := ResultsColumnMapping{
+			Rider:     r.FormValue("rider_column"),
+			Bib:       r.FormValue("bib_column"),
+			Time:      r.FormValue("time_column"),
+			Status:    r.FormValue("status_column"),
+			Delimiter: ',',
+		}
+		switch r.FormValue("delimiter") {
+		case "semicolon":
+			mapping.Delimiter = ';'
+		case "tab":
+			mapping.Delimiter = '\t'
+		}
+		code, err := ImportRaceResultsCommand(ctx, conn, raceId, resultsFile, mapping)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		http.Redirect(w, r, raceDetailsUrl(raceId)+"/results", http.StatusSeeOther)
+	}
+}
+
 func updateRaceDescriptionRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -235,7 +563,18 @@ func updateRaceDescriptionRoute(conn *pgxpool.Pool) http.HandlerFunc {
 		if coverImageFile != nil {
 			defer coverImageFile.Close()
 		}
-		code, err := UpdateRaceDescriptionCommand(ctx, conn, raceId, coverImageFile != nil || clearCoverImage == "on", coverImageFile)
+		clearCourse := r.FormValue("clear_course")
+		courseFile, _, err := r.FormFile("course")
+		if err != nil && !errors.Is(err, http.ErrMissingFile) {
+			err = kcore.Wrap(err, "error parsing course")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if courseFile != nil {
+			defer courseFile.Close()
+		}
+		code, err := UpdateRaceDescriptionCommand(ctx, conn, raceId, coverImageFile != nil || clearCoverImage == "on", coverImageFile, clearCourse == "on", courseFile)
 		if err != nil {
 			http.Error(w, err.Error(), code)
 			return
@@ -245,7 +584,66 @@ func updateRaceDescriptionRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-func openRaceForRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+type raceCategoryForm struct {
+	Name                string
+	StartAt             time.Time
+	MaximumParticipants int
+	MinimumAge          int
+	MaximumAge          int
+	Bibs                BibRange
+}
+
+// parseOptionalInt reads an empty field as 0
+func parseOptionalInt(r *http.Request, field string) (int, error) {
+	if r.FormValue(field) == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(r.FormValue(field))
+	if err != nil {
+		return 0, kcore.Wrap(err, fmt.Sprintf("error parsing %s", field))
+	}
+	return value, nil
+}
+
+// parseOptionalTime reads a datetime-local field in the race location, and an empty field as the zero time
+func parseOptionalTime(r *http.Request, field string, location *time.Location) (time.Time, error) {
+	if r.FormValue(field) == "" {
+		return time.Time{}, nil
+	}
+	value, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue(field), location)
+	if err != nil {
+		return time.Time{}, kcore.Wrap(err, fmt.Sprintf("error parsing %s", field))
+	}
+	return value, nil
+}
+
+func parseRaceCategoryForm(r *http.Request, location *time.Location) (raceCategoryForm, error) {
+	form := raceCategoryForm{Name: r.FormValue("name")}
+	var err error
+	form.StartAt, err = parseOptionalTime(r, "start_at", location)
+	if err != nil {
+		return form, err
+	}
+	form.MaximumParticipants, err = strconv.Atoi(r.FormValue("maximum_participants"))
+	if err != nil {
+		return form, kcore.Wrap(err, "error parsing maximum_participants")
+	}
+	optionalFields := map[string]*int{
+		"minimum_age": &form.MinimumAge,
+		"maximum_age": &form.MaximumAge,
+		"first_bib":   &form.Bibs.First,
+		"last_bib":    &form.Bibs.Last,
+	}
+	for field, value := range optionalFields {
+		*value, err = parseOptionalInt(r, field)
+		if err != nil {
+			return form, err
+		}
+	}
+	return form, nil
+}
+
+func addRaceCategoryRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
@@ -255,27 +653,293 @@ func openRaceForRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		maximumParticipants, err := strconv.Atoi(r.FormValue("maximum_participants"))
+		location, code, err := RaceLocationQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		form, err := parseRaceCategoryForm(r, location)
 		if err != nil {
-			err = kcore.Wrap(err, "error parsing maximum_participants")
 			slog.Warn(err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		code, err := OpenRaceForRegistration(ctx, conn, raceId, maximumParticipants)
+		code, err = AddRaceCategoryCommand(ctx, conn, raceId, form.Name, form.StartAt, form.MaximumParticipants, form.MinimumAge, form.MaximumAge, form.Bibs)
 		if err != nil {
 			http.Error(w, err.Error(), code)
-			return
 		} else {
 			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
 		}
 	}
 }
 
-func organizeRaceRoute(conn *pgxpool.Pool) http.HandlerFunc {
+func updateRaceCategoryRoute(conn *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		code, err := OrganizeRaceCommand(ctx, conn, r.FormValue("name"))
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		categoryId, err := kcore.ParseID(chi.URLParam(r, "categoryId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing categoryId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		location, code, err := RaceLocationQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		form, err := parseRaceCategoryForm(r, location)
+		if err != nil {
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err = UpdateRaceCategoryCommand(ctx, conn, raceId, categoryId, form.Name, form.StartAt, form.MaximumParticipants, form.MinimumAge, form.MaximumAge, form.Bibs)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+func removeRaceCategoryRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		categoryId, err := kcore.ParseID(chi.URLParam(r, "categoryId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing categoryId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := RemoveRaceCategoryCommand(ctx, conn, raceId, categoryId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+func openRaceForRegistrationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		maximumParticipants, err := strconv.Atoi(r.FormValue("maximum_participants"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing maximum_participants")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		timezone := r.FormValue("timezone")
+		location, err := LoadRaceLocation(timezone)
+		if err != nil {
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		times := map[string]time.Time{}
+		for _, field := range []string{"start_at", "registration_opens_at", "registration_closes_at"} {
+			times[field], err = parseOptionalTime(r, field, location)
+			if err != nil {
+				slog.Warn(err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		code, err := OpenRaceForRegistration(ctx, conn, raceId, times["start_at"], timezone, maximumParticipants, times["registration_opens_at"], times["registration_closes_at"])
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+// raceCommandRoute serves the commands that only need the race, like the status changes
+func raceCommandRoute(conn *pgxpool.Pool, command func(context.Context, *pgxpool.Pool, kcore.ID) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := command(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+// raceUserCommandRoute serves the commands that only need the race and the user they apply to
+func raceUserCommandRoute(conn *pgxpool.Pool, command func(context.Context, *pgxpool.Pool, kcore.ID, kcore.ID) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userId, err := kcore.ParseID(chi.URLParam(r, "userId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing userId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := command(ctx, conn, raceId, userId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+func inviteOrganizerRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		role, err := ParseOrganizerRole(r.FormValue("role"))
+		if err != nil {
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := InviteOrganizerCommand(ctx, conn, raceId, r.FormValue("username"), role)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+// declineOrganizerInvitationRoute goes back to the list, as the race may be a draft the user cannot see
+func declineOrganizerInvitationRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := DeclineOrganizerInvitationCommand(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, "/races", http.StatusSeeOther)
+		}
+	}
+}
+
+func changeOrganizerRoleRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userId, err := kcore.ParseID(chi.URLParam(r, "userId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing userId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		role, err := ParseOrganizerRole(r.FormValue("role"))
+		if err != nil {
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := ChangeOrganizerRoleCommand(ctx, conn, raceId, userId, role)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+func assignRaceBibRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userId, err := kcore.ParseID(chi.URLParam(r, "userId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing userId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bib, err := strconv.Atoi(r.FormValue("bib"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing bib")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := AssignRaceBibCommand(ctx, conn, raceId, userId, bib)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, raceDetailsUrl(raceId), http.StatusSeeOther)
+		}
+	}
+}
+
+func organizeRaceRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		_, code, err := OrganizeRaceCommand(ctx, conn, r.FormValue("name"))
 		if err != nil {
 			http.Error(w, err.Error(), code)
 		} else {
@@ -283,3 +947,163 @@ func organizeRaceRoute(conn *pgxpool.Pool) http.HandlerFunc {
 		}
 	}
 }
+
+func recordRacePassagesRoute(conn *pgxpool.Pool, live *LiveTiming, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// Timing systems either send passages one by one, or in batches
+		var passages []Passage
+		body := json.NewDecoder(r.Body)
+		var raw json.RawMessage
+		err = body.Decode(&raw)
+		if err == nil && bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			err = json.Unmarshal(raw, &passages)
+		} else if err == nil {
+			var passage Passage
+			err = json.Unmarshal(raw, &passage)
+			passages = []Passage{passage}
+		}
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing passages")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := RecordRacePassagesCommand(ctx, conn, live, config.TimingSecret, raceId, token, passages)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		w.WriteHeader(code)
+	}
+}
+
+func revokeTimingTokenRoute(conn *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			slog.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := RevokeTimingTokenCommand(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+		} else {
+			http.Redirect(w, r, string(raceAction(raceId, "live")), http.StatusSeeOther)
+		}
+	}
+}
+
+func viewLiveLeaderboardRoute(conn *pgxpool.Pool, live *LiveTiming, config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		raceDetail, code, err := RaceDetailQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		entries, err := live.Leaderboard(ctx, raceId)
+		kcore.Expect(err, "error loading leaderboard")
+		var token string
+		if raceDetail.Permissions.CanManageTiming {
+			_, version, err := loadRaceTiming(ctx, conn, raceId)
+			kcore.Expect(err, "")
+			token = timingToken(config.TimingSecret, raceId, version)
+		}
+		login := auth.LoginFromContext(ctx)
+		page := LivePage(login, raceDetail, entries, token)
+		kcore.RenderPage(r.Context(), page, w)
+	}
+}
+
+func writeLeaderboardEvent(ctx context.Context, w http.ResponseWriter, login auth.Login, entries []LeaderboardEntry) error {
+	var buf bytes.Buffer
+	err := LiveLeaderboard(login, entries).Render(ctx, &buf)
+	if err != nil {
+		return kcore.Wrap(err, "error rendering leaderboard")
+	}
+	_, err = fmt.Fprint(w, "event: leaderboard\n")
+	if err != nil {
+		return kcore.Wrap(err, "error writing event")
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		_, err = fmt.Fprintf(w, "data: %s\n", line)
+		if err != nil {
+			return kcore.Wrap(err, "error writing event")
+		}
+	}
+	_, err = fmt.Fprint(w, "\n")
+	if err != nil {
+		return kcore.Wrap(err, "error writing event")
+	}
+	return nil
+}
+
+func liveLeaderboardEventsRoute(conn *pgxpool.Pool, live *LiveTiming) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		raceId, err := kcore.ParseID(chi.URLParam(r, "raceId"))
+		if err != nil {
+			err = kcore.Wrap(err, "error parsing raceId")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Subscribing keeps the leaderboard in memory, it is only allowed to those who can see the race
+		_, code, err := RaceDetailQuery(ctx, conn, raceId)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		entries, updates, unsubscribe, err := live.Subscribe(ctx, raceId)
+		kcore.Expect(err, "error subscribing to leaderboard")
+		defer unsubscribe()
+
+		controller := http.NewResponseController(w)
+		// The server write timeout is meant for pages, not for streams
+		kcore.Expect(controller.SetWriteDeadline(time.Time{}), "error removing write deadline")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		login := auth.LoginFromContext(ctx)
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			err = writeLeaderboardEvent(ctx, w, login, entries)
+			if err == nil {
+				err = controller.Flush()
+			}
+			if err != nil {
+				slog.Info("leaderboard stream closed", slog.String("raceId", raceId.String()), slog.String("reason", err.Error()))
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-updates:
+				if !ok {
+					slog.Info("leaderboard stream closed", slog.String("raceId", raceId.String()), slog.String("reason", "race ended"))
+					return
+				}
+				entries = update
+			case <-heartbeat.C:
+				// Sending the leaderboard again keeps proxies from closing idle connections
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package race
+
+import (
+	"bike_race/auth"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/martinlehoux/kagamigo/kcore"
+)
+
+type StartListEntry struct {
+	Bib      int
+	Rider    string
+	Category string
+	StartAt  time.Time
+}
+
+// startList keeps approved riders, ordered by wave then bib
+func startList(race RaceDetailModel, registrations []RaceRegistrationModel) []StartListEntry {
+	startAts := map[string]time.Time{}
+	for _, category := range race.Categories {
+		startAts[category.Name] = category.StartAt
+	}
+	entries := []StartListEntry{}
+	for _, registration := range registrations {
+		if registration.Status != Approved {
+			continue
+		}
+		startAt, ok := startAts[registration.Category]
+		if !ok {
+			startAt = race.StartAt
+		}
+		entries = append(entries, StartListEntry{Bib: registration.Bib, Rider: registration.User.Username, Category: registration.Category, StartAt: startAt})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].StartAt.Equal(entries[j].StartAt) {
+			return entries[i].StartAt.Before(entries[j].StartAt)
+		}
+		if entries[i].Category != entries[j].Category {
+			return entries[i].Category < entries[j].Category
+		}
+		return entries[i].Bib < entries[j].Bib
+	})
+	return entries
+}
+
+func formatStartAt(startAt time.Time, location *time.Location) string {
+	if startAt.IsZero() {
+		return ""
+	}
+	return startAt.In(location).Format("2006-01-02 15:04")
+}
+
+func writeStartListCSV(w io.Writer, login auth.Login, entries []StartListEntry, location *time.Location) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{login.Tr("bib"), login.Tr("user"), login.Tr("raceCategory"), login.Tr("raceStart")})
+	if err != nil {
+		return kcore.Wrap(err, "error writing start list header")
+	}
+	for _, entry := range entries {
+		err = writer.Write([]string{strconv.Itoa(entry.Bib), entry.Rider, entry.Category, formatStartAt(entry.StartAt, location)})
+		if err != nil {
+			return kcore.Wrap(err, "error writing start list row")
+		}
+	}
+	writer.Flush()
+	err = writer.Error()
+	if err != nil {
+		return kcore.Wrap(err, "error flushing start list")
+	}
+	return nil
+}
+
+// writeStartListPDF prints one table per wave, with core fonts that only cover latin characters.
+func writeStartListPDF(w io.Writer, login auth.Login, race RaceDetailModel, entries []StartListEntry, location *time.Location) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, translate(login.Tr("startList_title", race.Name)), "", 1, "L", false, 0, "")
+	wave := ""
+	for i, entry := range entries {
+		entryWave := entry.Category + " " + formatStartAt(entry.StartAt, location)
+		if i == 0 || entryWave != wave {
+			wave = entryWave
+			pdf.Ln(4)
+			pdf.SetFont("Helvetica", "B", 12)
+			pdf.CellFormat(0, 8, translate(wave), "B", 1, "L", false, 0, "")
+			pdf.SetFont("Helvetica", "", 11)
+		}
+		pdf.CellFormat(20, 7, strconv.Itoa(entry.Bib), "", 0, "R", false, 0, "")
+		pdf.CellFormat(5, 7, "", "", 0, "", false, 0, "")
+		pdf.CellFormat(0, 7, translate(entry.Rider), "", 1, "L", false, 0, "")
+	}
+	err := pdf.Output(w)
+	if err != nil {
+		return kcore.Wrap(err, "error writing start list pdf")
+	}
+	return nil
+}
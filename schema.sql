@@ -9,6 +9,17 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: race_organizers__role; Type: TYPE; Schema: public; Owner: -
+--
+
+CREATE TYPE public.race_organizers__role AS ENUM (
+    'owner',
+    'editor',
+    'document_reviewer'
+);
+
+
 --
 -- Name: race_registrations__status; Type: TYPE; Schema: public; Owner: -
 --
@@ -16,7 +27,35 @@ SET row_security = off;
 CREATE TYPE public.race_registrations__status AS ENUM (
     'registered',
     'submitted',
-    'approved'
+    'approved',
+    'waitlisted',
+    'rejected',
+    'withdrawn',
+    'cancelled'
+);
+
+
+--
+-- Name: race_results__status; Type: TYPE; Schema: public; Owner: -
+--
+
+CREATE TYPE public.race_results__status AS ENUM (
+    'finished',
+    'dnf',
+    'dns',
+    'dsq'
+);
+
+
+--
+-- Name: races__status; Type: TYPE; Schema: public; Owner: -
+--
+
+CREATE TYPE public.races__status AS ENUM (
+    'draft',
+    'published',
+    'cancelled',
+    'finished'
 );
 
 
@@ -24,13 +63,164 @@ SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: api_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.api_tokens (
+    id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    scope text NOT NULL,
+    token_hash bytea NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    last_used_at timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+
+
+--
+-- Name: authentication_audit_events; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.authentication_audit_events (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id uuid NOT NULL,
+    username text NOT NULL,
+    ip text NOT NULL,
+    kind text NOT NULL,
+    created_at timestamp with time zone NOT NULL
+);
+
+
+--
+-- Name: email_verification_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.email_verification_tokens (
+    token_hash bytea NOT NULL,
+    user_id uuid NOT NULL,
+    email character varying(255) NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+
+--
+-- Name: login_throttles; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.login_throttles (
+    scope text NOT NULL,
+    key text NOT NULL,
+    failures integer NOT NULL,
+    last_failed_at timestamp with time zone NOT NULL
+);
+
+
+--
+-- Name: medical_certificate_purges; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.medical_certificate_purges (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    race_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    medical_certificate text NOT NULL,
+    purged_at timestamp with time zone NOT NULL
+);
+
+
+--
+-- Name: notifications; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.notifications (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id uuid NOT NULL,
+    race_id uuid NOT NULL,
+    kind text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    sent_at timestamp with time zone
+);
+
+
+--
+-- Name: password_reset_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.password_reset_tokens (
+    token_hash bytea NOT NULL,
+    user_id uuid NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+
+--
+-- Name: pending_logins; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.pending_logins (
+    token_hash bytea NOT NULL,
+    user_id uuid NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: race_categories; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.race_categories (
+    id uuid NOT NULL,
+    race_id uuid NOT NULL,
+    name character varying(255) NOT NULL,
+    start_at timestamp with time zone NOT NULL,
+    maximum_participants integer NOT NULL,
+    minimum_age integer DEFAULT 0 NOT NULL,
+    maximum_age integer DEFAULT 0 NOT NULL,
+    first_bib integer DEFAULT 0 NOT NULL,
+    last_bib integer DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: race_organizer_invitations; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.race_organizer_invitations (
+    race_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role public.race_organizers__role NOT NULL,
+    invited_at timestamp with time zone NOT NULL
+);
+
+
 --
 -- Name: race_organizers; Type: TABLE; Schema: public; Owner: -
 --
 
 CREATE TABLE public.race_organizers (
     race_id uuid NOT NULL,
-    user_id uuid NOT NULL
+    user_id uuid NOT NULL,
+    role public.race_organizers__role NOT NULL
+);
+
+
+--
+-- Name: race_passages; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.race_passages (
+    race_id uuid NOT NULL,
+    bib character varying(32) NOT NULL,
+    checkpoint character varying(64) NOT NULL,
+    passed_at timestamp with time zone NOT NULL,
+    received_at timestamp with time zone NOT NULL
 );
 
 
@@ -44,7 +234,23 @@ CREATE TABLE public.race_registrations (
     registered_at timestamp with time zone NOT NULL,
     status public.race_registrations__status NOT NULL,
     is_medical_certificate_approved boolean NOT NULL,
-    medical_certificate text
+    medical_certificate text,
+    rejection_reason text,
+    category_id uuid,
+    bib integer
+);
+
+
+--
+-- Name: race_results; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.race_results (
+    race_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    status public.race_results__status NOT NULL,
+    finish_time_ms bigint,
+    rank integer
 );
 
 
@@ -58,7 +264,20 @@ CREATE TABLE public.races (
     start_at timestamp with time zone NOT NULL,
     is_open_for_registration boolean NOT NULL,
     maximum_participants integer DEFAULT 0 NOT NULL,
-    cover_image_id uuid
+    cover_image_id uuid,
+    version integer DEFAULT 0 NOT NULL,
+    course_file text,
+    course_distance double precision DEFAULT 0 NOT NULL,
+    course_elevation_gain double precision DEFAULT 0 NOT NULL,
+    course_elevation_loss double precision DEFAULT 0 NOT NULL,
+    course_max_gradient double precision DEFAULT 0 NOT NULL,
+    timezone text DEFAULT 'Europe/Paris'::text NOT NULL,
+    registration_opens_at timestamp with time zone,
+    registration_closes_at timestamp with time zone,
+    status public.races__status NOT NULL,
+    course_profile text DEFAULT ''::text NOT NULL,
+    course_outline text DEFAULT ''::text NOT NULL,
+    timing_token_version integer DEFAULT 0 NOT NULL
 );
 
 
@@ -71,6 +290,46 @@ CREATE TABLE public.schema_migrations (
 );
 
 
+--
+-- Name: sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.sessions (
+    id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    user_agent text NOT NULL,
+    ip text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    last_seen_at timestamp with time zone NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    revoked_at timestamp with time zone,
+    is_second_factor_verified boolean DEFAULT false NOT NULL
+);
+
+
+--
+-- Name: totp_recovery_codes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.totp_recovery_codes (
+    user_id uuid NOT NULL,
+    code_hash bytea NOT NULL,
+    used_at timestamp with time zone
+);
+
+
+--
+-- Name: user_identities; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.user_identities (
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    user_id uuid NOT NULL,
+    created_at timestamp with time zone NOT NULL
+);
+
+
 --
 -- Name: users; Type: TABLE; Schema: public; Owner: -
 --
@@ -79,10 +338,118 @@ CREATE TABLE public.users (
     id uuid NOT NULL,
     username character varying(255) NOT NULL,
     password_hash bytea NOT NULL,
-    language character varying(10) NOT NULL
+    language character varying(10) NOT NULL,
+    is_admin boolean DEFAULT false NOT NULL,
+    is_disabled boolean DEFAULT false NOT NULL,
+    email character varying(255),
+    locked_until timestamp with time zone,
+    totp_secret bytea,
+    totp_enabled boolean DEFAULT false NOT NULL,
+    totp_last_step bigint DEFAULT 0 NOT NULL,
+    email_verified_at timestamp with time zone,
+    full_name character varying(255) DEFAULT ''::character varying NOT NULL,
+    birth_date date,
+    gender text DEFAULT ''::text NOT NULL,
+    phone character varying(32) DEFAULT ''::character varying NOT NULL
 );
 
 
+--
+-- Name: api_tokens api_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: api_tokens api_tokens_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: authentication_audit_events authentication_audit_events_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.authentication_audit_events
+    ADD CONSTRAINT authentication_audit_events_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: email_verification_tokens email_verification_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.email_verification_tokens
+    ADD CONSTRAINT email_verification_tokens_pkey PRIMARY KEY (token_hash);
+
+
+--
+-- Name: login_throttles login_throttles_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.login_throttles
+    ADD CONSTRAINT login_throttles_pkey PRIMARY KEY (scope, key);
+
+
+--
+-- Name: medical_certificate_purges medical_certificate_purges_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.medical_certificate_purges
+    ADD CONSTRAINT medical_certificate_purges_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: notifications notifications_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.notifications
+    ADD CONSTRAINT notifications_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: password_reset_tokens password_reset_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.password_reset_tokens
+    ADD CONSTRAINT password_reset_tokens_pkey PRIMARY KEY (token_hash);
+
+
+--
+-- Name: pending_logins pending_logins_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.pending_logins
+    ADD CONSTRAINT pending_logins_pkey PRIMARY KEY (token_hash);
+
+
+--
+-- Name: race_categories race_categories_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_categories
+    ADD CONSTRAINT race_categories_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: race_categories race_categories_race_id_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_categories
+    ADD CONSTRAINT race_categories_race_id_name_key UNIQUE (race_id, name);
+
+
+--
+-- Name: race_organizer_invitations race_organizer_invitations_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_organizer_invitations
+    ADD CONSTRAINT race_organizer_invitations_pkey PRIMARY KEY (race_id, user_id);
+
+
 --
 -- Name: race_organizers race_organizers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -91,6 +458,14 @@ ALTER TABLE ONLY public.race_organizers
     ADD CONSTRAINT race_organizers_pkey PRIMARY KEY (race_id, user_id);
 
 
+--
+-- Name: race_passages race_passages_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_passages
+    ADD CONSTRAINT race_passages_pkey PRIMARY KEY (race_id, bib, checkpoint);
+
+
 --
 -- Name: race_registrations race_registered_users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -99,6 +474,22 @@ ALTER TABLE ONLY public.race_registrations
     ADD CONSTRAINT race_registered_users_pkey PRIMARY KEY (race_id, user_id);
 
 
+--
+-- Name: race_registrations race_registrations_race_id_bib_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_registrations
+    ADD CONSTRAINT race_registrations_race_id_bib_key UNIQUE (race_id, bib) DEFERRABLE INITIALLY DEFERRED;
+
+
+--
+-- Name: race_results race_results_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_results
+    ADD CONSTRAINT race_results_pkey PRIMARY KEY (race_id, user_id);
+
+
 --
 -- Name: races races_name_key; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -123,6 +514,30 @@ ALTER TABLE ONLY public.schema_migrations
     ADD CONSTRAINT schema_migrations_pkey PRIMARY KEY (version);
 
 
+--
+-- Name: sessions sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sessions
+    ADD CONSTRAINT sessions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: totp_recovery_codes totp_recovery_codes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.totp_recovery_codes
+    ADD CONSTRAINT totp_recovery_codes_pkey PRIMARY KEY (user_id, code_hash);
+
+
+--
+-- Name: user_identities user_identities_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_pkey PRIMARY KEY (issuer, subject);
+
+
 --
 -- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -139,6 +554,142 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_username_key UNIQUE (username);
 
 
+--
+-- Name: api_tokens_user_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX api_tokens_user_id_idx ON public.api_tokens USING btree (user_id);
+
+
+--
+-- Name: authentication_audit_events_user_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX authentication_audit_events_user_id_idx ON public.authentication_audit_events USING btree (user_id);
+
+
+--
+-- Name: medical_certificate_purges_race_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX medical_certificate_purges_race_id_idx ON public.medical_certificate_purges USING btree (race_id);
+
+
+--
+-- Name: notifications_pending_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX notifications_pending_idx ON public.notifications USING btree (created_at) WHERE (sent_at IS NULL);
+
+
+--
+-- Name: races_registration_closes_at_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX races_registration_closes_at_idx ON public.races USING btree (registration_closes_at) WHERE is_open_for_registration;
+
+
+--
+-- Name: sessions_user_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX sessions_user_id_idx ON public.sessions USING btree (user_id);
+
+
+--
+-- Name: user_identities_user_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX user_identities_user_id_idx ON public.user_identities USING btree (user_id);
+
+
+--
+-- Name: users_email_verified_key; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX users_email_verified_key ON public.users USING btree (email) WHERE (email_verified_at IS NOT NULL);
+
+
+--
+-- Name: api_tokens api_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: email_verification_tokens email_verification_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.email_verification_tokens
+    ADD CONSTRAINT email_verification_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: medical_certificate_purges medical_certificate_purges_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.medical_certificate_purges
+    ADD CONSTRAINT medical_certificate_purges_race_id_fkey FOREIGN KEY (race_id) REFERENCES public.races(id);
+
+
+--
+-- Name: notifications notifications_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.notifications
+    ADD CONSTRAINT notifications_race_id_fkey FOREIGN KEY (race_id) REFERENCES public.races(id);
+
+
+--
+-- Name: notifications notifications_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.notifications
+    ADD CONSTRAINT notifications_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: password_reset_tokens password_reset_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.password_reset_tokens
+    ADD CONSTRAINT password_reset_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: pending_logins pending_logins_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.pending_logins
+    ADD CONSTRAINT pending_logins_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: race_categories race_categories_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_categories
+    ADD CONSTRAINT race_categories_race_id_fkey FOREIGN KEY (race_id) REFERENCES public.races(id);
+
+
+--
+-- Name: race_organizer_invitations race_organizer_invitations_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_organizer_invitations
+    ADD CONSTRAINT race_organizer_invitations_race_id_fkey FOREIGN KEY (race_id) REFERENCES public.races(id);
+
+
+--
+-- Name: race_organizer_invitations race_organizer_invitations_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_organizer_invitations
+    ADD CONSTRAINT race_organizer_invitations_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
 --
 -- Name: race_organizers race_organizers_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
@@ -155,6 +706,14 @@ ALTER TABLE ONLY public.race_organizers
     ADD CONSTRAINT race_organizers_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
 
 
+--
+-- Name: race_passages race_passages_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_passages
+    ADD CONSTRAINT race_passages_race_id_fkey FOREIGN KEY (race_id) REFERENCES public.races(id);
+
+
 --
 -- Name: race_registrations race_registered_users_race_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
@@ -171,6 +730,46 @@ ALTER TABLE ONLY public.race_registrations
     ADD CONSTRAINT race_registered_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
 
 
+--
+-- Name: race_registrations race_registrations_category_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_registrations
+    ADD CONSTRAINT race_registrations_category_id_fkey FOREIGN KEY (category_id) REFERENCES public.race_categories(id);
+
+
+--
+-- Name: race_results race_results_race_id_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.race_results
+    ADD CONSTRAINT race_results_race_id_user_id_fkey FOREIGN KEY (race_id, user_id) REFERENCES public.race_registrations(race_id, user_id);
+
+
+--
+-- Name: sessions sessions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sessions
+    ADD CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: totp_recovery_codes totp_recovery_codes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.totp_recovery_codes
+    ADD CONSTRAINT totp_recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: user_identities user_identities_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
 --
 -- PostgreSQL database dump complete
 --
@@ -191,4 +790,29 @@ INSERT INTO public.schema_migrations (version) VALUES
     ('20230527172746'),
     ('20230601171320'),
     ('20230622171111'),
-    ('20230623071721');
+    ('20230623071721'),
+    ('20261018090000'),
+    ('20261018100000'),
+    ('20261018110000'),
+    ('20261018120000'),
+    ('20261018130000'),
+    ('20261018140000'),
+    ('20261018150000'),
+    ('20261018160000'),
+    ('20261018170000'),
+    ('20261018180000'),
+    ('20261018190000'),
+    ('20261018200000'),
+    ('20261018210000'),
+    ('20261018220000'),
+    ('20261018230000'),
+    ('20261019000000'),
+    ('20261019010000'),
+    ('20261019020000'),
+    ('20261019030000'),
+    ('20261019040000'),
+    ('20261019050000'),
+    ('20261019060000'),
+    ('20261019070000'),
+    ('20261019080000'),
+    ('20261019090000');